@@ -0,0 +1,171 @@
+// A simple Sigsum log server, intended for testing and small
+// deployments. Leaves and tree heads are optionally persisted to a
+// local directory.
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/pborman/getopt/v2"
+
+	"sigsum.org/sigsum-go/internal/version"
+	"sigsum.org/sigsum-go/pkg/api"
+	"sigsum.org/sigsum-go/pkg/client"
+	"sigsum.org/sigsum-go/pkg/collector"
+	"sigsum.org/sigsum-go/pkg/key"
+	"sigsum.org/sigsum-go/pkg/log"
+	"sigsum.org/sigsum-go/pkg/logserver"
+	"sigsum.org/sigsum-go/pkg/policy"
+	"sigsum.org/sigsum-go/pkg/server"
+)
+
+type Settings struct {
+	keyFile     string
+	policyFile  string
+	stateDir    string
+	prefix      string
+	interval    time.Duration
+	diagnostics string
+	hostAndPort string
+}
+
+func main() {
+	var settings Settings
+	settings.parse(os.Args)
+	if err := log.SetLevelFromString(settings.diagnostics); err != nil {
+		log.Fatal("%v", err)
+	}
+
+	signer, err := key.ReadPrivateKeyFile(settings.keyFile)
+	if err != nil {
+		log.Fatal("reading key file failed: %v", err)
+	}
+	var witnesses *collector.Collector
+	if len(settings.policyFile) > 0 {
+		policy, err := policy.ReadPolicyFile(settings.policyFile)
+		if err != nil {
+			log.Fatal("failed to create policy: %v", err)
+		}
+		var stateFile string
+		if len(settings.stateDir) > 0 {
+			stateFile = filepath.Join(settings.stateDir, "witness-state")
+		}
+		publicKey := signer.Public()
+		witnesses, err = collector.New(&collector.Config{StateFile: stateFile}, policy, &publicKey,
+			func(url string) api.Witness {
+				return client.New(client.Config{UserAgent: "sigsum-log", URL: url})
+			})
+		if err != nil {
+			log.Fatal("creating witness collector failed: %v", err)
+		}
+	}
+	sigsumLog, err := logserver.New(&logserver.Config{
+		Signer:    signer,
+		Directory: settings.stateDir,
+		Interval:  settings.interval,
+		Collector: witnesses,
+	})
+	if err != nil {
+		log.Fatal("creating log failed: %v", err)
+	}
+	defer sigsumLog.Close()
+
+	httpServer := http.Server{
+		Addr:    settings.hostAndPort,
+		Handler: server.NewLog(&server.Config{Prefix: settings.prefix}, sigsumLog),
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		sigsumLog.Run(ctx)
+	}()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		err := httpServer.ListenAndServe()
+		if err != http.ErrServerClosed {
+			log.Fatal("%v", err)
+		}
+	}()
+
+	<-ctx.Done()
+
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer shutdownCancel()
+
+	httpServer.Shutdown(shutdownCtx)
+}
+
+func (s *Settings) parse(args []string) {
+	const usage = `
+Provides a Sigsum log, listening on the given host and port.
+
+New leaves are added to the tree, and a new signed tree head is
+published, at the interval specified by the --interval option. If
+the --state-directory option is provided, leaves and tree heads are
+stored in that directory, and loaded on startup. Otherwise, all
+state is kept in memory only.
+
+If the --policy option is provided, cosignatures are collected from
+all witnesses in the policy file that have a URL, and a new tree
+head is published only when the cosignatures satisfy the policy's
+quorum.
+
+Note that rate limiting is not implemented; any Sigsum-Token
+headers on add-leaf requests are ignored.
+`
+	set := getopt.New()
+	set.SetParameters("host:port")
+	set.SetUsage(func() { fmt.Print(usage) })
+
+	help := false
+	versionFlag := false
+	s.diagnostics = "info"
+	s.interval = logserver.DefaultInterval
+
+	set.FlagLong(&s.keyFile, "signing-key", 'k', "Log private key", "file").Mandatory()
+	set.FlagLong(&s.policyFile, "policy", 'p', "Sigsum policy", "file")
+	set.FlagLong(&s.stateDir, "state-directory", 0, "Directory for persistent log state", "directory")
+	set.FlagLong(&s.prefix, "url-prefix", 0, "Prefix preceding the endpoint names", "string")
+	set.FlagLong(&s.interval, "interval", 0, "Interval for publishing new tree heads")
+	set.FlagLong(&s.diagnostics, "diagnostics", 0, "One of \"fatal\", \"error\", \"warning\", \"info\", or \"debug\"", "level")
+	set.FlagLong(&help, "help", 0, "Display help")
+	set.FlagLong(&versionFlag, "version", 'v', "Display software version")
+	err := set.Getopt(args, nil)
+	// Check --help and --version first; if seen, ignore errors
+	// about missing mandatory arguments.
+	if help {
+		set.PrintUsage(os.Stdout)
+		fmt.Print(usage)
+		os.Exit(0)
+	}
+	if versionFlag {
+		version.DisplayVersion("sigsum-log")
+		os.Exit(0)
+	}
+	if err != nil {
+		fmt.Printf("err: %v\n", err)
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(1)
+	}
+	if set.NArgs() != 1 {
+		log.Fatal("Mandatory HOST:PORT argument missing")
+	}
+	s.hostAndPort = set.Arg(0)
+}
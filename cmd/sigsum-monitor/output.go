@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"sigsum.org/sigsum-go/pkg/crypto"
+	"sigsum.org/sigsum-go/pkg/log"
+	"sigsum.org/sigsum-go/pkg/monitor"
+	"sigsum.org/sigsum-go/pkg/types"
+)
+
+// Formats monitor events. Methods may be called concurrently, from
+// the per-log goroutines.
+type output interface {
+	treeHead(logKeyHash crypto.Hash, cth *types.CosignedTreeHead)
+	leaves(logKeyHash crypto.Hash, numberOfProcessedLeaves uint64, indices []uint64, leaves []types.Leaf)
+	alert(logKeyHash crypto.Hash, err error)
+}
+
+func newOutput(format string, w io.Writer) (output, error) {
+	switch format {
+	case "text":
+		return &textOutput{w: w}, nil
+	case "json":
+		return &jsonOutput{enc: json.NewEncoder(w)}, nil
+	default:
+		return nil, fmt.Errorf("unknown output format %q", format)
+	}
+}
+
+// Free-form text, where any alert terminates the process.
+type textOutput struct {
+	m sync.Mutex
+	w io.Writer
+}
+
+func (o *textOutput) treeHead(logKeyHash crypto.Hash, cth *types.CosignedTreeHead) {
+	o.m.Lock()
+	defer o.m.Unlock()
+	fmt.Fprintf(o.w, "New %x tree, size %d, cosignatures %d\n", logKeyHash, cth.Size, len(cth.Cosignatures))
+}
+
+func (o *textOutput) leaves(logKeyHash crypto.Hash, numberOfProcessedLeaves uint64, indices []uint64, leaves []types.Leaf) {
+	o.m.Lock()
+	defer o.m.Unlock()
+	fmt.Fprintf(o.w, "New %x leaves, count %d, total processed %d\n", logKeyHash, len(leaves), numberOfProcessedLeaves)
+	for i, l := range leaves {
+		fmt.Fprintf(o.w, "  index %d keyhash %x checksum %x\n", indices[i], l.KeyHash, l.Checksum)
+	}
+}
+
+func (_ *textOutput) alert(logKeyHash crypto.Hash, err error) {
+	log.Fatal("Alert log %x: %v\n", logKeyHash, err)
+}
+
+// One json object per line and event. Alerts are reported as
+// events, and monitoring continues.
+type jsonOutput struct {
+	m   sync.Mutex
+	enc *json.Encoder
+}
+
+// Common fields of all events. Hashes are lowercase hex.
+type jsonEvent struct {
+	Type       string `json:"type"`
+	Time       string `json:"time"`
+	LogKeyHash string `json:"log_key_hash"`
+}
+
+type jsonTreeHeadEvent struct {
+	jsonEvent
+	Size         uint64 `json:"size"`
+	RootHash     string `json:"root_hash"`
+	Cosignatures int    `json:"cosignatures"`
+}
+
+type jsonLeafEvent struct {
+	jsonEvent
+	Index     uint64 `json:"index"`
+	KeyHash   string `json:"key_hash"`
+	Checksum  string `json:"checksum"`
+	Signature string `json:"signature"`
+}
+
+type jsonProgressEvent struct {
+	jsonEvent
+	NextLeafIndex uint64 `json:"next_leaf_index"`
+}
+
+type jsonAlertEvent struct {
+	jsonEvent
+	AlertType monitor.AlertType `json:"alert_type"`
+	Message   string            `json:"message"`
+}
+
+func newJSONEvent(eventType string, logKeyHash *crypto.Hash) jsonEvent {
+	return jsonEvent{
+		Type:       eventType,
+		Time:       time.Now().UTC().Format(time.RFC3339),
+		LogKeyHash: fmt.Sprintf("%x", *logKeyHash),
+	}
+}
+
+func (o *jsonOutput) emit(events ...interface{}) {
+	o.m.Lock()
+	defer o.m.Unlock()
+	for _, e := range events {
+		if err := o.enc.Encode(e); err != nil {
+			log.Fatal("Writing output failed: %v", err)
+		}
+	}
+}
+
+func (o *jsonOutput) treeHead(logKeyHash crypto.Hash, cth *types.CosignedTreeHead) {
+	o.emit(jsonTreeHeadEvent{
+		jsonEvent:    newJSONEvent("tree_head", &logKeyHash),
+		Size:         cth.Size,
+		RootHash:     fmt.Sprintf("%x", cth.RootHash),
+		Cosignatures: len(cth.Cosignatures),
+	})
+}
+
+func (o *jsonOutput) leaves(logKeyHash crypto.Hash, numberOfProcessedLeaves uint64, indices []uint64, leaves []types.Leaf) {
+	events := make([]interface{}, 0, len(leaves)+1)
+	for i, l := range leaves {
+		events = append(events, jsonLeafEvent{
+			jsonEvent: newJSONEvent("leaf", &logKeyHash),
+			Index:     indices[i],
+			KeyHash:   fmt.Sprintf("%x", l.KeyHash),
+			Checksum:  fmt.Sprintf("%x", l.Checksum),
+			Signature: fmt.Sprintf("%x", l.Signature),
+		})
+	}
+	events = append(events, jsonProgressEvent{
+		jsonEvent:     newJSONEvent("progress", &logKeyHash),
+		NextLeafIndex: numberOfProcessedLeaves,
+	})
+	o.emit(events...)
+}
+
+func (o *jsonOutput) alert(logKeyHash crypto.Hash, err error) {
+	alertType := monitor.AlertOther
+	var alert *monitor.Alert
+	if errors.As(err, &alert) {
+		alertType = alert.Type
+		err = alert.Err
+	}
+	o.emit(jsonAlertEvent{
+		jsonEvent: newJSONEvent("alert", &logKeyHash),
+		AlertType: alertType,
+		Message:   err.Error(),
+	})
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"time"
+
+	"sigsum.org/sigsum-go/pkg/log"
+	"sigsum.org/sigsum-go/pkg/monitor"
+	"sigsum.org/sigsum-go/pkg/submit"
+)
+
+// Reads pending submission files, as written by sigsum-submit
+// --async, from a directory, and records the submissions, so that the
+// monitor can alert if a log doesn't include a leaf it has accepted.
+type pendingReader struct {
+	dir         string
+	submissions *monitor.Submissions
+	// Files already read, with their modification times.
+	seen map[string]time.Time
+}
+
+func newPendingReader(dir string) *pendingReader {
+	return &pendingReader{dir: dir, submissions: monitor.NewSubmissions()}
+}
+
+// Reads any new or modified files. Files that fail to parse are
+// retried on the next call.
+func (r *pendingReader) scan() {
+	files, err := filepath.Glob(filepath.Join(r.dir, "*.pending"))
+	if err != nil {
+		log.Error("Listing pending submissions failed: %v", err)
+		return
+	}
+	seen := make(map[string]time.Time)
+	for _, name := range files {
+		info, err := os.Stat(name)
+		if err != nil {
+			// Likely removed by sigsum-submit --collect.
+			continue
+		}
+		if t, ok := r.seen[name]; ok && t.Equal(info.ModTime()) {
+			seen[name] = t
+			continue
+		}
+		var ps submit.PendingSubmission
+		if err := readPendingSubmission(name, &ps); err != nil {
+			log.Warning("Reading pending submission %q failed: %v", name, err)
+			continue
+		}
+		leaf, err := ps.Leaf.Verify()
+		if err != nil {
+			log.Warning("Invalid leaf in pending submission %q: %v", name, err)
+			continue
+		}
+		leafHash := leaf.ToHash()
+		for _, logKeyHash := range ps.Logs {
+			r.submissions.Add(logKeyHash, leafHash, ps.SubmitTime)
+		}
+		seen[name] = info.ModTime()
+	}
+	r.seen = seen
+}
+
+func readPendingSubmission(name string, ps *submit.PendingSubmission) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return ps.FromASCII(f)
+}
+
+// Scans the directory at the end of each interval, until the context
+// expires.
+func (r *pendingReader) run(ctx context.Context, interval time.Duration) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+			r.scan()
+		}
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
 	"time"
 
@@ -24,23 +25,57 @@ type Settings struct {
 	keys        []string
 	diagnostics string
 	interval    time.Duration
+	stateDir    string
+	maxAge      time.Duration
+	format      string
+	pendingDir  string
+	maxDelay    time.Duration
 }
 
-type callbacks struct{}
+type callbacks struct {
+	out output
 
-func (_ callbacks) NewTreeHead(logKeyHash crypto.Hash, signedTreeHead types.SignedTreeHead) {
-	fmt.Printf("New %x tree, size %d\n", logKeyHash, signedTreeHead.Size)
+	// Nil if state isn't persisted.
+	stateDir *monitor.StateDirectory
+
+	// Protects the state map, which is updated by the per-log
+	// goroutines.
+	m     sync.Mutex
+	state map[crypto.Hash]monitor.StoredState
 }
 
-func (_ callbacks) NewLeaves(logKeyHash crypto.Hash, numberOfProcessedLeaves uint64, indices []uint64, leaves []types.Leaf) {
-	fmt.Printf("New %x leaves, count %d, total processed %d\n", logKeyHash, len(leaves), numberOfProcessedLeaves)
-	for i, l := range leaves {
-		fmt.Printf("  index %d keyhash %x checksum %x\n", indices[i], l.KeyHash, l.Checksum)
+// Records updated state for a log, and stores it, if a state
+// directory is configured.
+func (c *callbacks) updateState(logKeyHash crypto.Hash, update func(*monitor.StoredState)) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	state := c.state[logKeyHash]
+	update(&state)
+	c.state[logKeyHash] = state
+	if c.stateDir != nil {
+		if err := c.stateDir.Store(&logKeyHash, &state); err != nil {
+			log.Fatal("Storing state for log %x failed: %v", logKeyHash, err)
+		}
 	}
 }
 
-func (_ callbacks) Alert(logKeyHash crypto.Hash, e error) {
-	log.Fatal("Alert log %x: %v\n", logKeyHash, e)
+func (c *callbacks) NewTreeHead(logKeyHash crypto.Hash, cosignedTreeHead types.CosignedTreeHead) {
+	c.out.treeHead(logKeyHash, &cosignedTreeHead)
+	c.updateState(logKeyHash, func(state *monitor.StoredState) {
+		state.SignedTreeHead = cosignedTreeHead.SignedTreeHead
+	})
+}
+
+func (c *callbacks) NewLeaves(logKeyHash crypto.Hash, numberOfProcessedLeaves uint64, indices []uint64, leaves []types.Leaf) {
+	c.out.leaves(logKeyHash, numberOfProcessedLeaves, indices, leaves)
+	c.updateState(logKeyHash, func(state *monitor.StoredState) {
+		state.NextLeafIndex = numberOfProcessedLeaves
+	})
+}
+
+func (c *callbacks) Alert(logKeyHash crypto.Hash, e error) {
+	c.out.alert(logKeyHash, e)
 }
 
 func main() {
@@ -53,9 +88,16 @@ func main() {
 	if err != nil {
 		log.Fatal("failed to create policy: %v", err)
 	}
+	out, err := newOutput(settings.format, os.Stdout)
+	if err != nil {
+		log.Fatal("%v", err)
+	}
+	callbacks := callbacks{out: out, state: make(map[crypto.Hash]monitor.StoredState)}
 	config := monitor.Config{
-		QueryInterval: settings.interval,
-		Callbacks:     callbacks{},
+		QueryInterval:      settings.interval,
+		Callbacks:          &callbacks,
+		MaxCosignatureAge:  settings.maxAge,
+		MaxSubmissionDelay: settings.maxDelay,
 	}
 	if len(settings.keys) > 0 {
 		config.SubmitKeys = make(map[crypto.Hash]crypto.PublicKey)
@@ -67,14 +109,34 @@ func main() {
 			config.SubmitKeys[crypto.HashBytes(pub[:])] = pub
 		}
 	}
-	// TODO: Read state from disk. Also store the list of submit
-	// keys, and discard state if keys are added, since whenever
-	// new keys are added, the log must be rescanned from the
-	// start.
+	var state map[crypto.Hash]monitor.MonitorState
+	if len(settings.stateDir) > 0 {
+		// Whenever new keys are added, state is reset so that
+		// logs are rescanned from the start.
+		stateDir, storedState, err := monitor.OpenStateDirectory(settings.stateDir, config.SubmitKeys)
+		if err != nil {
+			log.Fatal("Failed reading state: %v", err)
+		}
+		callbacks.stateDir = stateDir
+		state = make(map[crypto.Hash]monitor.MonitorState)
+		for logKeyHash, s := range storedState {
+			callbacks.state[logKeyHash] = s
+			state[logKeyHash] = s.MonitorState()
+		}
+	}
 	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer cancel()
 
-	done := monitor.StartMonitoring(ctx, policy, &config, nil)
+	if len(settings.pendingDir) > 0 {
+		pending := newPendingReader(settings.pendingDir)
+		// Read files before monitoring starts, so that
+		// existing submissions are known from the start.
+		pending.scan()
+		config.Submissions = pending.submissions
+		go pending.run(ctx, settings.interval)
+	}
+
+	done := monitor.StartMonitoring(ctx, policy, &config, state)
 	<-done
 }
 
@@ -86,9 +148,15 @@ func (s *Settings) parse(args []string) {
 	versionFlag := false
 	s.diagnostics = "info"
 	s.interval = 10 * time.Minute
+	s.format = "text"
 
 	set.FlagLong(&s.policyFile, "policy", 'p', "Sigsum policy", "file").Mandatory()
 	set.FlagLong(&s.interval, "interval", 0, "Monitoring interval")
+	set.FlagLong(&s.maxAge, "max-cosignature-age", 0, "Alert if the newest cosignatures satisfying the quorum are older (default 1h, negative to disable)", "duration")
+	set.FlagLong(&s.format, "format", 0, "Output format, \"text\" or \"json\"", "format")
+	set.FlagLong(&s.stateDir, "state-directory", 0, "Directory for persistent monitor state", "directory")
+	set.FlagLong(&s.pendingDir, "pending-submissions", 0, "Directory with pending submission files, as written by sigsum-submit --async", "directory")
+	set.FlagLong(&s.maxDelay, "max-submission-delay", 0, "Alert if a log doesn't include a pending submission within this delay (default 10m)", "duration")
 	set.FlagLong(&s.diagnostics, "diagnostics", 0, "One of \"fatal\", \"error\", \"warning\", \"info\", or \"debug\"", "level")
 	set.FlagLong(&help, "help", 0, "Display help")
 	set.FlagLong(&versionFlag, "version", 'v', "Display software version")
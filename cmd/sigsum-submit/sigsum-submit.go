@@ -9,6 +9,7 @@ import (
 	"net"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"time"
 
@@ -39,6 +40,9 @@ type Settings struct {
 	tokenDomain  string
 	tokenKeyFile string
 	timeout      time.Duration
+	requiredLogs int
+	async        bool
+	collect      bool
 }
 
 // Empty name for stdin
@@ -124,6 +128,7 @@ func main() {
 		config := submit.Config{Policy: policy,
 			Domain:        settings.tokenDomain,
 			PerLogTimeout: settings.timeout,
+			RequiredLogs:  settings.requiredLogs,
 		}
 		ctx := context.Background()
 
@@ -139,11 +144,9 @@ func main() {
 			}
 		}
 
-		skip := func(inputName string, msg *crypto.Hash, publicKey *crypto.PublicKey) bool {
-			if len(inputName) == 0 {
-				return false
-			}
-			proofName := settings.getOutputFile(inputName, ".proof")
+		// Returns true if there's a valid proof, false if
+		// the proof file doesn't exist.
+		checkProofFile := func(proofName string, msg *crypto.Hash, publicKey *crypto.PublicKey) bool {
 			f, err := os.Open(proofName)
 			if errors.Is(err, fs.ErrNotExist) {
 				return false
@@ -162,6 +165,33 @@ func main() {
 			}
 			return true
 		}
+		if settings.collect {
+			settings.collectProofs(ctx, &config)
+			return
+		}
+
+		skip := func(inputName string, msg *crypto.Hash, publicKey *crypto.PublicKey) bool {
+			if len(inputName) == 0 {
+				return false
+			}
+			if settings.async {
+				// Skip if already submitted.
+				if _, err := os.Stat(settings.getOutputFile(inputName, ".pending")); err == nil {
+					return true
+				}
+			}
+			if !settings.multipleLogs() {
+				return checkProofFile(settings.getOutputFile(inputName, ".proof"), msg, publicKey)
+			}
+			count := 0
+			for _, entity := range policy.GetLogsWithUrl() {
+				logKeyHash := crypto.HashBytes(entity.PublicKey[:])
+				if checkProofFile(settings.getLogProofFile(inputName, &logKeyHash), msg, publicKey) {
+					count++
+				}
+			}
+			return count >= settings.requiredLogs
+		}
 
 		// An item to submit.
 		type Item struct {
@@ -169,7 +199,6 @@ func main() {
 			inputName string // empty for stdin
 		}
 		var items []Item
-		// TODO: Actually do requests in batch.
 		source(skip, func(name string, leaf *requests.Leaf) {
 			items = append(items, Item{
 				leaf:      *leaf,
@@ -177,15 +206,51 @@ func main() {
 			})
 		})
 
-		for _, item := range items {
-			proof, err := submit.SubmitLeafRequest(ctx, &config, &item.leaf)
-			if err != nil {
-				log.Fatal("Submit failed: %v", err)
+		reqs := make([]requests.Leaf, len(items))
+		for i, item := range items {
+			reqs[i] = item.leaf
+		}
+		if settings.async {
+			failed := 0
+			for i, result := range submit.StartBatch(ctx, &config, reqs) {
+				if result.Err != nil {
+					log.Error("Submit failed for %q: %v", items[i].inputName, result.Err)
+					failed++
+					continue
+				}
+				if err := settings.withOutputFile(items[i].inputName, ".pending", result.Pending.ToASCII); err != nil {
+					log.Fatal("Writing pending submission failed: %v", err)
+				}
 			}
-			if err := settings.withOutputFile(item.inputName, ".proof", proof.ToASCII); err != nil {
-				log.Fatal("Writing proof failed: %v", err)
+			if failed > 0 {
+				log.Fatal("Submit failed for %d of %d items", failed, len(items))
+			}
+			return
+		}
+		// Write all proofs that were successfully collected,
+		// so that a retry skips those items.
+		failed := 0
+		for i, result := range submit.SubmitBatch(ctx, &config, reqs) {
+			if result.Err != nil {
+				log.Error("Submit failed for %q: %v", items[i].inputName, result.Err)
+				failed++
+				continue
+			}
+			if !settings.multipleLogs() {
+				if err := settings.withOutputFile(items[i].inputName, ".proof", result.Proofs[0].ToASCII); err != nil {
+					log.Fatal("Writing proof failed: %v", err)
+				}
+				continue
+			}
+			for _, pr := range result.Proofs {
+				if err := withOutputFile(settings.getLogProofFile(items[i].inputName, &pr.LogKeyHash), pr.ToASCII); err != nil {
+					log.Fatal("Writing proof failed: %v", err)
+				}
 			}
 		}
+		if failed > 0 {
+			log.Fatal("Submit failed for %d of %d items", failed, len(items))
+		}
 	} else {
 		sink := func(_ string, _ *requests.Leaf) {}
 		if settings.leafHash {
@@ -234,7 +299,9 @@ an add-leaf request, which is parsed and verified.
 If a Sigsum policy (-p option) is provided, the request is
 submitted to the log specified by the policy, and a Sigsum proof
 is collected and output. If there are multiple logs in
-the policy, they are tried in randomized order.
+the policy, they are tried in randomized order. Multiple requests
+are submitted concurrently, and if some requests fail, proofs are
+still written for the requests that succeeded.
 
 With -k but without -p, the add-leaf request itself is output.
 With no -k and no -p, the request syntax and signature of the
@@ -265,6 +332,27 @@ If a corresponding .proof file already exists, that proof is read
 and verified. If the proof is valid, the input file is skipped. If
 the proof is not valid, sigsum-submit exits with an error.
 
+With --required-logs=N, for N > 1, the request is submitted to N
+distinct logs, and one proof per log is output. Then proofs are
+always written to files, not to stdout, and the ".proof" suffix of
+the proof file name, as determined above, is replaced by
+".HASH.proof", where HASH is the first 16 hex digits of the log's key
+hash. An input file is skipped only if there are valid proofs from
+at least N logs.
+
+With --async, requests are only sent to the log(s), without waiting
+for proofs. For each request, a pending submission is output,
+recording the request and the logs that accepted it, using the
+suffix ".pending" in place of ".proof". Inputs with an existing
+.pending file are skipped. Later, run sigsum-submit --collect with
+the .pending files as inputs, to collect the proofs. Proofs are
+output as described above, with any ".pending" suffix on the input
+file name stripped, and the .pending file is removed. If some proofs
+are not yet available, the corresponding requests are sent to the
+log again, unless the log has responded that they are persisted,
+the .pending files are kept (and updated), and sigsum-submit exits
+with an error; it can then be rerun later.
+
 If a corresponding .req output file already exists, it is
 overwritten (TODO: Figure out if that is the proper behavior).
 `
@@ -284,7 +372,10 @@ overwritten (TODO: Figure out if that is the proper behavior).
 	set.FlagLong(&s.diagnostics, "diagnostics", 0, "One of \"fatal\", \"error\", \"warning\", \"info\", or \"debug\"", "level")
 	set.FlagLong(&s.tokenDomain, "token-domain", 0, "Create a Sigsum-Token: header for this domain")
 	set.FlagLong(&s.tokenKeyFile, "token-signing-key", 0, "Key for signing Sigsum-Token: header", "file")
-	set.FlagLong(&s.timeout, "timeout", 0, "Per-log submission timeout. Zero means library default, currently 45s", "duration")
+	set.FlagLong(&s.timeout, "timeout", 0, "Per-log submission timeout, for all requests together. Zero means library default, currently 45s", "duration")
+	set.FlagLong(&s.requiredLogs, "required-logs", 0, "Number of distinct logs to submit to, with one proof per log (default 1)", "number")
+	set.FlagLong(&s.async, "async", 0, "Only send requests, and output pending submissions")
+	set.FlagLong(&s.collect, "collect", 0, "Collect proofs for pending submissions")
 	set.FlagLong(&help, "help", 0, "Display help")
 	set.FlagLong(&versionFlag, "version", 'v', "Display software version")
 	set.Parse(args)
@@ -311,6 +402,21 @@ overwritten (TODO: Figure out if that is the proper behavior).
 	if len(s.policyFile) > 0 && s.leafHash {
 		log.Fatal("The -p (--policy) and --leaf-hash options are mutually exclusive.")
 	}
+	if (s.async || s.collect) && len(s.policyFile) == 0 {
+		log.Fatal("The --async and --collect options require the -p (--policy) option.")
+	}
+	if s.async && s.collect {
+		log.Fatal("The --async and --collect options are mutually exclusive.")
+	}
+	if s.collect && len(s.keyFile) > 0 {
+		log.Fatal("The --collect and -k (--signing-key) options are mutually exclusive.")
+	}
+	if s.requiredLogs < 0 {
+		log.Fatal("The --required-logs option must be positive.")
+	}
+	if s.multipleLogs() && !s.async && !s.collect && len(s.inputFiles) == 0 && len(s.outputFile) == 0 {
+		log.Fatal("The --required-logs option with more than one log requires input files or the -o option.")
+	}
 	for _, f := range s.inputFiles {
 		if len(f) == 0 {
 			log.Fatal("Empty string is not a valid input file name.")
@@ -318,6 +424,91 @@ overwritten (TODO: Figure out if that is the proper behavior).
 	}
 }
 
+// Collects proofs for pending submissions, and removes each pending
+// submission file once its proofs are written.
+func (s *Settings) collectProofs(ctx context.Context, config *submit.Config) {
+	var pending []submit.PendingSubmission
+	var names []string
+	if len(s.inputFiles) == 0 {
+		var ps submit.PendingSubmission
+		if err := ps.FromASCII(os.Stdin); err != nil {
+			log.Fatal("Pending submission on stdin not valid: %v", err)
+		}
+		pending = append(pending, ps)
+		names = append(names, "")
+	}
+	for _, inputFile := range s.inputFiles {
+		ps, err := readPendingSubmissionFile(inputFile)
+		if err != nil {
+			log.Fatal("Pending submission %q not valid: %v", inputFile, err)
+		}
+		name := strings.TrimSuffix(inputFile, ".pending")
+		if len(name) == 0 {
+			log.Fatal("Invalid input file name %q", ".pending")
+		}
+		pending = append(pending, ps)
+		names = append(names, name)
+	}
+	persisted := make([][]bool, len(pending))
+	for i := range pending {
+		persisted[i] = slices.Clone(pending[i].Persisted)
+	}
+	notIncluded, failed := 0, 0
+	for i, result := range submit.CollectBatch(ctx, config, pending) {
+		if errors.Is(result.Err, submit.ErrNotIncluded) {
+			log.Info("Proof for %q not yet available: %v", names[i], result.Err)
+			notIncluded++
+			// Record logs that now have persisted the
+			// leaf, so that it isn't resent to them.
+			if len(names[i]) > 0 && !slices.Equal(pending[i].Persisted, persisted[i]) {
+				if err := withOutputFile(s.inputFiles[i], pending[i].ToASCII); err != nil {
+					log.Fatal("Updating pending submission failed: %v", err)
+				}
+			}
+			continue
+		}
+		if result.Err != nil {
+			log.Error("Collecting proof for %q failed: %v", names[i], result.Err)
+			failed++
+			continue
+		}
+		if len(result.Proofs) == 1 {
+			if err := s.withOutputFile(names[i], ".proof", result.Proofs[0].ToASCII); err != nil {
+				log.Fatal("Writing proof failed: %v", err)
+			}
+		} else {
+			if len(names[i]) == 0 && len(s.outputFile) == 0 {
+				log.Fatal("Proofs from multiple logs can't be written to stdout, use the -o option.")
+			}
+			for _, pr := range result.Proofs {
+				if err := withOutputFile(s.getLogProofFile(names[i], &pr.LogKeyHash), pr.ToASCII); err != nil {
+					log.Fatal("Writing proof failed: %v", err)
+				}
+			}
+		}
+		if len(names[i]) > 0 {
+			if err := os.Remove(s.inputFiles[i]); err != nil {
+				log.Fatal("Removing pending submission failed: %v", err)
+			}
+		}
+	}
+	if notIncluded > 0 || failed > 0 {
+		log.Fatal("Proofs missing for %d of %d items, %d not yet included, retry later", notIncluded+failed, len(pending), notIncluded)
+	}
+}
+
+func readPendingSubmissionFile(name string) (submit.PendingSubmission, error) {
+	r, err := os.Open(name)
+	if err != nil {
+		return submit.PendingSubmission{}, err
+	}
+	defer r.Close()
+
+	var ps submit.PendingSubmission
+	err = ps.FromASCII(r)
+	return ps, err
+}
+
 // Empty input name means stdin. Empty output name means stdout should be used.
 func (s *Settings) getOutputFile(name, suffix string) string {
 	if len(s.outputFile) > 0 {
@@ -334,6 +525,17 @@ func (s *Settings) getOutputFile(name, suffix string) string {
 	return name
 }
 
+func (s *Settings) multipleLogs() bool {
+	return s.requiredLogs > 1
+}
+
+// Name of the proof file for a particular log, when proofs from
+// multiple logs are requested.
+func (s *Settings) getLogProofFile(name string, logKeyHash *crypto.Hash) string {
+	return fmt.Sprintf("%s.%x.proof",
+		strings.TrimSuffix(s.getOutputFile(name, ".proof"), ".proof"), logKeyHash[:8])
+}
+
 func (s *Settings) withOutputFile(name, suffix string, writer func(f io.Writer) error) error {
 	outputFile := s.getOutputFile(name, suffix)
 	if len(outputFile) == 0 {
@@ -5,6 +5,7 @@ import (
 	"context"
 
 	"sigsum.org/sigsum-go/pkg/checkpoint"
+	"sigsum.org/sigsum-go/pkg/crypto"
 	"sigsum.org/sigsum-go/pkg/requests"
 	"sigsum.org/sigsum-go/pkg/submit-token"
 	"sigsum.org/sigsum-go/pkg/types"
@@ -15,12 +16,41 @@ import (
 // expected to support requests for trivial inclusion and consistency
 // proofs, even though such requests are not allowed on the wire.
 type Log interface {
+	LogReader
+
+	// Returns a tree head with size larger than req.OldSize,
+	// waiting until the log has one, or until the context
+	// expires. Implementations talking to a log that doesn't
+	// support waiting fall back to polling GetTreeHead. The
+	// caller typically just got a tree head of size req.OldSize,
+	// so polling should start with a delay.
+	WaitTreeHead(context.Context, requests.TreeHead) (types.CosignedTreeHead, error)
+	AddLeaf(context.Context, requests.Leaf, *token.SubmitHeader) (bool, error)
+}
+
+// The read-only part of the Log interface.
+type LogReader interface {
 	GetTreeHead(context.Context) (types.CosignedTreeHead, error)
 	GetInclusionProof(context.Context, requests.InclusionProof) (types.InclusionProof, error)
 	GetConsistencyProof(context.Context, requests.ConsistencyProof) (types.ConsistencyProof, error)
 	GetLeaves(context.Context, requests.Leaves) ([]types.Leaf, error)
+}
 
-	AddLeaf(context.Context, requests.Leaf, *token.SubmitHeader) (bool, error)
+// Optional extension of LogReader, for logs that can prove inclusion
+// of a range of leaves using a single proof. Like for inclusion and
+// consistency proofs, implementations are expected to support
+// requests for trivial proofs, i.e., for the complete tree, even
+// though such requests are not allowed on the wire.
+type RangeProver interface {
+	GetRangeProof(context.Context, requests.RangeProof) (types.RangeProof, error)
+}
+
+// Interface for a log served using static tlog-tiles. GetTile
+// returns hashes of complete subtrees, as specified by the request.
+type TileLog interface {
+	GetTreeHead(context.Context) (types.CosignedTreeHead, error)
+	GetTile(context.Context, requests.Tile) ([]crypto.Hash, error)
+	GetLeaves(context.Context, requests.Leaves) ([]types.Leaf, error)
 }
 
 // Interface for witness api.
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"time"
 )
 
 // Partial success from AddLeaf, caller should retry.
@@ -68,6 +69,26 @@ func (e *Error) WithOldSize(oldSize uint64) *Error {
 	return e.WithError(&errorWithOldSize{oldSize})
 }
 
+type errorWithRetryAfter struct {
+	err        error
+	retryAfter time.Duration
+}
+
+func (e *errorWithRetryAfter) Error() string {
+	return e.err.Error()
+}
+
+func (e *errorWithRetryAfter) Unwrap() error {
+	return e.err
+}
+
+// Return a new error, with same status code and underlying error,
+// and the time to wait before the request may be retried, e.g., as
+// specified by a Retry-After response header.
+func (e *Error) WithRetryAfter(retryAfter time.Duration) *Error {
+	return e.WithError(&errorWithRetryAfter{err: e.err, retryAfter: retryAfter})
+}
+
 // An error is considered matching if the status code is the same.
 // Example usage:
 //
@@ -108,3 +129,13 @@ func ErrorConflictOldSize(err error) (uint64, bool) {
 	}
 	return 0, false
 }
+
+// Returns the time to wait before retrying, if associated with the
+// error, see WithRetryAfter.
+func ErrorRetryAfter(err error) (time.Duration, bool) {
+	var retryError *errorWithRetryAfter
+	if errors.As(err, &retryError) {
+		return retryError.retryAfter, true
+	}
+	return 0, false
+}
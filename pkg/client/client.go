@@ -1,6 +1,7 @@
 // The client package implements a low-level client for sigsum's http
 // api. Verifying appropriate signatures and cosignatures (depending
-// on policy) is out of scope.
+// on policy) is out of scope for Client, but can be added by wrapping
+// it in a VerifyingLog.
 
 package client
 
@@ -10,16 +11,28 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"math"
 	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"sigsum.org/sigsum-go/pkg/api"
 	"sigsum.org/sigsum-go/pkg/ascii"
 	"sigsum.org/sigsum-go/pkg/checkpoint"
+	"sigsum.org/sigsum-go/pkg/log"
 	"sigsum.org/sigsum-go/pkg/requests"
 	token "sigsum.org/sigsum-go/pkg/submit-token"
 	"sigsum.org/sigsum-go/pkg/types"
 )
 
+const (
+	defaultPollDelay = 2 * time.Second
+	// Minimum time between get-tree-head requests that don't
+	// return a larger tree head, in WaitTreeHead.
+	minWaitInterval = time.Second
+)
+
 type Config struct {
 	UserAgent string
 	URL       string
@@ -27,6 +40,17 @@ type Config struct {
 	// HTTPClient specifies the HTTP client to use when making requests to the log.
 	// If nil, a default client is created.
 	HTTPClient *http.Client
+
+	// Delay between get-tree-head requests in WaitTreeHead, when
+	// the log doesn't support waiting. Zero implies a default.
+	PollDelay time.Duration
+}
+
+func (c Config) getPollDelay() time.Duration {
+	if c.PollDelay <= 0 {
+		return defaultPollDelay
+	}
+	return c.PollDelay
 }
 
 func (c Config) getHTTPClient() *http.Client {
@@ -46,6 +70,15 @@ func New(cfg Config) *Client {
 type Client struct {
 	config Config
 	client *http.Client
+	// Set when the log is found not to support waiting
+	// get-tree-head requests.
+	noWait atomic.Bool
+
+	// Protects the latest get-tree-head response, and its ETag,
+	// used for conditional requests.
+	m            sync.Mutex
+	treeHeadETag string
+	treeHead     types.CosignedTreeHead
 }
 
 func (cli *Client) GetSecondaryTreeHead(ctx context.Context) (sth types.SignedTreeHead, err error) {
@@ -53,9 +86,86 @@ func (cli *Client) GetSecondaryTreeHead(ctx context.Context) (sth types.SignedTr
 	return
 }
 
-func (cli *Client) GetTreeHead(ctx context.Context) (cth types.CosignedTreeHead, err error) {
-	err = cli.get(ctx, types.EndpointGetTreeHead.Path(cli.config.URL), cth.FromASCII)
-	return
+// If the log supports ETags, requests are conditional, and if the
+// tree head is unchanged, the previous tree head is returned.
+func (cli *Client) GetTreeHead(ctx context.Context) (types.CosignedTreeHead, error) {
+	return cli.getTreeHead(ctx, types.EndpointGetTreeHead.Path(cli.config.URL))
+}
+
+// Gets a tree head from the given url, using a conditional request
+// if the log supports ETags. The ETag depends only on the tree head,
+// so the same cached tree head is used for plain and waiting
+// requests.
+func (cli *Client) getTreeHead(ctx context.Context, url string) (types.CosignedTreeHead, error) {
+	cli.m.Lock()
+	etag, cached := cli.treeHeadETag, cli.treeHead
+	cli.m.Unlock()
+
+	var cth types.CosignedTreeHead
+	newETag, notModified, err := cli.getConditional(ctx, url, etag, cth.FromASCII)
+	if err != nil {
+		return types.CosignedTreeHead{}, err
+	}
+	if notModified {
+		return cached, nil
+	}
+	if len(newETag) > 0 {
+		cli.m.Lock()
+		cli.treeHeadETag, cli.treeHead = newETag, cth
+		cli.m.Unlock()
+	}
+	return cth, nil
+}
+
+// Uses get-tree-head/<size> requests, where the log waits for a
+// larger tree head. If the log doesn't support that, falls back to
+// polling get-tree-head, with the configured PollDelay. The caller is
+// expected to have just retrieved a tree head of size req.OldSize, so
+// the first poll is also delayed.
+func (cli *Client) WaitTreeHead(ctx context.Context, req requests.TreeHead) (types.CosignedTreeHead, error) {
+	for !cli.noWait.Load() {
+		start := time.Now()
+		cth, err := cli.getTreeHead(ctx, req.ToURL(types.EndpointGetTreeHead.Path(cli.config.URL)+"/"))
+		if errors.Is(err, api.ErrNotFound) {
+			log.Debug("Log %q doesn't support waiting for tree heads, falling back to polling", cli.config.URL)
+			cli.noWait.Store(true)
+			break
+		}
+		if err != nil {
+			return types.CosignedTreeHead{}, err
+		}
+		if cth.Size > req.OldSize {
+			return cth, nil
+		}
+		// The log's limit on waiting time expired.
+		if err := sleepWithContext(ctx, minWaitInterval-time.Since(start)); err != nil {
+			return types.CosignedTreeHead{}, err
+		}
+	}
+	for {
+		if err := sleepWithContext(ctx, cli.config.getPollDelay()); err != nil {
+			return types.CosignedTreeHead{}, err
+		}
+		cth, err := cli.GetTreeHead(ctx)
+		if err != nil || cth.Size > req.OldSize {
+			return cth, err
+		}
+	}
+}
+
+func sleepWithContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
 
 func (cli *Client) GetInclusionProof(ctx context.Context, req requests.InclusionProof) (proof types.InclusionProof, err error) {
@@ -77,6 +187,20 @@ func (cli *Client) GetConsistencyProof(ctx context.Context, req requests.Consist
 	return
 }
 
+// Implements api.RangeProver. Fails with api.ErrNotFound if the log
+// doesn't support the get-range-proof endpoint.
+func (cli *Client) GetRangeProof(ctx context.Context, req requests.RangeProof) (proof types.RangeProof, err error) {
+	if req.StartIndex >= req.EndIndex || req.EndIndex > req.Size {
+		return types.RangeProof{}, fmt.Errorf("invalid request, StartIndex (%d), EndIndex (%d), Size (%d)",
+			req.StartIndex, req.EndIndex, req.Size)
+	}
+	if req.StartIndex == 0 && req.EndIndex == req.Size {
+		return types.RangeProof{}, nil
+	}
+	err = cli.get(ctx, req.ToURL(types.EndpointGetRangeProof.Path(cli.config.URL)), proof.FromASCII)
+	return
+}
+
 func (cli *Client) GetLeaves(ctx context.Context, req requests.Leaves) (leaves []types.Leaf, err error) {
 	if req.StartIndex >= req.EndIndex {
 		return nil, fmt.Errorf("invalid request, StartIndex (%d) >= EndIndex (%d)",
@@ -171,13 +295,51 @@ func (cli *Client) post(ctx context.Context, url string, tokenHeader *string, re
 	return cli.do(req, parseResponse, errorHook)
 }
 
-func (cli *Client) do(req *http.Request, parseBody func(io.Reader) error, errorHook func(*http.Response) error) error {
+// Like get, but if etag is non-empty, it is sent in an If-None-Match
+// header. Returns the response's ETag, if any, and whether or not the
+// server responded with 304 Not Modified, in which case parseBody is
+// not called.
+func (cli *Client) getConditional(ctx context.Context, url, etag string,
+	parseBody func(io.Reader) error) (string, bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", false, err
+	}
+	if len(etag) > 0 {
+		req.Header.Set("If-None-Match", etag)
+	}
+	rsp, err := cli.send(req)
+	if err != nil {
+		return "", false, err
+	}
+	defer rsp.Body.Close()
+	switch rsp.StatusCode {
+	case http.StatusNotModified:
+		if len(etag) == 0 {
+			return "", false, fmt.Errorf("unexpected Not Modified response")
+		}
+		return etag, true, nil
+	case http.StatusOK:
+		return rsp.Header.Get("ETag"), false, parseBody(rsp.Body)
+	}
+	return "", false, responseErrorHandling(rsp)
+}
+
+func (cli *Client) send(req *http.Request) (*http.Response, error) {
 	// TODO: redirects, see go doc http.Client.CheckRedirect
 	req.Header.Set("User-Agent", cli.config.UserAgent)
 
 	rsp, err := cli.client.Do(req)
 	if err != nil {
-		return fmt.Errorf("send request: %w", err)
+		return nil, fmt.Errorf("send request: %w", err)
+	}
+	return rsp, nil
+}
+
+func (cli *Client) do(req *http.Request, parseBody func(io.Reader) error, errorHook func(*http.Response) error) error {
+	rsp, err := cli.send(req)
+	if err != nil {
+		return err
 	}
 	defer rsp.Body.Close()
 	if rsp.StatusCode == http.StatusOK && parseBody != nil {
@@ -200,10 +362,33 @@ func responseErrorHandling(rsp *http.Response) error {
 		return api.NewError(rsp.StatusCode, err)
 	}
 	if rsp.StatusCode != http.StatusOK {
-		return api.NewError(rsp.StatusCode, fmt.Errorf("server: %q", b))
+		err := api.NewError(rsp.StatusCode, fmt.Errorf("server: %q", b))
+		if retryAfter, ok := parseRetryAfter(rsp.Header.Get("Retry-After"), time.Now()); ok {
+			return err.WithRetryAfter(retryAfter)
+		}
+		return err
 	}
 	if len(b) > 0 {
 		return fmt.Errorf("unexpected server response (status OK): %q", b)
 	}
 	return nil
 }
+
+// Parses the value of a Retry-After header, which is either a number
+// of seconds, or a HTTP date.
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	if len(value) == 0 {
+		return 0, false
+	}
+	if seconds, err := ascii.IntFromDecimal(value); err == nil {
+		if seconds > uint64(math.MaxInt64/time.Second) {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	t, err := http.ParseTime(value)
+	if err != nil {
+		return 0, false
+	}
+	return max(t.Sub(now), 0), true
+}
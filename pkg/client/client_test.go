@@ -2,11 +2,20 @@ package client
 
 import (
 	"bytes"
+	"context"
+	"errors"
+	"fmt"
 	"io"
 	"net/http"
+	"net/http/httptest"
+	"slices"
 	"testing"
+	"time"
 
 	"sigsum.org/sigsum-go/pkg/api"
+	"sigsum.org/sigsum-go/pkg/crypto"
+	"sigsum.org/sigsum-go/pkg/requests"
+	"sigsum.org/sigsum-go/pkg/types"
 )
 
 func TestProcessConflictResponse(t *testing.T) {
@@ -41,3 +50,128 @@ func TestProcessConflictResponse(t *testing.T) {
 		}
 	}
 }
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	for _, table := range []struct {
+		value string
+		want  time.Duration // -1 if invalid
+	}{
+		{"", -1},
+		{"0", 0},
+		{"120", 2 * time.Minute},
+		{"-1", -1},
+		{"1.5", -1},
+		{"99999999999999999999", -1},
+		{"Mon, 01 Jan 2024 12:00:30 GMT", 30 * time.Second},
+		{"Mon, 01 Jan 2024 11:00:00 GMT", 0},
+		{"tomorrow", -1},
+	} {
+		got, ok := parseRetryAfter(table.value, now)
+		if table.want < 0 {
+			if ok {
+				t.Errorf("%q: unexpected result %v", table.value, got)
+			}
+		} else if !ok || got != table.want {
+			t.Errorf("%q: got %v (ok %v), want %v", table.value, got, ok, table.want)
+		}
+	}
+}
+
+func TestRetryAfterResponse(t *testing.T) {
+	rsp := http.Response{StatusCode: http.StatusTooManyRequests}
+	rsp.Header = make(http.Header)
+	rsp.Header.Set("Retry-After", "17")
+	rsp.Body = io.NopCloser(bytes.NewBufferString("slow down\n"))
+
+	err := responseErrorHandling(&rsp)
+	if !errors.Is(err, api.ErrTooManyRequests) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if got, ok := api.ErrorRetryAfter(err); !ok || got != 17*time.Second {
+		t.Errorf("unexpected retry after: %v (ok %v)", got, ok)
+	}
+}
+
+func TestConditionalGetTreeHead(t *testing.T) {
+	cth := types.CosignedTreeHead{SignedTreeHead: types.SignedTreeHead{
+		TreeHead: types.TreeHead{Size: 3, RootHash: crypto.Hash{1}}}}
+	var queries, notModified int
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries++
+		etag := fmt.Sprintf("\"%d\"", cth.Size)
+		w.Header().Set("ETag", etag)
+		if r.Header.Get("If-None-Match") == etag {
+			notModified++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		cth.ToASCII(w)
+	}))
+	defer s.Close()
+
+	cli := New(Config{URL: s.URL})
+	for _, size := range []uint64{3, 3, 4, 4} {
+		cth.Size = size
+		got, err := cli.GetTreeHead(context.Background())
+		if err != nil {
+			t.Fatalf("GetTreeHead failed: %v", err)
+		}
+		if got.TreeHead != cth.TreeHead {
+			t.Errorf("unexpected tree head, got %v, want %v", got.TreeHead, cth.TreeHead)
+		}
+	}
+	if queries != 4 || notModified != 2 {
+		t.Errorf("unexpected number of requests %d, not modified %d, want 4 and 2", queries, notModified)
+	}
+	// Waiting requests are conditional too.
+	got, err := cli.WaitTreeHead(context.Background(), requests.TreeHead{OldSize: 3})
+	if err != nil {
+		t.Fatalf("WaitTreeHead failed: %v", err)
+	}
+	if got.TreeHead != cth.TreeHead {
+		t.Errorf("unexpected waiting tree head, got %v, want %v", got.TreeHead, cth.TreeHead)
+	}
+	if queries != 5 || notModified != 3 {
+		t.Errorf("unexpected number of requests %d, not modified %d, want 5 and 3", queries, notModified)
+	}
+}
+
+func TestGetRangeProof(t *testing.T) {
+	proof := types.RangeProof{Path: []crypto.Hash{{1}, {2}}}
+	supported := true
+	var paths []string
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		if !supported {
+			http.NotFound(w, r)
+			return
+		}
+		proof.ToASCII(w)
+	}))
+	defer s.Close()
+
+	cli := New(Config{URL: s.URL})
+	ctx := context.Background()
+	got, err := cli.GetRangeProof(ctx, requests.RangeProof{StartIndex: 2, EndIndex: 4, Size: 5})
+	if err != nil {
+		t.Fatalf("GetRangeProof failed: %v", err)
+	}
+	if !slices.Equal(got.Path, proof.Path) {
+		t.Errorf("unexpected proof, got %x, want %x", got.Path, proof.Path)
+	}
+	// Trivial proof for the complete tree.
+	if got, err := cli.GetRangeProof(ctx, requests.RangeProof{StartIndex: 0, EndIndex: 5, Size: 5}); err != nil || len(got.Path) > 0 {
+		t.Errorf("unexpected result for complete tree, proof %x, err %v", got.Path, err)
+	}
+	if _, err := cli.GetRangeProof(ctx, requests.RangeProof{StartIndex: 2, EndIndex: 6, Size: 5}); err == nil {
+		t.Errorf("invalid request succeeded")
+	}
+	if want := []string{"/get-range-proof/2/4/5"}; !slices.Equal(paths, want) {
+		t.Errorf("unexpected requests, got %v, want %v", paths, want)
+	}
+	supported = false
+	if _, err := cli.GetRangeProof(ctx, requests.RangeProof{StartIndex: 2, EndIndex: 4, Size: 5}); !errors.Is(err, api.ErrNotFound) {
+		t.Errorf("unexpected error for unsupported endpoint: %v", err)
+	}
+}
@@ -0,0 +1,140 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"sigsum.org/sigsum-go/pkg/log"
+	"sigsum.org/sigsum-go/pkg/requests"
+	token "sigsum.org/sigsum-go/pkg/submit-token"
+	"sigsum.org/sigsum-go/pkg/types"
+)
+
+type MultiConfig struct {
+	UserAgent string
+	// URLs of endpoints accepting all requests, e.g., a log's
+	// primary node.
+	URLs []string
+	// URLs of read-only endpoints, e.g., mirrors of the log. Not
+	// used for add-leaf requests.
+	MirrorURLs []string
+
+	// HTTPClient specifies the HTTP client to use when making requests to the log.
+	// If nil, a default client is created.
+	HTTPClient *http.Client
+
+	// Delay between get-tree-head requests in WaitTreeHead, when
+	// the log doesn't support waiting. Zero implies a default.
+	PollDelay time.Duration
+}
+
+// MultiClient talks to a single log, served at several endpoints,
+// and implements api.Log. Requests for proofs and leaves are
+// distributed over all endpoints, in round-robin order, while
+// add-leaf and get-tree-head requests are sent only to the endpoints
+// listed in MultiConfig.URLs. If a request to one endpoint fails, it
+// is retried at the next endpoint, and the error from the last
+// endpoint tried is returned only if all fail.
+//
+// Tree heads are never requested from mirrors, so unlike other read
+// requests, get-tree-head requests are not load balanced. A mirror
+// may lag behind the log, and then returns an older tree head. That
+// is not an error, so it would not trigger failover, but a caller
+// that had already seen a newer tree head would see the log shrink,
+// which monitors treat as misbehavior. Requests for proofs and leaves
+// don't have that problem: those referring to a tree larger than a
+// mirror's fail, and are then retried like any other failure.
+type MultiClient struct {
+	// Endpoints used for add-leaf and get-tree-head.
+	writers []*Client
+	// All endpoints, including writers.
+	readers []*Client
+	next    atomic.Uint64
+}
+
+func NewMulti(cfg MultiConfig) *MultiClient {
+	httpClient := Config{HTTPClient: cfg.HTTPClient}.getHTTPClient()
+	newClient := func(url string) *Client {
+		return New(Config{
+			UserAgent:  cfg.UserAgent,
+			URL:        url,
+			HTTPClient: httpClient,
+			PollDelay:  cfg.PollDelay,
+		})
+	}
+	m := MultiClient{}
+	for _, url := range cfg.URLs {
+		m.writers = append(m.writers, newClient(url))
+	}
+	m.readers = append(m.readers, m.writers...)
+	for _, url := range cfg.MirrorURLs {
+		m.readers = append(m.readers, newClient(url))
+	}
+	return &m
+}
+
+// Calls f with each of the clients in turn, starting at the next
+// client in round-robin order, until a call succeeds or the context
+// expires.
+func tryEach[T any](ctx context.Context, m *MultiClient, clients []*Client, f func(*Client) (T, error)) (T, error) {
+	if len(clients) == 0 {
+		var zero T
+		return zero, fmt.Errorf("no log endpoints available")
+	}
+	start := m.next.Add(1)
+	for i := 0; ; i++ {
+		cli := clients[(start+uint64(i))%uint64(len(clients))]
+		res, err := f(cli)
+		if err == nil || ctx.Err() != nil || i+1 >= len(clients) {
+			return res, err
+		}
+		log.Debug("Request to log endpoint %q failed, trying next: %v", cli.config.URL, err)
+	}
+}
+
+func (m *MultiClient) GetTreeHead(ctx context.Context) (types.CosignedTreeHead, error) {
+	return tryEach(ctx, m, m.writers, func(cli *Client) (types.CosignedTreeHead, error) {
+		return cli.GetTreeHead(ctx)
+	})
+}
+
+// A failing endpoint is detected only when the waiting request
+// fails; the next endpoint is then asked to wait in turn.
+func (m *MultiClient) WaitTreeHead(ctx context.Context, req requests.TreeHead) (types.CosignedTreeHead, error) {
+	return tryEach(ctx, m, m.writers, func(cli *Client) (types.CosignedTreeHead, error) {
+		return cli.WaitTreeHead(ctx, req)
+	})
+}
+
+func (m *MultiClient) GetInclusionProof(ctx context.Context, req requests.InclusionProof) (types.InclusionProof, error) {
+	return tryEach(ctx, m, m.readers, func(cli *Client) (types.InclusionProof, error) {
+		return cli.GetInclusionProof(ctx, req)
+	})
+}
+
+func (m *MultiClient) GetConsistencyProof(ctx context.Context, req requests.ConsistencyProof) (types.ConsistencyProof, error) {
+	return tryEach(ctx, m, m.readers, func(cli *Client) (types.ConsistencyProof, error) {
+		return cli.GetConsistencyProof(ctx, req)
+	})
+}
+
+func (m *MultiClient) GetRangeProof(ctx context.Context, req requests.RangeProof) (types.RangeProof, error) {
+	return tryEach(ctx, m, m.readers, func(cli *Client) (types.RangeProof, error) {
+		return cli.GetRangeProof(ctx, req)
+	})
+}
+
+func (m *MultiClient) GetLeaves(ctx context.Context, req requests.Leaves) ([]types.Leaf, error) {
+	return tryEach(ctx, m, m.readers, func(cli *Client) ([]types.Leaf, error) {
+		return cli.GetLeaves(ctx, req)
+	})
+}
+
+func (m *MultiClient) AddLeaf(ctx context.Context, req requests.Leaf, header *token.SubmitHeader) (bool, error) {
+	return tryEach(ctx, m, m.writers, func(cli *Client) (bool, error) {
+		return cli.AddLeaf(ctx, req, header)
+	})
+}
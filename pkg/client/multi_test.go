@@ -0,0 +1,108 @@
+package client_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"sigsum.org/sigsum-go/pkg/client"
+	"sigsum.org/sigsum-go/pkg/crypto"
+	"sigsum.org/sigsum-go/pkg/logserver"
+	"sigsum.org/sigsum-go/pkg/requests"
+	"sigsum.org/sigsum-go/pkg/server"
+	"sigsum.org/sigsum-go/pkg/types"
+)
+
+// Serves the log, counting requests by method. If broken is set,
+// all requests fail.
+type countingEndpoint struct {
+	handler http.Handler
+	broken  bool
+	gets    atomic.Int64
+	posts   atomic.Int64
+}
+
+func (e *countingEndpoint) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		e.posts.Add(1)
+	} else {
+		e.gets.Add(1)
+	}
+	if e.broken {
+		http.Error(w, "broken", http.StatusInternalServerError)
+		return
+	}
+	e.handler.ServeHTTP(w, r)
+}
+
+func TestMultiClient(t *testing.T) {
+	submitSigner := crypto.NewEd25519Signer(&crypto.PrivateKey{2})
+	l, err := logserver.New(&logserver.Config{Signer: crypto.NewEd25519Signer(&crypto.PrivateKey{1})})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	handler := server.NewLog(&server.Config{}, l)
+
+	// The primary, a healthy mirror and a broken mirror.
+	endpoints := []*countingEndpoint{
+		{handler: handler}, {handler: handler}, {handler: handler, broken: true},
+	}
+	var urls []string
+	for _, e := range endpoints {
+		s := httptest.NewServer(e)
+		defer s.Close()
+		urls = append(urls, s.URL)
+	}
+	cli := client.NewMulti(client.MultiConfig{URLs: urls[:1], MirrorURLs: urls[1:]})
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		msg := crypto.Hash{byte(i)}
+		signature, err := types.SignLeafMessage(submitSigner, msg[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := cli.AddLeaf(ctx, requests.Leaf{Message: msg, Signature: signature, PublicKey: submitSigner.Public()}, nil); err != nil {
+			t.Fatalf("AddLeaf failed: %v", err)
+		}
+	}
+	if got, want := endpoints[0].posts.Load(), int64(3); got != want {
+		t.Errorf("unexpected number of add-leaf requests to primary, got %d, want %d", got, want)
+	}
+	for i, e := range endpoints[1:] {
+		if got := e.posts.Load(); got != 0 {
+			t.Errorf("unexpected add-leaf requests to mirror %d: %d", i, got)
+		}
+	}
+	if err := l.Publish(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 6; i++ {
+		cth, err := cli.GetTreeHead(ctx)
+		if err != nil {
+			t.Fatalf("GetTreeHead failed: %v", err)
+		}
+		if cth.Size != 3 {
+			t.Errorf("unexpected tree size %d, want 3", cth.Size)
+		}
+		if _, err := cli.GetLeaves(ctx, requests.Leaves{StartIndex: 0, EndIndex: 3}); err != nil {
+			t.Fatalf("GetLeaves failed: %v", err)
+		}
+	}
+	for i, e := range endpoints {
+		if e.gets.Load() == 0 {
+			t.Errorf("no read requests to endpoint %d", i)
+		}
+	}
+
+	// With all endpoints broken, requests fail.
+	for _, e := range endpoints {
+		e.broken = true
+	}
+	if _, err := cli.GetTreeHead(ctx); err == nil {
+		t.Errorf("GetTreeHead succeeded with all endpoints broken")
+	}
+}
@@ -0,0 +1,305 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+
+	"sigsum.org/sigsum-go/pkg/api"
+	"sigsum.org/sigsum-go/pkg/checkpoint"
+	"sigsum.org/sigsum-go/pkg/crypto"
+	"sigsum.org/sigsum-go/pkg/merkle"
+	"sigsum.org/sigsum-go/pkg/requests"
+	"sigsum.org/sigsum-go/pkg/types"
+)
+
+const (
+	// Default number of level 0 tiles to scan when looking up
+	// the index of a leaf hash that isn't already known.
+	DefaultMaxScanTiles = 16
+
+	// Limits on cached tiles and leaf indices; when exceeded,
+	// the corresponding cache is cleared.
+	maxCachedTiles       = 128
+	maxCachedLeafIndices = 1 << 16
+)
+
+type TileConfig struct {
+	UserAgent string
+	// URL prefix of the tlog-tiles endpoints.
+	URL string
+
+	// HTTPClient specifies the HTTP client to use when making requests to the log.
+	// If nil, a default client is created.
+	HTTPClient *http.Client
+
+	// Keys of known witnesses. Cosignature lines on the checkpoint
+	// are mapped to the corresponding witness key hashes, other
+	// cosignature lines are ignored.
+	WitnessKeys []crypto.PublicKey
+
+	// Maximum number of level 0 tiles to scan, backwards from the
+	// end of the tree, when GetInclusionProof needs to look up
+	// the index of a leaf. Zero implies a default.
+	MaxScanTiles uint64
+}
+
+// TileClient reads a Sigsum log served as static tlog-tiles, see
+// https://github.com/C2SP/C2SP/blob/main/tlog-tiles.md, and
+// implements api.LogReader. Inclusion and consistency proofs are
+// computed locally from the log's tiles. As for Client, verifying
+// signatures and cosignatures is out of scope.
+//
+// The tiles protocol doesn't provide any lookup of leaf indices, so
+// GetInclusionProof only succeeds for leaves that have been seen
+// by a previous GetLeaves call, or are located close to the end of
+// the tree.
+type TileClient struct {
+	client       *Client
+	url          string
+	witnessKeys  map[crypto.Hash]crypto.PublicKey
+	maxScanTiles uint64
+
+	// Protects all fields below.
+	m sync.Mutex
+	// Size of the most recent tree head.
+	size      uint64
+	tiles     map[requests.Tile][]crypto.Hash
+	leafIndex map[crypto.Hash]uint64
+}
+
+func NewTileClient(cfg TileConfig) *TileClient {
+	witnessKeys := make(map[crypto.Hash]crypto.PublicKey)
+	for _, key := range cfg.WitnessKeys {
+		witnessKeys[crypto.HashBytes(key[:])] = key
+	}
+	maxScanTiles := cfg.MaxScanTiles
+	if maxScanTiles == 0 {
+		maxScanTiles = DefaultMaxScanTiles
+	}
+	return &TileClient{
+		client: New(Config{
+			UserAgent:  cfg.UserAgent,
+			URL:        cfg.URL,
+			HTTPClient: cfg.HTTPClient,
+		}),
+		url:          cfg.URL,
+		witnessKeys:  witnessKeys,
+		maxScanTiles: maxScanTiles,
+		tiles:        make(map[requests.Tile][]crypto.Hash),
+		leafIndex:    make(map[crypto.Hash]uint64),
+	}
+}
+
+// Parses a checkpoint, and cosignature lines by known witnesses.
+func (cli *TileClient) parseCheckpoint(data []byte) (types.CosignedTreeHead, error) {
+	var cp checkpoint.Checkpoint
+	if err := cp.FromASCII(bytes.NewBuffer(data)); err != nil {
+		return types.CosignedTreeHead{}, err
+	}
+	cth := types.CosignedTreeHead{SignedTreeHead: cp.SignedTreeHead}
+
+	// Signature lines follow the first empty line.
+	_, signatures, found := bytes.Cut(data, []byte("\n\n"))
+	if !found {
+		return types.CosignedTreeHead{}, fmt.Errorf("invalid checkpoint, no signature lines")
+	}
+	lines, err := checkpoint.CosignatureLinesFromASCII(bytes.NewBuffer(signatures))
+	if err != nil {
+		return types.CosignedTreeHead{}, err
+	}
+	for _, line := range lines {
+		for keyHash, key := range cli.witnessKeys {
+			if checkpoint.NewWitnessKeyId(line.KeyName, &key) == line.KeyId {
+				if cth.Cosignatures == nil {
+					cth.Cosignatures = make(map[crypto.Hash]types.Cosignature)
+				}
+				cth.Cosignatures[keyHash] = line.Cosignature
+			}
+		}
+	}
+	return cth, nil
+}
+
+func (cli *TileClient) GetTreeHead(ctx context.Context) (types.CosignedTreeHead, error) {
+	var cth types.CosignedTreeHead
+	if err := cli.client.get(ctx, types.EndpointCheckpoint.Path(cli.url), func(r io.Reader) error {
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		cth, err = cli.parseCheckpoint(data)
+		return err
+	}); err != nil {
+		return types.CosignedTreeHead{}, err
+	}
+	cli.m.Lock()
+	defer cli.m.Unlock()
+	if cth.Size > cli.size {
+		cli.size = cth.Size
+	}
+	return cth, nil
+}
+
+// Returns the size of the most recent tree head, fetching a tree
+// head if needed to cover the given index.
+func (cli *TileClient) getSize(ctx context.Context, index uint64) (uint64, error) {
+	cli.m.Lock()
+	size := cli.size
+	cli.m.Unlock()
+	if index < size {
+		return size, nil
+	}
+	cth, err := cli.GetTreeHead(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return cth.Size, nil
+}
+
+// Returns the hashes of the tile with the given level and index, in
+// a tree of the given size.
+func (cli *TileClient) getTile(ctx context.Context, level, index, size uint64) ([]crypto.Hash, error) {
+	width := min(types.TileWidth, (size>>(level*types.TileHeight))-index*types.TileWidth)
+	req := requests.Tile{Level: level, Index: index, Width: width}
+
+	cli.m.Lock()
+	hashes, ok := cli.tiles[req]
+	cli.m.Unlock()
+	if ok {
+		return hashes, nil
+	}
+	if err := cli.client.get(ctx, req.ToURL(types.EndpointTile.Path(cli.url)), func(r io.Reader) (err error) {
+		hashes, err = types.TileHashesFromBinary(r, width)
+		return err
+	}); err != nil {
+		return nil, err
+	}
+
+	cli.m.Lock()
+	defer cli.m.Unlock()
+	if len(cli.tiles) >= maxCachedTiles {
+		cli.tiles = make(map[requests.Tile][]crypto.Hash)
+	}
+	cli.tiles[req] = hashes
+	return hashes, nil
+}
+
+// Returns a function to get node hashes, for a tree of the given
+// size, from the corresponding tiles.
+func (cli *TileClient) nodeHashFunc(ctx context.Context, size uint64) merkle.GetNodeHashFunc {
+	return func(level uint, index uint64) (crypto.Hash, error) {
+		tileLevel := uint64(level / types.TileHeight)
+		// The node is the root of a subtree of these nodes
+		// at the tile's level.
+		count := uint64(1) << (level % types.TileHeight)
+		first := index * count
+
+		hashes, err := cli.getTile(ctx, tileLevel, first/types.TileWidth, size)
+		if err != nil {
+			return crypto.Hash{}, err
+		}
+		offset := first % types.TileWidth
+		if offset+count > uint64(len(hashes)) {
+			return crypto.Hash{}, fmt.Errorf("node (%d, %d) not covered by tile", level, index)
+		}
+		nodes := make([]crypto.Hash, count)
+		copy(nodes, hashes[offset:offset+count])
+		for ; count > 1; count /= 2 {
+			for i := uint64(0); i < count/2; i++ {
+				nodes[i] = merkle.HashInteriorNode(&nodes[2*i], &nodes[2*i+1])
+			}
+		}
+		return nodes[0], nil
+	}
+}
+
+// Looks up the index of a leaf hash, in the tree of the given size.
+func (cli *TileClient) findLeafIndex(ctx context.Context, leafHash *crypto.Hash, size uint64) (uint64, error) {
+	cli.m.Lock()
+	index, ok := cli.leafIndex[*leafHash]
+	cli.m.Unlock()
+	if ok && index < size {
+		return index, nil
+	}
+	for i, n := uint64(0), (size-1)/types.TileWidth; i < cli.maxScanTiles && i <= n; i++ {
+		hashes, err := cli.getTile(ctx, 0, n-i, size)
+		if err != nil {
+			return 0, err
+		}
+		for j, h := range hashes {
+			if h == *leafHash {
+				return (n-i)*types.TileWidth + uint64(j), nil
+			}
+		}
+	}
+	return 0, api.ErrNotFound
+}
+
+func (cli *TileClient) GetInclusionProof(ctx context.Context, req requests.InclusionProof) (types.InclusionProof, error) {
+	if req.Size == 0 {
+		return types.InclusionProof{}, api.ErrNotFound
+	}
+	index, err := cli.findLeafIndex(ctx, &req.LeafHash, req.Size)
+	if err != nil {
+		return types.InclusionProof{}, err
+	}
+	path, err := merkle.ProveInclusionFromNodes(cli.nodeHashFunc(ctx, req.Size), index, req.Size)
+	if err != nil {
+		return types.InclusionProof{}, err
+	}
+	return types.InclusionProof{LeafIndex: index, Path: path}, nil
+}
+
+func (cli *TileClient) GetConsistencyProof(ctx context.Context, req requests.ConsistencyProof) (types.ConsistencyProof, error) {
+	if req.OldSize == 0 || req.OldSize == req.NewSize {
+		return types.ConsistencyProof{}, nil
+	}
+	path, err := merkle.ProveConsistencyFromNodes(cli.nodeHashFunc(ctx, req.NewSize), req.OldSize, req.NewSize)
+	if err != nil {
+		return types.ConsistencyProof{}, err
+	}
+	return types.ConsistencyProof{Path: path}, nil
+}
+
+// Returns leaves from a single entry bundle, hence, fewer leaves
+// than requested may be returned.
+func (cli *TileClient) GetLeaves(ctx context.Context, req requests.Leaves) ([]types.Leaf, error) {
+	if req.StartIndex >= req.EndIndex {
+		return nil, fmt.Errorf("invalid request, StartIndex (%d) >= EndIndex (%d)",
+			req.StartIndex, req.EndIndex)
+	}
+	size, err := cli.getSize(ctx, req.StartIndex)
+	if err != nil {
+		return nil, err
+	}
+	if req.StartIndex >= size {
+		return nil, api.ErrBadRequest.WithError(
+			fmt.Errorf("start index %d exceeds tree size %d", req.StartIndex, size))
+	}
+	bundle := requests.EntryBundle{Index: req.StartIndex / types.TileWidth}
+	first := bundle.Index * types.TileWidth
+	bundle.Width = min(types.TileWidth, size-first)
+
+	var leaves []types.Leaf
+	if err := cli.client.get(ctx, bundle.ToURL(types.EndpointEntryBundle.Path(cli.url)), func(r io.Reader) (err error) {
+		leaves, err = types.LeavesFromEntryBundle(r, bundle.Width)
+		return err
+	}); err != nil {
+		return nil, err
+	}
+
+	cli.m.Lock()
+	defer cli.m.Unlock()
+	if len(cli.leafIndex)+len(leaves) > maxCachedLeafIndices {
+		cli.leafIndex = make(map[crypto.Hash]uint64)
+	}
+	for i, leaf := range leaves {
+		cli.leafIndex[leaf.ToHash()] = first + uint64(i)
+	}
+	end := min(req.EndIndex, first+bundle.Width)
+	return leaves[req.StartIndex-first : end-first], nil
+}
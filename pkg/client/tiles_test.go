@@ -0,0 +1,184 @@
+package client
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"sigsum.org/sigsum-go/pkg/api"
+	"sigsum.org/sigsum-go/pkg/checkpoint"
+	"sigsum.org/sigsum-go/pkg/crypto"
+	"sigsum.org/sigsum-go/pkg/logserver"
+	"sigsum.org/sigsum-go/pkg/merkle"
+	"sigsum.org/sigsum-go/pkg/requests"
+	"sigsum.org/sigsum-go/pkg/server"
+	"sigsum.org/sigsum-go/pkg/types"
+)
+
+// Adds a witness cosignature to the log's tree head.
+type cosignedTileLog struct {
+	*logserver.Log
+	witness crypto.Signer
+}
+
+func (l cosignedTileLog) GetTreeHead(ctx context.Context) (types.CosignedTreeHead, error) {
+	cth, err := l.Log.GetTreeHead(ctx)
+	if err != nil {
+		return cth, err
+	}
+	logKey := crypto.NewEd25519Signer(&crypto.PrivateKey{1}).Public()
+	cs, err := cth.Cosign(l.witness, types.SigsumCheckpointOrigin(&logKey), 17)
+	if err != nil {
+		return cth, err
+	}
+	witnessKey := l.witness.Public()
+	cth.Cosignatures = map[crypto.Hash]types.Cosignature{crypto.HashBytes(witnessKey[:]): cs}
+	return cth, nil
+}
+
+func TestTileClient(t *testing.T) {
+	logSigner := crypto.NewEd25519Signer(&crypto.PrivateKey{1})
+	logKey := logSigner.Public()
+	submitSigner := crypto.NewEd25519Signer(&crypto.PrivateKey{2})
+	witnessSigner := crypto.NewEd25519Signer(&crypto.PrivateKey{3})
+	witnessKey := witnessSigner.Public()
+
+	l, err := logserver.New(&logserver.Config{Signer: logSigner})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	ctx := context.Background()
+	// Enough leaves to get several level 0 tiles and a partial
+	// level 1 tile.
+	var leafHashes []crypto.Hash
+	for i := 0; i < 600; i++ {
+		var msg crypto.Hash
+		binary.BigEndian.PutUint64(msg[:], uint64(i))
+		signature, err := types.SignLeafMessage(submitSigner, msg[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		req := requests.Leaf{Message: msg, Signature: signature, PublicKey: submitSigner.Public()}
+		leaf, err := req.Verify()
+		if err != nil {
+			t.Fatal(err)
+		}
+		leafHashes = append(leafHashes, leaf.ToHash())
+		if _, err := l.AddLeaf(ctx, req, nil); err != nil {
+			t.Fatal(err)
+		}
+		if i == 300 {
+			if err := l.Publish(ctx); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	oldCth, err := l.GetTreeHead(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Publish(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	witnesses := []checkpoint.NoteVerifier{
+		checkpoint.NewNoteVerifier("example.org/witness", checkpoint.SigTypeCosignature, &witnessKey)}
+	s := httptest.NewServer(server.NewTileLog(&server.Config{}, &logKey, witnesses,
+		cosignedTileLog{Log: l, witness: witnessSigner}))
+	defer s.Close()
+
+	cli := NewTileClient(TileConfig{URL: s.URL, WitnessKeys: []crypto.PublicKey{witnessKey}, MaxScanTiles: 1})
+	cth, err := cli.GetTreeHead(ctx)
+	if err != nil {
+		t.Fatalf("GetTreeHead failed: %v", err)
+	}
+	if got, want := cth.Size, uint64(len(leafHashes)); got != want {
+		t.Fatalf("unexpected tree size, got %d, want %d", got, want)
+	}
+	if !cth.Verify(&logKey) {
+		t.Errorf("invalid tree head signature")
+	}
+	if cs, ok := cth.Cosignatures[crypto.HashBytes(witnessKey[:])]; !ok ||
+		!cs.Verify(&witnessKey, types.SigsumCheckpointOrigin(&logKey), &cth.TreeHead) {
+		t.Errorf("missing or invalid cosignature")
+	}
+
+	proof, err := cli.GetConsistencyProof(ctx, requests.ConsistencyProof{OldSize: oldCth.Size, NewSize: cth.Size})
+	if err != nil {
+		t.Fatalf("GetConsistencyProof failed: %v", err)
+	}
+	if err := proof.Verify(&oldCth.TreeHead, &cth.TreeHead); err != nil {
+		t.Errorf("invalid consistency proof: %v", err)
+	}
+
+	// With MaxScanTiles 1, only leaves in the last tile are
+	// found before a GetLeaves call.
+	if _, err := cli.GetInclusionProof(ctx, requests.InclusionProof{Size: cth.Size, LeafHash: leafHashes[599]}); err != nil {
+		t.Errorf("GetInclusionProof failed for leaf in last tile: %v", err)
+	}
+	if _, err := cli.GetInclusionProof(ctx, requests.InclusionProof{Size: cth.Size, LeafHash: leafHashes[10]}); !errors.Is(err, api.ErrNotFound) {
+		t.Errorf("unexpected result for unknown leaf index: %v", err)
+	}
+	for i := uint64(0); i < cth.Size; {
+		leaves, err := cli.GetLeaves(ctx, requests.Leaves{StartIndex: i, EndIndex: i + 100})
+		if err != nil {
+			t.Fatalf("GetLeaves failed: %v", err)
+		}
+		if len(leaves) == 0 || len(leaves) > 100 {
+			t.Fatalf("unexpected number of leaves: %d", len(leaves))
+		}
+		for j, leaf := range leaves {
+			if got, want := leaf.ToHash(), leafHashes[i+uint64(j)]; got != want {
+				t.Errorf("unexpected leaf at index %d", i+uint64(j))
+			}
+		}
+		i += uint64(len(leaves))
+	}
+	if _, err := cli.GetLeaves(ctx, requests.Leaves{StartIndex: cth.Size, EndIndex: cth.Size + 1}); !errors.Is(err, api.ErrBadRequest) {
+		t.Errorf("unexpected result for out of range leaves: %v", err)
+	}
+	// Compare to proofs for smaller trees, using a local tree.
+	tree := merkle.NewTree()
+	for _, h := range leafHashes {
+		tree.AddLeafHash(&h)
+	}
+	for _, size := range []uint64{1, 2, 255, 256, 257, oldCth.Size, cth.Size} {
+		for _, index := range []uint64{0, 1, 254, 255, 256, 511, 512, size - 1} {
+			if index >= size {
+				continue
+			}
+			proof, err := cli.GetInclusionProof(ctx, requests.InclusionProof{Size: size, LeafHash: leafHashes[index]})
+			if err != nil {
+				t.Fatalf("GetInclusionProof failed for leaf %d, size %d: %v", index, size, err)
+			}
+			want, err := tree.ProveInclusion(index, size)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if proof.LeafIndex != index || !slices.Equal(proof.Path, want) {
+				t.Errorf("unexpected inclusion proof for leaf %d, size %d", index, size)
+			}
+		}
+		for _, oldSize := range []uint64{1, 3, 256, 300} {
+			if oldSize >= size {
+				continue
+			}
+			proof, err := cli.GetConsistencyProof(ctx, requests.ConsistencyProof{OldSize: oldSize, NewSize: size})
+			if err != nil {
+				t.Fatalf("GetConsistencyProof failed for sizes %d, %d: %v", oldSize, size, err)
+			}
+			want, err := tree.ProveConsistency(oldSize, size)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(proof.Path, want) {
+				t.Errorf("unexpected consistency proof for sizes %d, %d", oldSize, size)
+			}
+		}
+	}
+}
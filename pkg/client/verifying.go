@@ -0,0 +1,340 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/dchest/safefile"
+
+	"sigsum.org/sigsum-go/pkg/api"
+	"sigsum.org/sigsum-go/pkg/crypto"
+	"sigsum.org/sigsum-go/pkg/merkle"
+	"sigsum.org/sigsum-go/pkg/policy"
+	"sigsum.org/sigsum-go/pkg/requests"
+	token "sigsum.org/sigsum-go/pkg/submit-token"
+	"sigsum.org/sigsum-go/pkg/types"
+)
+
+const (
+	// Limit on the number of verified tree heads remembered;
+	// when exceeded, all but the latest are forgotten.
+	maxVerifiedTreeHeads = 16
+)
+
+// Errors returned by VerifyingLog, wrapped with details. In addition,
+// a tree head without sufficient cosignatures results in a wrapped
+// *policy.QuorumError.
+var (
+	// The log's signature on a tree head is invalid.
+	ErrInvalidSignature = errors.New("invalid log signature")
+	// A tree head is inconsistent with a previously verified tree
+	// head.
+	ErrInconsistentTreeHead = errors.New("inconsistent tree head")
+	// An inclusion or consistency proof is invalid.
+	ErrInvalidProof = errors.New("invalid proof")
+	// Leaves returned by the log are not included in the latest
+	// verified tree head.
+	ErrInvalidLeaves = errors.New("invalid leaves")
+	// A request refers to a tree size without a verified tree
+	// head.
+	ErrUnknownTreeHead = errors.New("no verified tree head of requested size")
+)
+
+// Persistent storage for the trusted state of a VerifyingLog, i.e.,
+// the latest verified tree head.
+type TreeHeadStore interface {
+	// Returns the stored tree head, or nil if there is none.
+	LoadTreeHead() (*types.SignedTreeHead, error)
+	StoreTreeHead(*types.SignedTreeHead) error
+}
+
+// Stores the tree head in a file, which is replaced atomically.
+type FileTreeHeadStore struct {
+	FileName string
+}
+
+func (s FileTreeHeadStore) LoadTreeHead() (*types.SignedTreeHead, error) {
+	f, err := os.Open(s.FileName)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var sth types.SignedTreeHead
+	if err := sth.FromASCII(f); err != nil {
+		return nil, fmt.Errorf("invalid tree head file %q: %v", s.FileName, err)
+	}
+	return &sth, nil
+}
+
+func (s FileTreeHeadStore) StoreTreeHead(sth *types.SignedTreeHead) error {
+	f, err := safefile.Create(s.FileName, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := sth.ToASCII(f); err != nil {
+		return err
+	}
+	return f.Commit()
+}
+
+type VerifyingConfig struct {
+	LogKey crypto.PublicKey
+	// Policy for witness cosignatures, which must list the log.
+	// If nil, cosignatures are not checked.
+	Policy *policy.Policy
+	// Storage for the latest verified tree head. If nil, the
+	// state is kept in memory only.
+	Store TreeHeadStore
+}
+
+// VerifyingLog wraps an api.Log, e.g., a Client, and verifies
+// everything the log returns, before returning it to the caller:
+// signatures and cosignatures on tree heads, consistency of each tree
+// head with the latest verified tree head, and inclusion and
+// consistency proofs. Tree heads returned to the caller include only
+// verified cosignatures.
+//
+// Proofs and leaves are verified with respect to tree heads
+// previously returned by GetTreeHead or WaitTreeHead, and requests
+// referring to any other tree size fail with ErrUnknownTreeHead.
+type VerifyingLog struct {
+	log    api.Log
+	logKey crypto.PublicKey
+	policy *policy.Policy
+	store  TreeHeadStore
+
+	// Protects all fields below, and is held while verifying a
+	// new tree head, to serialize updates of the trusted state.
+	m sync.Mutex
+	// Latest verified tree head.
+	trusted types.SignedTreeHead
+	// Maps size to root hash, for recently verified tree heads.
+	rootHashes map[uint64]crypto.Hash
+}
+
+// Creates a VerifyingLog, loading the trusted state from the
+// configured store, if any.
+func NewVerifyingLog(log api.Log, cfg VerifyingConfig) (*VerifyingLog, error) {
+	v := VerifyingLog{
+		log:        log,
+		logKey:     cfg.LogKey,
+		policy:     cfg.Policy,
+		store:      cfg.Store,
+		trusted:    types.SignedTreeHead{TreeHead: types.NewEmptyTreeHead()},
+		rootHashes: make(map[uint64]crypto.Hash),
+	}
+	if v.store != nil {
+		sth, err := v.store.LoadTreeHead()
+		if err != nil {
+			return nil, fmt.Errorf("loading tree head failed: %v", err)
+		}
+		if sth != nil {
+			if !sth.Verify(&v.logKey) {
+				return nil, fmt.Errorf("stored tree head: %w", ErrInvalidSignature)
+			}
+			v.trusted = *sth
+		}
+	}
+	v.rootHashes[v.trusted.Size] = v.trusted.RootHash
+	return &v, nil
+}
+
+// Returns the latest verified tree head.
+func (v *VerifyingLog) TrustedTreeHead() types.SignedTreeHead {
+	v.m.Lock()
+	defer v.m.Unlock()
+	return v.trusted
+}
+
+func (v *VerifyingLog) GetTreeHead(ctx context.Context) (types.CosignedTreeHead, error) {
+	cth, err := v.log.GetTreeHead(ctx)
+	if err != nil {
+		return types.CosignedTreeHead{}, err
+	}
+	return v.verifyTreeHead(ctx, &cth)
+}
+
+func (v *VerifyingLog) WaitTreeHead(ctx context.Context, req requests.TreeHead) (types.CosignedTreeHead, error) {
+	cth, err := v.log.WaitTreeHead(ctx, req)
+	if err != nil {
+		return types.CosignedTreeHead{}, err
+	}
+	return v.verifyTreeHead(ctx, &cth)
+}
+
+// Verifies signatures, and consistency with the trusted tree head,
+// which is updated if the new tree head is larger. A tree head
+// smaller than the trusted one, e.g., from a mirror that lags
+// behind, is accepted if it is consistent.
+func (v *VerifyingLog) verifyTreeHead(ctx context.Context, cth *types.CosignedTreeHead) (types.CosignedTreeHead, error) {
+	if !cth.Verify(&v.logKey) {
+		return types.CosignedTreeHead{}, fmt.Errorf("tree size %d: %w", cth.Size, ErrInvalidSignature)
+	}
+	if v.policy != nil {
+		logKeyHash := crypto.HashBytes(v.logKey[:])
+		if err := v.policy.VerifyCosignedTreeHead(&logKeyHash, cth); err != nil {
+			return types.CosignedTreeHead{}, fmt.Errorf("tree size %d: %w", cth.Size, err)
+		}
+		cosignatures, _ := v.policy.VerifyCosignatures(&v.logKey, cth)
+		cth = &types.CosignedTreeHead{SignedTreeHead: cth.SignedTreeHead, Cosignatures: cosignatures}
+	}
+
+	v.m.Lock()
+	defer v.m.Unlock()
+
+	if cth.Size < v.trusted.Size {
+		if err := v.checkConsistency(ctx, &cth.TreeHead, &v.trusted.TreeHead); err != nil {
+			return types.CosignedTreeHead{}, err
+		}
+	} else {
+		if err := v.checkConsistency(ctx, &v.trusted.TreeHead, &cth.TreeHead); err != nil {
+			return types.CosignedTreeHead{}, err
+		}
+		if cth.Size > v.trusted.Size {
+			if v.store != nil {
+				if err := v.store.StoreTreeHead(&cth.SignedTreeHead); err != nil {
+					return types.CosignedTreeHead{}, fmt.Errorf("storing tree head failed: %v", err)
+				}
+			}
+			v.trusted = cth.SignedTreeHead
+		}
+	}
+	if len(v.rootHashes) >= maxVerifiedTreeHeads {
+		v.rootHashes = map[uint64]crypto.Hash{v.trusted.Size: v.trusted.RootHash}
+	}
+	v.rootHashes[cth.Size] = cth.RootHash
+	return *cth, nil
+}
+
+func (v *VerifyingLog) checkConsistency(ctx context.Context, oldTree, newTree *types.TreeHead) error {
+	var proof types.ConsistencyProof
+	if oldTree.Size > 0 && oldTree.Size < newTree.Size {
+		var err error
+		proof, err = v.log.GetConsistencyProof(ctx, requests.ConsistencyProof{OldSize: oldTree.Size, NewSize: newTree.Size})
+		if err != nil {
+			return fmt.Errorf("get-consistency-proof from size %d to %d failed: %v", oldTree.Size, newTree.Size, err)
+		}
+	}
+	if err := proof.Verify(oldTree, newTree); err != nil {
+		return fmt.Errorf("%w, sizes %d and %d: %v", ErrInconsistentTreeHead, oldTree.Size, newTree.Size, err)
+	}
+	return nil
+}
+
+// Returns a verified tree head of the given size.
+func (v *VerifyingLog) getTreeHead(size uint64) (types.TreeHead, error) {
+	v.m.Lock()
+	defer v.m.Unlock()
+	rootHash, ok := v.rootHashes[size]
+	if !ok {
+		return types.TreeHead{}, fmt.Errorf("%w, size %d", ErrUnknownTreeHead, size)
+	}
+	return types.TreeHead{Size: size, RootHash: rootHash}, nil
+}
+
+func (v *VerifyingLog) GetInclusionProof(ctx context.Context, req requests.InclusionProof) (types.InclusionProof, error) {
+	th, err := v.getTreeHead(req.Size)
+	if err != nil {
+		return types.InclusionProof{}, err
+	}
+	proof, err := v.log.GetInclusionProof(ctx, req)
+	if err != nil {
+		return types.InclusionProof{}, err
+	}
+	if err := proof.Verify(&req.LeafHash, &th); err != nil {
+		return types.InclusionProof{}, fmt.Errorf("%w, inclusion of leaf %x in tree size %d: %v",
+			ErrInvalidProof, req.LeafHash, req.Size, err)
+	}
+	return proof, nil
+}
+
+func (v *VerifyingLog) GetConsistencyProof(ctx context.Context, req requests.ConsistencyProof) (types.ConsistencyProof, error) {
+	oldTree, err := v.getTreeHead(req.OldSize)
+	if err != nil {
+		return types.ConsistencyProof{}, err
+	}
+	newTree, err := v.getTreeHead(req.NewSize)
+	if err != nil {
+		return types.ConsistencyProof{}, err
+	}
+	proof, err := v.log.GetConsistencyProof(ctx, req)
+	if err != nil {
+		return types.ConsistencyProof{}, err
+	}
+	if err := proof.Verify(&oldTree, &newTree); err != nil {
+		return types.ConsistencyProof{}, fmt.Errorf("%w, consistency from size %d to %d: %v",
+			ErrInvalidProof, req.OldSize, req.NewSize, err)
+	}
+	return proof, nil
+}
+
+// Verifies that the returned leaves are included in the latest
+// verified tree head, using inclusion proofs for the first and last
+// leaf. The request's EndIndex must not exceed the size of that tree
+// head.
+func (v *VerifyingLog) GetLeaves(ctx context.Context, req requests.Leaves) ([]types.Leaf, error) {
+	th := v.TrustedTreeHead().TreeHead
+	if req.EndIndex > th.Size {
+		return nil, fmt.Errorf("%w, get-leaves end index %d exceeds size %d",
+			ErrUnknownTreeHead, req.EndIndex, th.Size)
+	}
+	leaves, err := v.log.GetLeaves(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	if len(leaves) == 0 || uint64(len(leaves)) > req.EndIndex-req.StartIndex {
+		return nil, fmt.Errorf("%w, got %d leaves, requested %d",
+			ErrInvalidLeaves, len(leaves), req.EndIndex-req.StartIndex)
+	}
+	leafHashes := make([]crypto.Hash, 0, len(leaves))
+	for _, leaf := range leaves {
+		leafHashes = append(leafHashes, leaf.ToHash())
+	}
+	end := req.StartIndex + uint64(len(leaves))
+
+	getProof := func(index uint64) ([]crypto.Hash, error) {
+		proof, err := v.log.GetInclusionProof(ctx, requests.InclusionProof{
+			Size: th.Size, LeafHash: leafHashes[index-req.StartIndex]})
+		if err != nil {
+			return nil, fmt.Errorf("get-inclusion-proof for leaf %d failed: %v", index, err)
+		}
+		if proof.LeafIndex != index {
+			return nil, fmt.Errorf("%w, leaf %d found at index %d", ErrInvalidLeaves, index, proof.LeafIndex)
+		}
+		return proof.Path, nil
+	}
+	startPath, err := getProof(req.StartIndex)
+	if err != nil {
+		return nil, err
+	}
+	if len(leaves) == 1 {
+		err = merkle.VerifyInclusion(&leafHashes[0], req.StartIndex, th.Size, &th.RootHash, startPath)
+	} else if end == th.Size {
+		err = merkle.VerifyInclusionTail(leafHashes, req.StartIndex, &th.RootHash, startPath)
+	} else {
+		var endPath []crypto.Hash
+		if endPath, err = getProof(end - 1); err != nil {
+			return nil, err
+		}
+		err = merkle.VerifyInclusionBatch(leafHashes, req.StartIndex, th.Size, &th.RootHash, startPath, endPath)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w, range %d:%d not included in tree size %d: %v",
+			ErrInvalidLeaves, req.StartIndex, end, th.Size, err)
+	}
+	return leaves, nil
+}
+
+// Add-leaf responses include nothing to verify; inclusion of the leaf
+// is verified by subsequent GetInclusionProof calls.
+func (v *VerifyingLog) AddLeaf(ctx context.Context, req requests.Leaf, header *token.SubmitHeader) (bool, error) {
+	return v.log.AddLeaf(ctx, req, header)
+}
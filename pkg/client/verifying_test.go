@@ -0,0 +1,170 @@
+package client_test
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"sigsum.org/sigsum-go/pkg/api"
+	"sigsum.org/sigsum-go/pkg/client"
+	"sigsum.org/sigsum-go/pkg/crypto"
+	"sigsum.org/sigsum-go/pkg/logserver"
+	"sigsum.org/sigsum-go/pkg/policy"
+	"sigsum.org/sigsum-go/pkg/requests"
+	"sigsum.org/sigsum-go/pkg/types"
+)
+
+func newTestLog(t *testing.T, signer crypto.Signer, size int, firstMsg byte) *logserver.Log {
+	t.Helper()
+	l, err := logserver.New(&logserver.Config{Signer: signer})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	addTestLeaves(t, l, size, firstMsg)
+	return l
+}
+
+func addTestLeaves(t *testing.T, l *logserver.Log, n int, firstMsg byte) {
+	t.Helper()
+	ctx := context.Background()
+	submitSigner := crypto.NewEd25519Signer(&crypto.PrivateKey{2})
+	for i := 0; i < n; i++ {
+		msg := crypto.Hash{firstMsg + byte(i)}
+		signature, err := types.SignLeafMessage(submitSigner, msg[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := l.AddLeaf(ctx, requests.Leaf{Message: msg, Signature: signature, PublicKey: submitSigner.Public()}, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := l.Publish(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Modifies the second leaf of each get-leaves response, so that it
+// can be detected only by verifying the complete range.
+type tamperingLog struct {
+	api.Log
+}
+
+func (l tamperingLog) GetLeaves(ctx context.Context, req requests.Leaves) ([]types.Leaf, error) {
+	leaves, err := l.Log.GetLeaves(ctx, req)
+	if err == nil && len(leaves) > 1 {
+		leaves[1].Checksum[0] ^= 1
+	}
+	return leaves, err
+}
+
+func TestVerifyingLog(t *testing.T) {
+	ctx := context.Background()
+	logSigner := crypto.NewEd25519Signer(&crypto.PrivateKey{1})
+	l := newTestLog(t, logSigner, 5, 0)
+	store := client.FileTreeHeadStore{FileName: filepath.Join(t.TempDir(), "tree-head")}
+	v, err := client.NewVerifyingLog(l, client.VerifyingConfig{LogKey: logSigner.Public(), Store: store})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cth, err := v.GetTreeHead(ctx)
+	if err != nil {
+		t.Fatalf("GetTreeHead failed: %v", err)
+	}
+	if cth.Size != 5 {
+		t.Fatalf("unexpected tree size %d, want 5", cth.Size)
+	}
+	addTestLeaves(t, l, 5, 5)
+	if cth, err = v.GetTreeHead(ctx); err != nil {
+		t.Fatalf("GetTreeHead failed: %v", err)
+	}
+	if _, err := v.GetConsistencyProof(ctx, requests.ConsistencyProof{OldSize: 5, NewSize: 10}); err != nil {
+		t.Errorf("GetConsistencyProof failed: %v", err)
+	}
+	for _, r := range [][2]uint64{{0, 1}, {2, 7}, {3, 10}, {9, 10}} {
+		leaves, err := v.GetLeaves(ctx, requests.Leaves{StartIndex: r[0], EndIndex: r[1]})
+		if err != nil {
+			t.Errorf("GetLeaves %d:%d failed: %v", r[0], r[1], err)
+			continue
+		}
+		proof, err := v.GetInclusionProof(ctx, requests.InclusionProof{Size: 10, LeafHash: leaves[0].ToHash()})
+		if err != nil {
+			t.Errorf("GetInclusionProof failed: %v", err)
+		} else if proof.LeafIndex != r[0] {
+			t.Errorf("unexpected leaf index %d, want %d", proof.LeafIndex, r[0])
+		}
+	}
+
+	for _, table := range []struct {
+		desc string
+		f    func() error
+		want error
+	}{
+		{"unknown size", func() error {
+			_, err := v.GetInclusionProof(ctx, requests.InclusionProof{Size: 7, LeafHash: crypto.Hash{1}})
+			return err
+		}, client.ErrUnknownTreeHead},
+		{"leaves beyond tree head", func() error {
+			_, err := v.GetLeaves(ctx, requests.Leaves{StartIndex: 5, EndIndex: 11})
+			return err
+		}, client.ErrUnknownTreeHead},
+	} {
+		if err := table.f(); !errors.Is(err, table.want) {
+			t.Errorf("%s: unexpected error %v, want %v", table.desc, err, table.want)
+		}
+	}
+
+	// A new instance loads the stored tree head.
+	v, err = client.NewVerifyingLog(tamperingLog{l}, client.VerifyingConfig{LogKey: logSigner.Public(), Store: store})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := v.TrustedTreeHead(); got != cth.SignedTreeHead {
+		t.Errorf("unexpected loaded tree head, got %v, want %v", got, cth.SignedTreeHead)
+	}
+	if _, err := v.GetLeaves(ctx, requests.Leaves{StartIndex: 2, EndIndex: 7}); !errors.Is(err, client.ErrInvalidLeaves) {
+		t.Errorf("tampered leaves: unexpected error %v", err)
+	}
+
+	// A log with the same key, but different leaves.
+	fork := newTestLog(t, logSigner, 12, 100)
+	v, err = client.NewVerifyingLog(fork, client.VerifyingConfig{LogKey: logSigner.Public(), Store: store})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := v.GetTreeHead(ctx); !errors.Is(err, client.ErrInconsistentTreeHead) {
+		t.Errorf("forked log: unexpected error %v", err)
+	}
+	// A log with a different key.
+	v, err = client.NewVerifyingLog(newTestLog(t, crypto.NewEd25519Signer(&crypto.PrivateKey{3}), 1, 0),
+		client.VerifyingConfig{LogKey: logSigner.Public()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := v.GetTreeHead(ctx); !errors.Is(err, client.ErrInvalidSignature) {
+		t.Errorf("wrong log key: unexpected error %v", err)
+	}
+}
+
+func TestVerifyingLogQuorum(t *testing.T) {
+	logSigner := crypto.NewEd25519Signer(&crypto.PrivateKey{1})
+	witnessKey := crypto.NewEd25519Signer(&crypto.PrivateKey{4}).Public()
+	p, err := policy.NewKofNPolicy([]crypto.PublicKey{logSigner.Public()}, []crypto.PublicKey{witnessKey}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := client.NewVerifyingLog(newTestLog(t, logSigner, 1, 0),
+		client.VerifyingConfig{LogKey: logSigner.Public(), Policy: p})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = v.GetTreeHead(context.Background())
+	var quorumErr *policy.QuorumError
+	if !errors.As(err, &quorumErr) {
+		t.Fatalf("unexpected error %v, want quorum error", err)
+	}
+	if got, want := len(quorumErr.Missing), 1; got != want {
+		t.Errorf("unexpected number of missing witnesses, got %d, want %d", got, want)
+	}
+}
@@ -0,0 +1,134 @@
+package client_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"sigsum.org/sigsum-go/pkg/client"
+	"sigsum.org/sigsum-go/pkg/crypto"
+	"sigsum.org/sigsum-go/pkg/logserver"
+	"sigsum.org/sigsum-go/pkg/requests"
+	"sigsum.org/sigsum-go/pkg/server"
+	"sigsum.org/sigsum-go/pkg/types"
+)
+
+func TestWaitTreeHead(t *testing.T) {
+	submitSigner := crypto.NewEd25519Signer(&crypto.PrivateKey{2})
+	for _, table := range []struct {
+		desc string
+		// If set, the server doesn't support waiting.
+		noWait bool
+	}{
+		{"waiting", false},
+		{"polling", true},
+	} {
+		l, err := logserver.New(&logserver.Config{Signer: crypto.NewEd25519Signer(&crypto.PrivateKey{1})})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer l.Close()
+		var handler http.Handler = server.NewLog(&server.Config{MaxWait: 20 * time.Millisecond}, l)
+		if table.noWait {
+			logHandler := handler
+			handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if strings.HasPrefix(r.URL.Path, "/"+string(types.EndpointGetTreeHead)+"/") {
+					http.NotFound(w, r)
+					return
+				}
+				logHandler.ServeHTTP(w, r)
+			})
+		}
+		s := httptest.NewServer(handler)
+		defer s.Close()
+
+		cli := client.New(client.Config{URL: s.URL, PollDelay: 10 * time.Millisecond})
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		go func() {
+			// Wait a bit, so that the server's limit on
+			// waiting expires at least once.
+			time.Sleep(50 * time.Millisecond)
+			var msg crypto.Hash
+			signature, err := types.SignLeafMessage(submitSigner, msg[:])
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if _, err := l.AddLeaf(ctx, requests.Leaf{Message: msg, Signature: signature, PublicKey: submitSigner.Public()}, nil); err != nil {
+				t.Error(err)
+				return
+			}
+			if err := l.Publish(ctx); err != nil {
+				t.Error(err)
+			}
+		}()
+		cth, err := cli.WaitTreeHead(ctx, requests.TreeHead{OldSize: 0})
+		if err != nil {
+			t.Fatalf("%s: WaitTreeHead failed: %v", table.desc, err)
+		}
+		if cth.Size != 1 {
+			t.Errorf("%s: unexpected tree size %d, want 1", table.desc, cth.Size)
+		}
+	}
+}
+
+// When polling, the caller already has a fresh tree head, so the
+// first get-tree-head request is made only after the poll delay.
+func TestWaitTreeHeadPollDelay(t *testing.T) {
+	submitSigner := crypto.NewEd25519Signer(&crypto.PrivateKey{2})
+	l, err := logserver.New(&logserver.Config{Signer: crypto.NewEd25519Signer(&crypto.PrivateKey{1})})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var msg crypto.Hash
+	signature, err := types.SignLeafMessage(submitSigner, msg[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.AddLeaf(ctx, requests.Leaf{Message: msg, Signature: signature, PublicKey: submitSigner.Public()}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Publish(ctx); err != nil {
+		t.Fatal(err)
+	}
+	logHandler := server.NewLog(&server.Config{}, l)
+	var treeHeads atomic.Int32
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/"+string(types.EndpointGetTreeHead)+"/") {
+			http.NotFound(w, r)
+			return
+		}
+		if strings.HasPrefix(r.URL.Path, "/"+string(types.EndpointGetTreeHead)) {
+			treeHeads.Add(1)
+		}
+		logHandler.ServeHTTP(w, r)
+	}))
+	defer s.Close()
+
+	pollDelay := 50 * time.Millisecond
+	cli := client.New(client.Config{URL: s.URL, PollDelay: pollDelay})
+	start := time.Now()
+	cth, err := cli.WaitTreeHead(ctx, requests.TreeHead{OldSize: 0})
+	if err != nil {
+		t.Fatalf("WaitTreeHead failed: %v", err)
+	}
+	if cth.Size != 1 {
+		t.Errorf("unexpected tree size %d, want 1", cth.Size)
+	}
+	if elapsed := time.Since(start); elapsed < pollDelay {
+		t.Errorf("polled without delay, after %v", elapsed)
+	}
+	if got := treeHeads.Load(); got != 1 {
+		t.Errorf("unexpected number of get-tree-head requests, got %d, want 1", got)
+	}
+}
@@ -0,0 +1,248 @@
+// Package collector implements the log side of the witness
+// protocol, see https://github.com/C2SP/C2SP/blob/main/tlog-witness.md.
+// A Collector submits a log's signed tree heads, as checkpoints, to
+// all witnesses listed with a URL in a policy, and collects the
+// resulting cosignatures.
+//
+// For each witness, the collector keeps track of the tree size of
+// the latest checkpoint cosigned by that witness, which determines
+// the consistency proof included in the next request. This state can
+// be persisted to a file, so that a restarted log doesn't need to
+// rediscover it by getting a conflict response from every witness.
+package collector
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"sigsum.org/sigsum-go/pkg/api"
+	"sigsum.org/sigsum-go/pkg/checkpoint"
+	"sigsum.org/sigsum-go/pkg/crypto"
+	"sigsum.org/sigsum-go/pkg/log"
+	"sigsum.org/sigsum-go/pkg/policy"
+	"sigsum.org/sigsum-go/pkg/requests"
+	"sigsum.org/sigsum-go/pkg/types"
+)
+
+const (
+	DefaultTimeout = 10 * time.Second
+	// Number of AddCheckpoint attempts per witness, where each
+	// conflict response tells us the witness' current size.
+	maxAttempts = 3
+)
+
+// Source of consistency proofs, between tree heads of the log, e.g.,
+// the log itself.
+type ProofSource interface {
+	GetConsistencyProof(context.Context, requests.ConsistencyProof) (types.ConsistencyProof, error)
+}
+
+type Config struct {
+	// File where the per-witness state is stored. If empty, state
+	// is kept in memory only.
+	StateFile string
+	// Timeout for each witness request. Zero implies a default
+	// timeout.
+	Timeout time.Duration
+}
+
+func (c *Config) withDefaults() Config {
+	config := *c
+	if config.Timeout <= 0 {
+		config.Timeout = DefaultTimeout
+	}
+	return config
+}
+
+type witness struct {
+	keyHash   crypto.Hash
+	publicKey crypto.PublicKey
+	url       string
+	client    api.Witness
+}
+
+type Collector struct {
+	config    Config
+	policy    *policy.Policy
+	logKey    crypto.PublicKey
+	origin    string
+	witnesses []witness
+
+	// Protects sizes, and the state file. Maps witness key hash
+	// to size of latest cosigned tree head.
+	m     sync.Mutex
+	sizes map[crypto.Hash]uint64
+}
+
+// Creates a collector for the log with the given public key, for all
+// witnesses in the policy that have a URL. The newClient function
+// creates a client for the witness at a given URL, typically using
+// client.New. If a state file is configured, previous state is loaded
+// from it.
+func New(c *Config, p *policy.Policy, logKey *crypto.PublicKey, newClient func(url string) api.Witness) (*Collector, error) {
+	config := c.withDefaults()
+	var witnesses []witness
+	for _, entity := range p.GetWitnessesWithUrl() {
+		witnesses = append(witnesses, witness{
+			keyHash:   crypto.HashBytes(entity.PublicKey[:]),
+			publicKey: entity.PublicKey,
+			url:       entity.URL,
+			client:    newClient(entity.URL),
+		})
+	}
+	return newCollector(&config, p, logKey, witnesses)
+}
+
+func newCollector(config *Config, p *policy.Policy, logKey *crypto.PublicKey, witnesses []witness) (*Collector, error) {
+	sizes := make(map[crypto.Hash]uint64)
+	if len(config.StateFile) > 0 {
+		var err error
+		sizes, err = readState(config.StateFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading witness state failed: %v", err)
+		}
+	}
+	return &Collector{
+		config:    *config,
+		policy:    p,
+		logKey:    *logKey,
+		origin:    types.SigsumCheckpointOrigin(logKey),
+		witnesses: witnesses,
+		sizes:     sizes,
+	}, nil
+}
+
+// Collects cosignatures on the given signed tree head, from all
+// witnesses concurrently. The returned tree head includes all
+// verified cosignatures. The returned error is non-nil if the
+// collected cosignatures don't satisfy the policy's quorum, and in
+// this case the returned tree head is still valid, but with
+// insufficient cosignatures. Failures for individual witnesses are
+// logged, but not otherwise reported.
+func (c *Collector) Collect(ctx context.Context, proofs ProofSource, sth *types.SignedTreeHead) (types.CosignedTreeHead, error) {
+	cp := checkpoint.Checkpoint{
+		SignedTreeHead: *sth,
+		Origin:         c.origin,
+		KeyId:          checkpoint.NewLogKeyId(c.origin, &c.logKey),
+	}
+	type result struct {
+		keyHash     crypto.Hash
+		cosignature types.Cosignature
+	}
+	results := make(chan result, len(c.witnesses))
+
+	var wg sync.WaitGroup
+	for _, w := range c.witnesses {
+		wg.Add(1)
+		go func(w witness) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(ctx, c.config.Timeout)
+			defer cancel()
+
+			cs, err := c.addCheckpoint(ctx, proofs, &w, &cp)
+			if err != nil {
+				log.Warning("Collecting cosignature from witness %q failed: %v", w.url, err)
+				return
+			}
+			results <- result{keyHash: w.keyHash, cosignature: cs}
+		}(w)
+	}
+	wg.Wait()
+	close(results)
+
+	cth := types.CosignedTreeHead{
+		SignedTreeHead: *sth,
+		Cosignatures:   make(map[crypto.Hash]types.Cosignature),
+	}
+	verified := make(map[crypto.Hash]struct{})
+	for r := range results {
+		cth.Cosignatures[r.keyHash] = r.cosignature
+		verified[r.keyHash] = struct{}{}
+	}
+	if err := c.storeState(); err != nil {
+		log.Error("Storing witness state failed: %v", err)
+	}
+	if !c.policy.IsQuorum(verified) {
+		return cth, fmt.Errorf("no witness quorum for tree size %d, got %d of %d cosignatures",
+			sth.Size, len(verified), len(c.witnesses))
+	}
+	return cth, nil
+}
+
+func (c *Collector) getSize(keyHash *crypto.Hash) uint64 {
+	c.m.Lock()
+	defer c.m.Unlock()
+	return c.sizes[*keyHash]
+}
+
+func (c *Collector) setSize(keyHash *crypto.Hash, size uint64) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	c.sizes[*keyHash] = size
+}
+
+func (c *Collector) storeState() error {
+	if len(c.config.StateFile) == 0 {
+		return nil
+	}
+	c.m.Lock()
+	defer c.m.Unlock()
+	return storeState(c.config.StateFile, c.sizes)
+}
+
+// Requests a cosignature from a single witness. On a conflict
+// response, retries using the old size reported by the witness.
+func (c *Collector) addCheckpoint(ctx context.Context, proofs ProofSource, w *witness, cp *checkpoint.Checkpoint) (types.Cosignature, error) {
+	oldSize := c.getSize(&w.keyHash)
+	for attempt := 1; ; attempt++ {
+		if oldSize > cp.Size {
+			return types.Cosignature{}, fmt.Errorf("witness has size %d, larger than tree size %d", oldSize, cp.Size)
+		}
+		var proof types.ConsistencyProof
+		if oldSize > 0 && oldSize < cp.Size {
+			var err error
+			proof, err = proofs.GetConsistencyProof(ctx, requests.ConsistencyProof{OldSize: oldSize, NewSize: cp.Size})
+			if err != nil {
+				return types.Cosignature{}, fmt.Errorf("getting consistency proof from size %d failed: %v", oldSize, err)
+			}
+		}
+		lines, err := w.client.AddCheckpoint(ctx, requests.AddCheckpoint{
+			OldSize:    oldSize,
+			Proof:      proof,
+			Checkpoint: *cp,
+		})
+		if err == nil {
+			cs, err := c.verifyCosignature(w, lines, &cp.TreeHead)
+			if err != nil {
+				return types.Cosignature{}, err
+			}
+			c.setSize(&w.keyHash, cp.Size)
+			return cs, nil
+		}
+		size, ok := api.ErrorConflictOldSize(err)
+		if !ok || attempt >= maxAttempts {
+			return types.Cosignature{}, err
+		}
+		log.Debug("Witness %q has size %d, expected %d, retrying", w.url, size, oldSize)
+		c.setSize(&w.keyHash, size)
+		oldSize = size
+	}
+}
+
+// Finds and verifies the witness' cosignature among the returned
+// cosignature lines. The key name used by the witness isn't known,
+// so lines are matched by key id only.
+func (c *Collector) verifyCosignature(w *witness, lines []checkpoint.CosignatureLine, th *types.TreeHead) (types.Cosignature, error) {
+	for _, line := range lines {
+		if line.KeyId != checkpoint.NewWitnessKeyId(line.KeyName, &w.publicKey) {
+			continue
+		}
+		if !line.Cosignature.Verify(&w.publicKey, c.origin, th) {
+			return types.Cosignature{}, fmt.Errorf("invalid cosignature")
+		}
+		return line.Cosignature, nil
+	}
+	return types.Cosignature{}, fmt.Errorf("no cosignature by expected key, got %d cosignature lines", len(lines))
+}
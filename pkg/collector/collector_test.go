@@ -0,0 +1,170 @@
+package collector
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"sigsum.org/sigsum-go/pkg/api"
+	"sigsum.org/sigsum-go/pkg/checkpoint"
+	"sigsum.org/sigsum-go/pkg/crypto"
+	"sigsum.org/sigsum-go/pkg/merkle"
+	"sigsum.org/sigsum-go/pkg/policy"
+	"sigsum.org/sigsum-go/pkg/requests"
+	"sigsum.org/sigsum-go/pkg/types"
+)
+
+// A minimal witness, which checks old size and consistency.
+type testWitness struct {
+	signer crypto.Signer
+	// If set, produce invalid cosignatures.
+	bad   bool
+	th    types.TreeHead
+	calls int
+}
+
+func (w *testWitness) AddCheckpoint(_ context.Context, req requests.AddCheckpoint) ([]checkpoint.CosignatureLine, error) {
+	w.calls++
+	if req.OldSize != w.th.Size {
+		return nil, api.ErrConflict.WithOldSize(w.th.Size)
+	}
+	if req.OldSize > 0 {
+		if err := req.Proof.Verify(&w.th, &req.Checkpoint.TreeHead); err != nil {
+			return nil, api.ErrUnprocessableEntity.WithError(err)
+		}
+	}
+	cs, err := req.Checkpoint.Cosign(w.signer, 17)
+	if err != nil {
+		return nil, err
+	}
+	if w.bad {
+		cs.Signature[0] ^= 1
+	}
+	w.th = req.Checkpoint.TreeHead
+	pub := w.signer.Public()
+	return []checkpoint.CosignatureLine{{
+		KeyName:     "example.org/witness",
+		KeyId:       checkpoint.NewWitnessKeyId("example.org/witness", &pub),
+		Cosignature: cs,
+	}}, nil
+}
+
+type testProofs struct {
+	tree *merkle.Tree
+}
+
+func (p testProofs) GetConsistencyProof(_ context.Context, req requests.ConsistencyProof) (types.ConsistencyProof, error) {
+	path, err := p.tree.ProveConsistency(req.OldSize, req.NewSize)
+	return types.ConsistencyProof{Path: path}, err
+}
+
+func newTestTree(t *testing.T, size uint64) *merkle.Tree {
+	tree := merkle.NewTree()
+	for i := uint64(0); i < size; i++ {
+		var h crypto.Hash
+		binary.BigEndian.PutUint64(h[:], i)
+		tree.AddLeafHash(&h)
+	}
+	return &tree
+}
+
+func signTree(t *testing.T, signer crypto.Signer, size uint64) types.SignedTreeHead {
+	t.Helper()
+	th := types.TreeHead{Size: size, RootHash: newTestTree(t, size).GetRootHash()}
+	sth, err := th.Sign(signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sth
+}
+
+func newTestCollector(t *testing.T, stateFile string, k int, logKey *crypto.PublicKey, witnesses []*testWitness) *Collector {
+	t.Helper()
+	var keys []crypto.PublicKey
+	var ws []witness
+	for i, w := range witnesses {
+		pub := w.signer.Public()
+		keys = append(keys, pub)
+		ws = append(ws, witness{
+			keyHash:   crypto.HashBytes(pub[:]),
+			publicKey: pub,
+			url:       fmt.Sprintf("https://witness-%d.example.org", i),
+			client:    w,
+		})
+	}
+	p, err := policy.NewKofNPolicy([]crypto.PublicKey{*logKey}, keys, k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := newCollector(&Config{StateFile: stateFile, Timeout: DefaultTimeout}, p, logKey, ws)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestCollect(t *testing.T) {
+	logSigner := crypto.NewEd25519Signer(&crypto.PrivateKey{1})
+	logKey := logSigner.Public()
+	proofs := testProofs{newTestTree(t, 20)}
+	ctx := context.Background()
+	stateFile := filepath.Join(t.TempDir(), "witness-state")
+
+	witnesses := []*testWitness{
+		{signer: crypto.NewEd25519Signer(&crypto.PrivateKey{2})},
+		// Witness that has already cosigned a tree head of
+		// size 5, unknown to the collector.
+		{signer: crypto.NewEd25519Signer(&crypto.PrivateKey{3}),
+			th: types.TreeHead{Size: 5, RootHash: newTestTree(t, 5).GetRootHash()}},
+		{signer: crypto.NewEd25519Signer(&crypto.PrivateKey{4}), bad: true},
+	}
+	c := newTestCollector(t, stateFile, 2, &logKey, witnesses)
+
+	sth := signTree(t, logSigner, 10)
+	cth, err := c.Collect(ctx, proofs, &sth)
+	if err != nil {
+		t.Fatalf("Collect failed: %v", err)
+	}
+	if got, want := len(cth.Cosignatures), 2; got != want {
+		t.Errorf("unexpected number of cosignatures, got %d, want %d", got, want)
+	}
+	origin := types.SigsumCheckpointOrigin(&logKey)
+	for _, w := range witnesses[:2] {
+		pub := w.signer.Public()
+		cs, ok := cth.Cosignatures[crypto.HashBytes(pub[:])]
+		if !ok || !cs.Verify(&pub, origin, &cth.TreeHead) {
+			t.Errorf("missing or invalid cosignature for witness %x", pub)
+		}
+	}
+	if got, want := witnesses[1].calls, 2; got != want {
+		t.Errorf("unexpected number of requests to witness with unknown size, got %d, want %d", got, want)
+	}
+
+	// A new collector, loading the stored state, should not get
+	// any conflicts.
+	for _, w := range witnesses {
+		w.calls = 0
+	}
+	c = newTestCollector(t, stateFile, 2, &logKey, witnesses)
+	sth = signTree(t, logSigner, 20)
+	if _, err := c.Collect(ctx, proofs, &sth); err != nil {
+		t.Fatalf("Collect failed: %v", err)
+	}
+	for i, w := range witnesses[:2] {
+		if w.calls != 1 {
+			t.Errorf("unexpected number of requests to witness %d, got %d, want 1", i, w.calls)
+		}
+	}
+
+	// Require all three witnesses.
+	c = newTestCollector(t, stateFile, 3, &logKey, witnesses)
+	cth, err = c.Collect(ctx, proofs, &sth)
+	if err == nil {
+		t.Errorf("Collect succeeded without quorum")
+	}
+	if got, want := len(cth.Cosignatures), 2; got != want {
+		t.Errorf("unexpected number of cosignatures, got %d, want %d", got, want)
+	}
+}
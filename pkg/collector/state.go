@@ -0,0 +1,80 @@
+package collector
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/dchest/safefile"
+
+	"sigsum.org/sigsum-go/pkg/ascii"
+	"sigsum.org/sigsum-go/pkg/crypto"
+)
+
+// The state file lists, for each witness, the tree size of the latest
+// checkpoint the witness is known to have cosigned, as pairs of lines
+//
+//	key_hash=<hex-encoded hash of the witness' public key>
+//	size=<tree size>
+//
+// Witnesses not listed are assumed to have cosigned nothing.
+func readState(fileName string) (map[crypto.Hash]uint64, error) {
+	f, err := os.Open(fileName)
+	if errors.Is(err, os.ErrNotExist) {
+		return map[crypto.Hash]uint64{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseState(f)
+}
+
+func parseState(r io.Reader) (map[crypto.Hash]uint64, error) {
+	sizes := make(map[crypto.Hash]uint64)
+	p := ascii.NewParser(r)
+	for {
+		keyHash, err := p.GetHash("key_hash")
+		if err == io.EOF {
+			return sizes, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		size, err := p.GetInt("size")
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := sizes[keyHash]; ok {
+			return nil, fmt.Errorf("duplicate witness %x", keyHash)
+		}
+		sizes[keyHash] = size
+	}
+}
+
+func writeState(w io.Writer, sizes map[crypto.Hash]uint64) error {
+	for keyHash, size := range sizes {
+		if err := ascii.WriteHash(w, "key_hash", &keyHash); err != nil {
+			return err
+		}
+		if err := ascii.WriteInt(w, "size", size); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func storeState(fileName string, sizes map[crypto.Hash]uint64) error {
+	f, err := safefile.Create(fileName, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := writeState(f, sizes); err != nil {
+		return err
+	}
+	// Atomically replace old file with new.
+	return f.Commit()
+}
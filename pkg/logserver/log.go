@@ -0,0 +1,480 @@
+// Package logserver implements a simple Sigsum log, i.e., the
+// api.Log interface backed by a merkle.Tree. Leaves and the latest
+// signed tree head can optionally be persisted to a local
+// directory. To serve the log over HTTP, pass it to server.NewLog.
+//
+// Submitted leaves are kept in a pending set until the next
+// publishing interval, when they are appended to the tree (and
+// persisted), and a new tree head is signed. If a witness collector
+// is configured, the new tree head is published only after
+// cosignatures have been collected from a quorum of witnesses. If a
+// secondary node is configured, the published tree head is limited to
+// the part of the tree the secondary has replicated, see the
+// secondary package. This implementation doesn't enforce any rate
+// limits; any Sigsum-Token header is ignored.
+package logserver
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"sigsum.org/sigsum-go/pkg/api"
+	"sigsum.org/sigsum-go/pkg/collector"
+	"sigsum.org/sigsum-go/pkg/crypto"
+	"sigsum.org/sigsum-go/pkg/log"
+	"sigsum.org/sigsum-go/pkg/merkle"
+	"sigsum.org/sigsum-go/pkg/requests"
+	"sigsum.org/sigsum-go/pkg/secondary"
+	token "sigsum.org/sigsum-go/pkg/submit-token"
+	"sigsum.org/sigsum-go/pkg/types"
+)
+
+const (
+	DefaultInterval = 30 * time.Second
+	// Maximum number of leaves returned by a single GetLeaves
+	// call.
+	DefaultMaxLeaves = 512
+)
+
+type Config struct {
+	// Signer for the log's tree heads.
+	Signer crypto.Signer
+	// Directory where leaves and the latest published tree head
+	// are stored. If empty, the log is kept in memory only.
+	Directory string
+	// Interval between publishing of tree heads. Zero implies a
+	// default interval.
+	Interval time.Duration
+	// Maximum number of leaves to return from a GetLeaves
+	// call. Zero implies a default.
+	MaxLeaves uint64
+	// Optional collector of witness cosignatures. If set, each
+	// publishing of a tree head includes collecting
+	// cosignatures, and fails unless the witness quorum is
+	// satisfied.
+	Collector *collector.Collector
+	// Optional checker for a secondary node, replicating the
+	// log. If set, a tree head is published only for leaves the
+	// secondary has replicated, typically lagging one interval
+	// behind the sequenced leaves.
+	Secondary *secondary.Checker
+}
+
+func (c *Config) withDefaults() Config {
+	config := *c
+	if config.Interval <= 0 {
+		config.Interval = DefaultInterval
+	}
+	if config.MaxLeaves == 0 {
+		config.MaxLeaves = DefaultMaxLeaves
+	}
+	return config
+}
+
+// Implements api.Log.
+type Log struct {
+	config    Config
+	storage   *storage // nil if not persisted.
+	publicKey crypto.PublicKey
+
+	// Serializes calls to Publish.
+	publishing sync.Mutex
+
+	// Protects all fields below.
+	m      sync.RWMutex
+	tree   merkle.Tree
+	leaves []types.Leaf
+	// Pending leaves, in order of submission, and corresponding
+	// set of leaf hashes.
+	pending       []types.Leaf
+	pendingHashes map[crypto.Hash]struct{}
+	// Latest signed tree head, which may not yet be published.
+	sth types.SignedTreeHead
+	// Latest published tree head.
+	cth types.CosignedTreeHead
+	// Closed, and replaced, when a new tree head is published.
+	published chan struct{}
+}
+
+// Creates a new log. If a directory is configured, any previous
+// state is loaded from it. A log without any previously signed tree
+// head starts out with a signed empty tree head. Initially, the
+// latest signed tree head is published without any cosignatures.
+func New(c *Config) (*Log, error) {
+	if c.Signer == nil {
+		return nil, fmt.Errorf("no signer configured")
+	}
+	l := &Log{
+		config:        c.withDefaults(),
+		publicKey:     c.Signer.Public(),
+		tree:          merkle.NewTree(),
+		pendingHashes: make(map[crypto.Hash]struct{}),
+		published:     make(chan struct{}),
+	}
+	var sth *types.SignedTreeHead
+	if len(l.config.Directory) > 0 {
+		var err error
+		l.storage, l.leaves, sth, err = openStorage(l.config.Directory)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := l.load(sth); err != nil {
+		l.Close()
+		return nil, err
+	}
+	l.cth = types.CosignedTreeHead{SignedTreeHead: l.sth}
+	return l, nil
+}
+
+// Rebuilds the tree from stored leaves, and checks that the stored
+// tree head is valid and consistent with the leaves.
+func (l *Log) load(sth *types.SignedTreeHead) error {
+	if sth != nil {
+		if !sth.Verify(&l.publicKey) {
+			return fmt.Errorf("invalid signature on stored tree head")
+		}
+		if sth.Size > uint64(len(l.leaves)) {
+			return fmt.Errorf("stored tree head size %d exceeds number of stored leaves %d",
+				sth.Size, len(l.leaves))
+		}
+	}
+	for i, leaf := range l.leaves {
+		if sth != nil && uint64(i) == sth.Size {
+			if l.tree.GetRootHash() != sth.RootHash {
+				return fmt.Errorf("stored tree head inconsistent with stored leaves")
+			}
+		}
+		h := leaf.ToHash()
+		if !l.tree.AddLeafHash(&h) {
+			return fmt.Errorf("duplicate stored leaf at index %d", i)
+		}
+	}
+	if sth == nil {
+		return l.sign()
+	}
+	if sth.Size == l.tree.Size() && l.tree.GetRootHash() != sth.RootHash {
+		return fmt.Errorf("stored tree head inconsistent with stored leaves")
+	}
+	l.sth = *sth
+	if sth.Size < l.tree.Size() {
+		// Leaves were sequenced, but no corresponding tree
+		// head signed before shutdown.
+		return l.sign()
+	}
+	return nil
+}
+
+func (l *Log) Close() error {
+	if l.storage != nil {
+		return l.storage.close()
+	}
+	return nil
+}
+
+// Publish appends all pending leaves to the tree, signs a new tree
+// head, and, if a collector is configured, collects witness
+// cosignatures. The tree head is then published, i.e., returned by
+// GetTreeHead. Cosignatures are collected also when the tree hasn't
+// grown, to keep witness timestamps fresh.
+func (l *Log) Publish(ctx context.Context) error {
+	l.publishing.Lock()
+	defer l.publishing.Unlock()
+
+	sth, err := l.sequence()
+	if err != nil {
+		return err
+	}
+	if l.config.Secondary != nil {
+		if sth, err = l.confirmedTreeHead(ctx, &sth); err != nil {
+			return err
+		}
+	}
+	cth := types.CosignedTreeHead{SignedTreeHead: sth}
+	if l.config.Collector != nil {
+		cth, err = l.config.Collector.Collect(ctx, treeProofs{l}, &sth)
+		if err != nil {
+			return fmt.Errorf("collecting cosignatures failed: %v", err)
+		}
+	}
+
+	l.m.Lock()
+	defer l.m.Unlock()
+	l.cth = cth
+	close(l.published)
+	l.published = make(chan struct{})
+	return nil
+}
+
+// Appends pending leaves to the tree, and returns the signed tree
+// head for the new tree.
+func (l *Log) sequence() (types.SignedTreeHead, error) {
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	if len(l.pending) > 0 {
+		if l.storage != nil {
+			if err := l.storage.appendLeaves(l.pending); err != nil {
+				return types.SignedTreeHead{}, fmt.Errorf("persisting leaves failed: %v", err)
+			}
+		}
+		for _, leaf := range l.pending {
+			h := leaf.ToHash()
+			if !l.tree.AddLeafHash(&h) {
+				panic(fmt.Sprintf("internal error, duplicate pending leaf %x", h))
+			}
+		}
+		l.leaves = append(l.leaves, l.pending...)
+		l.pending = nil
+		l.pendingHashes = make(map[crypto.Hash]struct{})
+	}
+	if l.sth.Size != l.tree.Size() {
+		if err := l.sign(); err != nil {
+			return types.SignedTreeHead{}, err
+		}
+	}
+	return l.sth, nil
+}
+
+// Returns a signed tree head for the part of the tree, up to sth,
+// that the secondary has replicated. Fails if that is smaller than
+// the published tree head.
+func (l *Log) confirmedTreeHead(ctx context.Context, sth *types.SignedTreeHead) (types.SignedTreeHead, error) {
+	th, err := l.config.Secondary.ConfirmedTreeHead(ctx, treeProofs{l}, &sth.TreeHead)
+	if err != nil {
+		return types.SignedTreeHead{}, fmt.Errorf("checking secondary failed: %v", err)
+	}
+	if th.Size == sth.Size {
+		return *sth, nil
+	}
+	l.m.RLock()
+	published := l.cth.SignedTreeHead
+	l.m.RUnlock()
+
+	if th.Size < published.Size {
+		return types.SignedTreeHead{}, fmt.Errorf("secondary tree size %d smaller than published tree size %d",
+			th.Size, published.Size)
+	}
+	log.Debug("Secondary has replicated %d of %d leaves", th.Size, sth.Size)
+	if th.Size == published.Size {
+		return published, nil
+	}
+	return th.Sign(l.config.Signer)
+}
+
+// Signs and stores a tree head for the current tree. Must be called
+// with lock held, or during initialization.
+func (l *Log) sign() error {
+	th := types.TreeHead{Size: l.tree.Size(), RootHash: l.tree.GetRootHash()}
+	sth, err := th.Sign(l.config.Signer)
+	if err != nil {
+		return err
+	}
+	if l.storage != nil {
+		if err := l.storage.storeTreeHead(&sth); err != nil {
+			return fmt.Errorf("storing tree head failed: %v", err)
+		}
+	}
+	l.sth = sth
+	return nil
+}
+
+// Provides consistency proofs to the collector, which needs proofs
+// also for a signed tree head that isn't yet published.
+type treeProofs struct {
+	l *Log
+}
+
+func (p treeProofs) GetConsistencyProof(_ context.Context, req requests.ConsistencyProof) (types.ConsistencyProof, error) {
+	p.l.m.RLock()
+	defer p.l.m.RUnlock()
+
+	path, err := p.l.tree.ProveConsistency(req.OldSize, req.NewSize)
+	if err != nil {
+		return types.ConsistencyProof{}, err
+	}
+	return types.ConsistencyProof{Path: path}, nil
+}
+
+// Run calls Publish immediately, and then at the configured
+// interval, until the context is cancelled.
+func (l *Log) Run(ctx context.Context) {
+	ticker := time.NewTicker(l.config.Interval)
+	defer ticker.Stop()
+	for {
+		if err := l.Publish(ctx); err != nil {
+			log.Error("Publishing tree head failed: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (l *Log) GetTreeHead(_ context.Context) (types.CosignedTreeHead, error) {
+	l.m.RLock()
+	defer l.m.RUnlock()
+	return l.cth, nil
+}
+
+func (l *Log) WaitTreeHead(ctx context.Context, req requests.TreeHead) (types.CosignedTreeHead, error) {
+	for {
+		l.m.RLock()
+		cth, published := l.cth, l.published
+		l.m.RUnlock()
+
+		if cth.Size > req.OldSize {
+			return cth, nil
+		}
+		select {
+		case <-ctx.Done():
+			return types.CosignedTreeHead{}, ctx.Err()
+		case <-published:
+		}
+	}
+}
+
+func (l *Log) GetInclusionProof(_ context.Context, req requests.InclusionProof) (types.InclusionProof, error) {
+	l.m.RLock()
+	defer l.m.RUnlock()
+
+	if req.Size > l.cth.Size {
+		return types.InclusionProof{}, api.ErrBadRequest.WithError(
+			fmt.Errorf("size %d exceeds current tree size %d", req.Size, l.cth.Size))
+	}
+	index, err := l.tree.GetLeafIndex(&req.LeafHash)
+	if err != nil || index >= req.Size {
+		return types.InclusionProof{}, api.ErrNotFound
+	}
+	path, err := l.tree.ProveInclusion(index, req.Size)
+	if err != nil {
+		return types.InclusionProof{}, err
+	}
+	return types.InclusionProof{LeafIndex: index, Path: path}, nil
+}
+
+func (l *Log) GetConsistencyProof(_ context.Context, req requests.ConsistencyProof) (types.ConsistencyProof, error) {
+	l.m.RLock()
+	defer l.m.RUnlock()
+
+	if req.NewSize > l.cth.Size || req.OldSize > req.NewSize {
+		return types.ConsistencyProof{}, api.ErrBadRequest.WithError(
+			fmt.Errorf("invalid sizes old %d, new %d, current tree size %d",
+				req.OldSize, req.NewSize, l.cth.Size))
+	}
+	path, err := l.tree.ProveConsistency(req.OldSize, req.NewSize)
+	if err != nil {
+		return types.ConsistencyProof{}, err
+	}
+	return types.ConsistencyProof{Path: path}, nil
+}
+
+// Implements api.RangeProver.
+func (l *Log) GetRangeProof(_ context.Context, req requests.RangeProof) (types.RangeProof, error) {
+	l.m.RLock()
+	defer l.m.RUnlock()
+
+	if req.Size > l.cth.Size || req.StartIndex >= req.EndIndex || req.EndIndex > req.Size {
+		return types.RangeProof{}, api.ErrBadRequest.WithError(
+			fmt.Errorf("invalid range start %d, end %d, size %d, current tree size %d",
+				req.StartIndex, req.EndIndex, req.Size, l.cth.Size))
+	}
+	path, err := l.tree.ProveRange(req.StartIndex, req.EndIndex, req.Size)
+	if err != nil {
+		return types.RangeProof{}, err
+	}
+	return types.RangeProof{Path: path}, nil
+}
+
+func (l *Log) GetLeaves(_ context.Context, req requests.Leaves) ([]types.Leaf, error) {
+	l.m.RLock()
+	defer l.m.RUnlock()
+
+	if req.StartIndex >= req.EndIndex || req.StartIndex >= l.cth.Size {
+		return nil, api.ErrBadRequest.WithError(
+			fmt.Errorf("invalid range start %d, end %d, current tree size %d",
+				req.StartIndex, req.EndIndex, l.cth.Size))
+	}
+	end := min(req.EndIndex, l.cth.Size, req.StartIndex+l.config.MaxLeaves)
+	leaves := make([]types.Leaf, end-req.StartIndex)
+	copy(leaves, l.leaves[req.StartIndex:end])
+	return leaves, nil
+}
+
+// Like GetLeaves, but for all sequenced leaves, including leaves not
+// yet covered by a published tree head. Intended for the primary's
+// internal endpoint used by a secondary node, see
+// server.NewGetLeavesServer. Fails with api.ErrNotFound if the start
+// index is at or beyond the end of the tree.
+func (l *Log) GetSequencedLeaves(_ context.Context, req requests.Leaves) ([]types.Leaf, error) {
+	l.m.RLock()
+	defer l.m.RUnlock()
+
+	size := uint64(len(l.leaves))
+	if req.StartIndex >= req.EndIndex {
+		return nil, api.ErrBadRequest.WithError(
+			fmt.Errorf("invalid range start %d, end %d", req.StartIndex, req.EndIndex))
+	}
+	if req.StartIndex >= size {
+		return nil, api.ErrNotFound
+	}
+	end := min(req.EndIndex, size, req.StartIndex+l.config.MaxLeaves)
+	leaves := make([]types.Leaf, end-req.StartIndex)
+	copy(leaves, l.leaves[req.StartIndex:end])
+	return leaves, nil
+}
+
+// Implements api.TileLog, for tiles within the published tree.
+func (l *Log) GetTile(_ context.Context, req requests.Tile) ([]crypto.Hash, error) {
+	l.m.RLock()
+	defer l.m.RUnlock()
+
+	if req.Width == 0 || req.Width > types.TileWidth {
+		return nil, api.ErrBadRequest.WithError(fmt.Errorf("invalid tile width %d", req.Width))
+	}
+	if req.Level >= 64/types.TileHeight {
+		return nil, api.ErrNotFound
+	}
+	level := uint(req.Level * types.TileHeight)
+	start := req.Index * types.TileWidth
+	if req.Index > (l.cth.Size>>level)/types.TileWidth ||
+		start+req.Width > l.cth.Size>>level {
+		return nil, api.ErrNotFound
+	}
+	hashes := make([]crypto.Hash, req.Width)
+	for i := range hashes {
+		var err error
+		hashes[i], err = l.tree.GetNodeHash(level, start+uint64(i))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return hashes, nil
+}
+
+// Returns true if the leaf is sequenced (and persisted, if the log
+// has a directory configured), false if it has only been added to
+// the set of pending leaves.
+func (l *Log) AddLeaf(_ context.Context, req requests.Leaf, _ *token.SubmitHeader) (bool, error) {
+	leaf, err := req.Verify()
+	if err != nil {
+		return false, api.ErrForbidden.WithError(err)
+	}
+	h := leaf.ToHash()
+
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	if _, err := l.tree.GetLeafIndex(&h); err == nil {
+		return true, nil
+	}
+	if _, ok := l.pendingHashes[h]; !ok {
+		l.pendingHashes[h] = struct{}{}
+		l.pending = append(l.pending, leaf)
+	}
+	return false, nil
+}
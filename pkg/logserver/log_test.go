@@ -0,0 +1,236 @@
+package logserver
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"sigsum.org/sigsum-go/pkg/api"
+	"sigsum.org/sigsum-go/pkg/crypto"
+	"sigsum.org/sigsum-go/pkg/requests"
+	"sigsum.org/sigsum-go/pkg/types"
+)
+
+func TestAddLeafAndProofs(t *testing.T) {
+	logSigner := crypto.NewEd25519Signer(&crypto.PrivateKey{1})
+	logKey := logSigner.Public()
+	submitSigner := crypto.NewEd25519Signer(&crypto.PrivateKey{2})
+
+	l, err := New(&Config{Signer: logSigner})
+	if err != nil {
+		t.Fatalf("creating log failed: %v", err)
+	}
+	defer l.Close()
+
+	ctx := context.Background()
+	cth, err := l.GetTreeHead(ctx)
+	if err != nil {
+		t.Fatalf("GetTreeHead failed: %v", err)
+	}
+	if cth.Size != 0 || !cth.Verify(&logKey) {
+		t.Fatalf("unexpected initial tree head: size %d", cth.Size)
+	}
+
+	var leafHashes []crypto.Hash
+	for i := 0; i < 10; i++ {
+		req := makeLeafRequest(t, submitSigner, uint64(i))
+		leaf, err := req.Verify()
+		if err != nil {
+			t.Fatal(err)
+		}
+		leafHashes = append(leafHashes, leaf.ToHash())
+		if persisted, err := l.AddLeaf(ctx, req, nil); err != nil || persisted {
+			t.Fatalf("unexpected AddLeaf result: %v, %v", persisted, err)
+		}
+		// Adding the same leaf again is harmless.
+		if persisted, err := l.AddLeaf(ctx, req, nil); err != nil || persisted {
+			t.Fatalf("unexpected AddLeaf result for duplicate: %v, %v", persisted, err)
+		}
+		if i%3 == 2 {
+			if err := l.Publish(ctx); err != nil {
+				t.Fatalf("Publish failed: %v", err)
+			}
+			if persisted, err := l.AddLeaf(ctx, req, nil); err != nil || !persisted {
+				t.Fatalf("unexpected AddLeaf result after publish: %v, %v", persisted, err)
+			}
+		}
+	}
+	prev := cth
+	cth, err = l.GetTreeHead(ctx)
+	if err != nil {
+		t.Fatalf("GetTreeHead failed: %v", err)
+	}
+	if got, want := cth.Size, uint64(9); got != want {
+		t.Fatalf("unexpected tree size, got %d, want %d", got, want)
+	}
+	if !cth.Verify(&logKey) {
+		t.Fatalf("invalid tree head signature")
+	}
+	consistency, err := l.GetConsistencyProof(ctx, requests.ConsistencyProof{OldSize: prev.Size, NewSize: cth.Size})
+	if err != nil {
+		t.Fatalf("GetConsistencyProof failed: %v", err)
+	}
+	if err := consistency.Verify(&prev.TreeHead, &cth.TreeHead); err != nil {
+		t.Errorf("invalid consistency proof: %v", err)
+	}
+	for i, h := range leafHashes[:cth.Size] {
+		proof, err := l.GetInclusionProof(ctx, requests.InclusionProof{Size: cth.Size, LeafHash: h})
+		if err != nil {
+			t.Fatalf("GetInclusionProof failed for leaf %d: %v", i, err)
+		}
+		if err := proof.Verify(&h, &cth.TreeHead); err != nil {
+			t.Errorf("invalid inclusion proof for leaf %d: %v", i, err)
+		}
+	}
+	// The last leaf is still pending.
+	if _, err := l.GetInclusionProof(ctx, requests.InclusionProof{Size: cth.Size, LeafHash: leafHashes[9]}); !errors.Is(err, api.ErrNotFound) {
+		t.Errorf("unexpected result for pending leaf: %v", err)
+	}
+	if _, err := l.GetInclusionProof(ctx, requests.InclusionProof{Size: cth.Size + 1, LeafHash: leafHashes[0]}); !errors.Is(err, api.ErrBadRequest) {
+		t.Errorf("unexpected result for too large size: %v", err)
+	}
+
+	leaves, err := l.GetLeaves(ctx, requests.Leaves{StartIndex: 2, EndIndex: 100})
+	if err != nil {
+		t.Fatalf("GetLeaves failed: %v", err)
+	}
+	if got, want := len(leaves), 7; got != want {
+		t.Fatalf("unexpected number of leaves, got %d, want %d", got, want)
+	}
+	for i, leaf := range leaves {
+		if leaf.ToHash() != leafHashes[i+2] {
+			t.Errorf("unexpected leaf at index %d", i+2)
+		}
+	}
+	if _, err := l.GetLeaves(ctx, requests.Leaves{StartIndex: 9, EndIndex: 10}); !errors.Is(err, api.ErrBadRequest) {
+		t.Errorf("unexpected result for out of range leaves: %v", err)
+	}
+}
+
+func TestWaitTreeHead(t *testing.T) {
+	l, err := New(&Config{Signer: crypto.NewEd25519Signer(&crypto.PrivateKey{1})})
+	if err != nil {
+		t.Fatalf("creating log failed: %v", err)
+	}
+	defer l.Close()
+
+	ctx := context.Background()
+	shortCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if _, err := l.WaitTreeHead(shortCtx, requests.TreeHead{OldSize: 0}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("unexpected WaitTreeHead result for empty log: %v", err)
+	}
+
+	done := make(chan types.CosignedTreeHead)
+	go func() {
+		cth, err := l.WaitTreeHead(ctx, requests.TreeHead{OldSize: 0})
+		if err != nil {
+			t.Errorf("WaitTreeHead failed: %v", err)
+		}
+		done <- cth
+	}()
+	// Publishing without new leaves doesn't wake up the waiter.
+	if err := l.Publish(ctx); err != nil {
+		t.Fatalf("Publish failed: %v", err)
+	}
+	if _, err := l.AddLeaf(ctx, makeLeafRequest(t, crypto.NewEd25519Signer(&crypto.PrivateKey{2}), 0), nil); err != nil {
+		t.Fatalf("AddLeaf failed: %v", err)
+	}
+	if err := l.Publish(ctx); err != nil {
+		t.Fatalf("Publish failed: %v", err)
+	}
+	if cth := <-done; cth.Size != 1 {
+		t.Errorf("unexpected tree size %d, want 1", cth.Size)
+	}
+	// No waiting when there's already a larger tree head.
+	if cth, err := l.WaitTreeHead(shortCtx, requests.TreeHead{OldSize: 0}); err != nil || cth.Size != 1 {
+		t.Errorf("unexpected WaitTreeHead result: size %d, err %v", cth.Size, err)
+	}
+}
+
+func TestPersistence(t *testing.T) {
+	logSigner := crypto.NewEd25519Signer(&crypto.PrivateKey{1})
+	submitSigner := crypto.NewEd25519Signer(&crypto.PrivateKey{2})
+	dir := t.TempDir()
+	ctx := context.Background()
+
+	addLeaves := func(l *Log, start, end uint64) {
+		for i := start; i < end; i++ {
+			if _, err := l.AddLeaf(ctx, makeLeafRequest(t, submitSigner, i), nil); err != nil {
+				t.Fatalf("AddLeaf failed: %v", err)
+			}
+		}
+	}
+	l, err := New(&Config{Signer: logSigner, Directory: dir})
+	if err != nil {
+		t.Fatalf("creating log failed: %v", err)
+	}
+	addLeaves(l, 0, 5)
+	if err := l.Publish(ctx); err != nil {
+		t.Fatalf("Publish failed: %v", err)
+	}
+	// Pending leaves are lost on restart.
+	addLeaves(l, 5, 7)
+	cth, err := l.GetTreeHead(ctx)
+	if err != nil {
+		t.Fatalf("GetTreeHead failed: %v", err)
+	}
+	l.Close()
+
+	// Simulate crash in the middle of writing a leaf.
+	f, err := os.OpenFile(filepath.Join(dir, leavesFileName), os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write(make([]byte, 17)); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	l, err = New(&Config{Signer: logSigner, Directory: dir})
+	if err != nil {
+		t.Fatalf("reopening log failed: %v", err)
+	}
+	if got, err := l.GetTreeHead(ctx); err != nil || got.SignedTreeHead != cth.SignedTreeHead {
+		t.Fatalf("unexpected tree head after restart: %v, err %v", got, err)
+	}
+	addLeaves(l, 5, 8)
+	if err := l.Publish(ctx); err != nil {
+		t.Fatalf("Publish failed: %v", err)
+	}
+	newCth, err := l.GetTreeHead(ctx)
+	if err != nil {
+		t.Fatalf("GetTreeHead failed: %v", err)
+	}
+	if got, want := newCth.Size, uint64(8); got != want {
+		t.Fatalf("unexpected tree size, got %d, want %d", got, want)
+	}
+	proof, err := l.GetConsistencyProof(ctx, requests.ConsistencyProof{OldSize: cth.Size, NewSize: newCth.Size})
+	if err != nil {
+		t.Fatalf("GetConsistencyProof failed: %v", err)
+	}
+	if err := proof.Verify(&cth.TreeHead, &newCth.TreeHead); err != nil {
+		t.Errorf("tree after restart not consistent: %v", err)
+	}
+	l.Close()
+
+	// Wrong key must be rejected.
+	if _, err := New(&Config{Signer: submitSigner, Directory: dir}); err == nil {
+		t.Errorf("opening log with wrong key succeeded, should fail")
+	}
+}
+
+func makeLeafRequest(t *testing.T, signer crypto.Signer, i uint64) requests.Leaf {
+	t.Helper()
+	var msg crypto.Hash
+	binary.BigEndian.PutUint64(msg[:], i)
+	signature, err := types.SignLeafMessage(signer, msg[:])
+	if err != nil {
+		t.Fatalf("signing leaf failed: %v", err)
+	}
+	return requests.Leaf{Message: msg, Signature: signature, PublicKey: signer.Public()}
+}
@@ -0,0 +1,142 @@
+package logserver
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/dchest/safefile"
+
+	"sigsum.org/sigsum-go/pkg/types"
+)
+
+const (
+	leavesFileName   = "leaves"
+	treeHeadFileName = "tree-head"
+
+	// Size of the binary representation of a leaf, see
+	// types.Leaf.ToBinary.
+	leafSize = 128
+)
+
+// Persistent state of a log, stored as files in a directory. The
+// leaves file is append-only, with fixed size binary records; a
+// partial record at the end (e.g., due to a crash in the middle of
+// a write) is discarded when the file is opened. The tree head file
+// holds the latest published signed tree head, in ASCII format, and
+// it is replaced atomically.
+type storage struct {
+	dir        string
+	leavesFile *os.File
+	// Size of leaves file, in bytes.
+	leavesSize int64
+}
+
+// Opens storage in the given directory, creating files as
+// needed. Returns all stored leaves, and the stored tree head, if
+// any.
+func openStorage(dir string) (*storage, []types.Leaf, *types.SignedTreeHead, error) {
+	f, err := os.OpenFile(filepath.Join(dir, leavesFileName), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	leaves, err := readLeaves(f)
+	if err != nil {
+		f.Close()
+		return nil, nil, nil, err
+	}
+	sth, err := readTreeHead(filepath.Join(dir, treeHeadFileName))
+	if err != nil {
+		f.Close()
+		return nil, nil, nil, err
+	}
+	return &storage{dir: dir, leavesFile: f, leavesSize: int64(len(leaves)) * leafSize}, leaves, sth, nil
+}
+
+// Reads all complete leaf records, truncates any trailing partial
+// record, and leaves the file offset at the end of the file.
+func readLeaves(f *os.File) ([]types.Leaf, error) {
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return nil, fmt.Errorf("reading leaves failed: %v", err)
+	}
+	count := len(data) / leafSize
+	if len(data) > count*leafSize {
+		if err := f.Truncate(int64(count * leafSize)); err != nil {
+			return nil, fmt.Errorf("truncating partial leaf failed: %v", err)
+		}
+	}
+	if _, err := f.Seek(int64(count*leafSize), io.SeekStart); err != nil {
+		return nil, err
+	}
+	leaves := make([]types.Leaf, count)
+	for i := range leaves {
+		if err := leaves[i].FromBinary(data[i*leafSize : (i+1)*leafSize]); err != nil {
+			return nil, err
+		}
+	}
+	return leaves, nil
+}
+
+// Returns nil, nil if file doesn't exist.
+func readTreeHead(name string) (*types.SignedTreeHead, error) {
+	f, err := os.Open(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var sth types.SignedTreeHead
+	if err := sth.FromASCII(f); err != nil {
+		return nil, fmt.Errorf("invalid tree head file: %v", err)
+	}
+	return &sth, nil
+}
+
+// Appends leaves, and syncs to disk before returning.
+func (s *storage) appendLeaves(leaves []types.Leaf) error {
+	if len(leaves) == 0 {
+		return nil
+	}
+	data := make([]byte, 0, len(leaves)*leafSize)
+	for _, leaf := range leaves {
+		data = append(data, leaf.ToBinary()...)
+	}
+	_, err := s.leavesFile.Write(data)
+	if err == nil {
+		err = s.leavesFile.Sync()
+	}
+	if err != nil {
+		// Try to discard any partially written data, so that
+		// a retry doesn't produce misaligned or duplicate
+		// records.
+		if err := s.leavesFile.Truncate(s.leavesSize); err == nil {
+			s.leavesFile.Seek(s.leavesSize, io.SeekStart)
+		}
+		return err
+	}
+	s.leavesSize += int64(len(data))
+	return nil
+}
+
+func (s *storage) storeTreeHead(sth *types.SignedTreeHead) error {
+	f, err := safefile.Create(filepath.Join(s.dir, treeHeadFileName), 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := sth.ToASCII(f); err != nil {
+		return err
+	}
+	// Atomically replace old file with new.
+	return f.Commit()
+}
+
+func (s *storage) close() error {
+	return s.leavesFile.Close()
+}
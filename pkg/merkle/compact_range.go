@@ -0,0 +1,212 @@
+package merkle
+
+import (
+	"fmt"
+	"math/bits"
+	"slices"
+
+	"sigsum.org/sigsum-go/pkg/crypto"
+)
+
+// CompactRange represents the leaves [Begin, End), using the hashes
+// of the minimal set of complete subtrees that cover the range,
+// ordered from left to right. See
+// https://github.com/transparency-dev/merkle/blob/main/docs/compact_ranges.md.
+// Adjacent ranges can be merged, so that leaves fetched out of order
+// can be stitched together. A range starting at index zero
+// represents a tree, with a root hash that can be compared to a tree
+// head.
+type CompactRange struct {
+	begin, end uint64
+	hashes     []crypto.Hash
+}
+
+// Identifies a complete subtree, covering leaves [index << level,
+// (index + 1) << level).
+type node struct {
+	level uint
+	index uint64
+}
+
+// Returns the levels of the subtrees of the compact range [begin,
+// end), left to right.
+func rangeLevels(begin, end uint64) []uint {
+	var levels []uint
+	for begin < end {
+		level := uint(bits.Len64(end-begin) - 1)
+		if begin > 0 {
+			level = min(level, uint(bits.TrailingZeros64(begin)))
+		}
+		levels = append(levels, level)
+		begin += uint64(1) << level
+	}
+	return levels
+}
+
+// Returns an empty range starting at begin.
+func NewCompactRange(begin uint64) *CompactRange {
+	return &CompactRange{begin: begin, end: begin}
+}
+
+// Creates a range from the hashes of its subtrees, left to right,
+// e.g., as returned by Hashes.
+func NewCompactRangeFromHashes(begin, end uint64, hashes []crypto.Hash) (*CompactRange, error) {
+	if begin > end {
+		return nil, fmt.Errorf("invalid range [%d, %d)", begin, end)
+	}
+	if got, want := len(hashes), len(rangeLevels(begin, end)); got != want {
+		return nil, fmt.Errorf("invalid number of hashes for range [%d, %d), got %d, want %d",
+			begin, end, got, want)
+	}
+	return &CompactRange{begin: begin, end: end, hashes: slices.Clone(hashes)}, nil
+}
+
+func (cr *CompactRange) Begin() uint64 {
+	return cr.begin
+}
+
+func (cr *CompactRange) End() uint64 {
+	return cr.end
+}
+
+// Returns the hashes of the range's subtrees, left to right.
+func (cr *CompactRange) Hashes() []crypto.Hash {
+	return slices.Clone(cr.hashes)
+}
+
+// Appends a leaf hash at index End.
+func (cr *CompactRange) Append(leafHash *crypto.Hash) {
+	cr.appendNode(0, *leafHash)
+}
+
+// Appends the hash of a subtree at the given level, starting at End,
+// which must be a multiple of 2^level.
+func (cr *CompactRange) appendNode(level uint, h crypto.Hash) {
+	end := cr.end + uint64(1)<<level
+	// Merge with left siblings, as long as they're included in
+	// the range.
+	for start := cr.end; (start>>level)&1 == 1 && start-uint64(1)<<level >= cr.begin; level++ {
+		h = HashInteriorNode(&cr.hashes[len(cr.hashes)-1], &h)
+		cr.hashes = cr.hashes[:len(cr.hashes)-1]
+		start -= uint64(1) << level
+	}
+	cr.hashes = append(cr.hashes, h)
+	cr.end = end
+}
+
+// Merge extends the range with the adjacent range other, which must
+// start at End.
+func (cr *CompactRange) Merge(other *CompactRange) error {
+	if other.begin != cr.end {
+		return fmt.Errorf("ranges [%d, %d) and [%d, %d) are not adjacent",
+			cr.begin, cr.end, other.begin, other.end)
+	}
+	for i, level := range rangeLevels(other.begin, other.end) {
+		cr.appendNode(level, other.hashes[i])
+	}
+	return nil
+}
+
+// Returns the root hash of the tree with End leaves. Fails unless
+// the range starts at index zero.
+func (cr *CompactRange) RootHash() (crypto.Hash, error) {
+	if cr.begin != 0 {
+		return crypto.Hash{}, fmt.Errorf("range [%d, %d) doesn't start at zero", cr.begin, cr.end)
+	}
+	return compactRange(cr.hashes).getRootHash(), nil
+}
+
+// Adds the range's subtrees to the map.
+func (cr *CompactRange) addNodes(nodes map[node]crypto.Hash) {
+	start := cr.begin
+	for i, level := range rangeLevels(cr.begin, cr.end) {
+		nodes[node{level: level, index: start >> level}] = cr.hashes[i]
+		start += uint64(1) << level
+	}
+}
+
+// Returns a GetNodeHashFunc for the subtrees of the given ranges.
+func rangesGetNodeHash(ranges ...*CompactRange) GetNodeHashFunc {
+	nodes := make(map[node]crypto.Hash)
+	for _, cr := range ranges {
+		cr.addNodes(nodes)
+	}
+	return func(level uint, index uint64) (crypto.Hash, error) {
+		if h, ok := nodes[node{level: level, index: index}]; ok {
+			return h, nil
+		}
+		return crypto.Hash{}, fmt.Errorf("node at level %d, index %d not available", level, index)
+	}
+}
+
+// ProveInclusionFromRanges produces an inclusion proof for the leaf
+// at index left.End(), using the ranges left, [0, index), and right,
+// [index + 1, size), on either side of the leaf.
+func ProveInclusionFromRanges(left, right *CompactRange) ([]crypto.Hash, error) {
+	if left.begin != 0 || right.begin != left.end+1 {
+		return nil, fmt.Errorf("invalid ranges [%d, %d) and [%d, %d) for inclusion proof",
+			left.begin, left.end, right.begin, right.end)
+	}
+	return ProveInclusionFromNodes(rangesGetNodeHash(left, right), left.end, right.end)
+}
+
+// ProveConsistencyFromRanges produces a consistency proof between
+// trees of size m and n, using the ranges left, [0, m), and right,
+// [m, n).
+func ProveConsistencyFromRanges(left, right *CompactRange) ([]crypto.Hash, error) {
+	if left.begin != 0 || right.begin != left.end {
+		return nil, fmt.Errorf("invalid ranges [%d, %d) and [%d, %d) for consistency proof",
+			left.begin, left.end, right.begin, right.end)
+	}
+	return ProveConsistencyFromNodes(rangesGetNodeHash(left, right), left.end, right.end)
+}
+
+// Returns the range [0, end), given the hashes of its subtrees in
+// right to left order, as they appear on proof paths.
+func rangeFromPath(end uint64, path []crypto.Hash, ranges [][2]uint64) *CompactRange {
+	cr := CompactRange{end: end}
+	for i := len(ranges) - 1; i >= 0; i-- {
+		if ranges[i][1] <= end {
+			cr.hashes = append(cr.hashes, path[i])
+		}
+	}
+	return &cr
+}
+
+// NewCompactRangeFromInclusion verifies an inclusion proof, and
+// returns the range [0, index + 1), i.e., the leaves up to and
+// including the proven leaf. Arguments are as for VerifyInclusion.
+func NewCompactRangeFromInclusion(leaf *crypto.Hash, index, size uint64, root *crypto.Hash, path []crypto.Hash) (*CompactRange, error) {
+	if err := VerifyInclusion(leaf, index, size, root, path); err != nil {
+		return nil, err
+	}
+	// The subtrees to the left of the leaf form the range [0, index).
+	cr := rangeFromPath(index, path, pathRanges(func(subtree subtreeFunc) ([]crypto.Hash, error) {
+		return inclusionPath(subtree, index, 0, size)
+	}))
+	cr.Append(leaf)
+	return cr, nil
+}
+
+// NewCompactRangeFromConsistency verifies a consistency proof, and
+// returns the range [0, oldSize). Arguments are as for
+// VerifyConsistency.
+func NewCompactRangeFromConsistency(oldSize, newSize uint64, oldRoot, newRoot *crypto.Hash, path []crypto.Hash) (*CompactRange, error) {
+	if err := VerifyConsistency(oldSize, newSize, oldRoot, newRoot, path); err != nil {
+		return nil, err
+	}
+	if oldSize == 0 {
+		return NewCompactRange(0), nil
+	}
+	if oldSize == newSize {
+		return nil, fmt.Errorf("proof for equal sizes doesn't determine a compact range")
+	}
+	if oldSize&(oldSize-1) == 0 {
+		// A complete tree; the old root is not included in
+		// the proof.
+		return &CompactRange{end: oldSize, hashes: []crypto.Hash{*oldRoot}}, nil
+	}
+	return rangeFromPath(oldSize, path, pathRanges(func(subtree subtreeFunc) ([]crypto.Hash, error) {
+		return consistencyPath(subtree, oldSize, 0, newSize, true)
+	})), nil
+}
@@ -0,0 +1,167 @@
+package merkle
+
+import (
+	"slices"
+	"testing"
+
+	"sigsum.org/sigsum-go/pkg/crypto"
+)
+
+func newRange(leaves []crypto.Hash, begin, end uint64) *CompactRange {
+	cr := NewCompactRange(begin)
+	for i := begin; i < end; i++ {
+		cr.Append(&leaves[i])
+	}
+	return cr
+}
+
+func TestCompactRangeAppend(t *testing.T) {
+	leaves := newLeaves(40)
+	tree := newMemoryTree(leaves)
+	for begin := uint64(0); begin <= 40; begin++ {
+		for end := begin; end <= 40; end++ {
+			cr := newRange(leaves, begin, end)
+			if cr.Begin() != begin || cr.End() != end {
+				t.Fatalf("unexpected range [%d, %d), want [%d, %d)", cr.Begin(), cr.End(), begin, end)
+			}
+			nodes := make(map[node]crypto.Hash)
+			cr.addNodes(nodes)
+			if got, want := len(nodes), len(rangeLevels(begin, end)); got != want {
+				t.Fatalf("unexpected number of nodes for [%d, %d), got %d, want %d", begin, end, got, want)
+			}
+			for n, h := range nodes {
+				if want, err := tree.GetNodeHash(n.level, n.index); err != nil || h != want {
+					t.Errorf("unexpected hash for [%d, %d), level %d, index %d", begin, end, n.level, n.index)
+				}
+			}
+			root, err := cr.RootHash()
+			if begin > 0 {
+				if err == nil {
+					t.Errorf("RootHash for range [%d, %d) succeeded", begin, end)
+				}
+			} else if want := rootOf(leaves[:end]); err != nil || root != want {
+				t.Errorf("unexpected root hash for size %d, err %v", end, err)
+			}
+		}
+	}
+}
+
+func TestCompactRangeMerge(t *testing.T) {
+	leaves := newLeaves(40)
+	for begin := uint64(0); begin <= 40; begin++ {
+		for mid := begin; mid <= 40; mid++ {
+			for end := mid; end <= 40; end++ {
+				cr := newRange(leaves, begin, mid)
+				if err := cr.Merge(newRange(leaves, mid, end)); err != nil {
+					t.Fatalf("Merge [%d, %d) and [%d, %d) failed: %v", begin, mid, mid, end, err)
+				}
+				want := newRange(leaves, begin, end)
+				if cr.Begin() != begin || cr.End() != end || !slices.Equal(cr.Hashes(), want.Hashes()) {
+					t.Errorf("unexpected result of merging [%d, %d) and [%d, %d)", begin, mid, mid, end)
+				}
+			}
+		}
+	}
+	if err := newRange(leaves, 0, 3).Merge(newRange(leaves, 4, 5)); err == nil {
+		t.Errorf("merging non-adjacent ranges succeeded")
+	}
+}
+
+func TestCompactRangeFromHashes(t *testing.T) {
+	leaves := newLeaves(20)
+	cr := newRange(leaves, 3, 17)
+	if got, err := NewCompactRangeFromHashes(3, 17, cr.Hashes()); err != nil || !slices.Equal(got.Hashes(), cr.Hashes()) {
+		t.Errorf("NewCompactRangeFromHashes failed: %v", err)
+	}
+	if _, err := NewCompactRangeFromHashes(3, 16, cr.Hashes()); err == nil {
+		t.Errorf("NewCompactRangeFromHashes with wrong number of hashes succeeded")
+	}
+}
+
+// Leaves fetched out of order are stitched together, and checked
+// against a tree head.
+func TestCompactRangeStitch(t *testing.T) {
+	leaves := newLeaves(100)
+	tree := newMemoryTree(leaves)
+	chunks := []*CompactRange{
+		newRange(leaves, 60, 100), newRange(leaves, 23, 41), newRange(leaves, 41, 60),
+	}
+	cr := chunks[1]
+	for _, chunk := range []*CompactRange{chunks[2], chunks[0]} {
+		if err := cr.Merge(chunk); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Leaves preceding the first chunk are covered by an
+	// inclusion proof for leaf 22.
+	path, err := tree.ProveInclusion(22, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := tree.GetRootHash()
+	left, err := NewCompactRangeFromInclusion(&leaves[22], 22, 100, &root, path)
+	if err != nil {
+		t.Fatalf("NewCompactRangeFromInclusion failed: %v", err)
+	}
+	if err := left.Merge(cr); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := left.RootHash(); err != nil || got != root {
+		t.Errorf("stitched range doesn't match tree head, err %v", err)
+	}
+}
+
+func TestCompactRangeProofs(t *testing.T) {
+	leaves := newLeaves(70)
+	tree := newMemoryTree(leaves)
+	for n := uint64(1); n <= 70; n++ {
+		root := rootOf(leaves[:n])
+		for i := uint64(0); i < n; i++ {
+			want, err := tree.ProveInclusion(i, n)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := ProveInclusionFromRanges(newRange(leaves, 0, i), newRange(leaves, i+1, n))
+			if err != nil {
+				t.Fatalf("ProveInclusionFromRanges %d, %d failed: %v", i, n, err)
+			}
+			if !slices.Equal(got, want) {
+				t.Errorf("unexpected inclusion path i %d, n %d\n  got: %x\n want: %x\n", i, n, got, want)
+			}
+			cr, err := NewCompactRangeFromInclusion(&leaves[i], i, n, &root, want)
+			if err != nil {
+				t.Fatalf("NewCompactRangeFromInclusion %d, %d failed: %v", i, n, err)
+			}
+			if cr.Begin() != 0 || cr.End() != i+1 || !slices.Equal(cr.Hashes(), newRange(leaves, 0, i+1).Hashes()) {
+				t.Errorf("unexpected range from inclusion proof i %d, n %d", i, n)
+			}
+			if _, err := NewCompactRangeFromInclusion(&leaves[i], i, n, &leaves[0], want); err == nil && n > 1 {
+				t.Errorf("NewCompactRangeFromInclusion with invalid root succeeded")
+			}
+		}
+		for m := uint64(0); m < n; m++ {
+			want, err := tree.ProveConsistency(m, n)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := ProveConsistencyFromRanges(newRange(leaves, 0, m), newRange(leaves, m, n))
+			if err != nil {
+				t.Fatalf("ProveConsistencyFromRanges %d, %d failed: %v", m, n, err)
+			}
+			if !slices.Equal(got, want) {
+				t.Errorf("unexpected consistency path m %d, n %d\n  got: %x\n want: %x\n", m, n, got, want)
+			}
+			if m == 0 {
+				continue
+			}
+			oldRoot := rootOf(leaves[:m])
+			cr, err := NewCompactRangeFromConsistency(m, n, &oldRoot, &root, want)
+			if err != nil {
+				t.Fatalf("NewCompactRangeFromConsistency %d, %d failed: %v", m, n, err)
+			}
+			if cr.Begin() != 0 || cr.End() != m || !slices.Equal(cr.Hashes(), newRange(leaves, 0, m).Hashes()) {
+				t.Errorf("unexpected range from consistency proof m %d, n %d", m, n)
+			}
+		}
+	}
+}
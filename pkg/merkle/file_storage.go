@@ -0,0 +1,399 @@
+package merkle
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+	"os"
+	"path/filepath"
+
+	"github.com/dchest/safefile"
+
+	"sigsum.org/sigsum-go/pkg/crypto"
+)
+
+const (
+	leafIndexFileName = "leaf-index"
+
+	// Size of the leaf index header, holding the number of
+	// indexed leaves.
+	indexHeaderSize = 8
+	// Each slot holds a leaf index plus one, or zero for an
+	// empty slot.
+	indexSlotSize = 8
+	// Number of slots in a new leaf index.
+	minIndexSlots = 1024
+
+	// Number of leaf hashes read at a time when rebuilding the
+	// leaf index.
+	rebuildBatchSize = 1024
+)
+
+func levelFileName(level uint) string {
+	return fmt.Sprintf("level-%02d", level)
+}
+
+// FileStorage is a Storage keeping the tree in files in a
+// directory. For each level of the tree, there's an append-only file
+// of the hashes of complete nodes at that level, where level zero
+// holds the leaf hashes. Hence any node hash can be read directly,
+// and proofs don't need to rehash any leaves. Lookup of leaf hashes
+// uses an on-disk hash table, with open addressing, that is rebuilt
+// with twice the size when it gets half full.
+//
+// Appends are written to the files immediately, but not synced to
+// disk until Sync is called. When the storage is reopened, e.g.,
+// after a crash, partially written records are discarded, missing
+// interior nodes are recomputed from the level below, and missing
+// leaf index entries are added.
+type FileStorage struct {
+	dir  string
+	size uint64
+	// Files of node hashes, indexed by level, opened lazily.
+	levels [64]*os.File
+	index  *os.File
+	// Number of slots in the leaf index, a power of two.
+	slots uint64
+}
+
+// Opens storage in the given directory, creating the directory and
+// files as needed. The size is the number of leaves expected, e.g.,
+// the size of the latest signed tree head. Any leaves beyond that
+// size are discarded; it is an error if fewer leaves are stored.
+func OpenFileStorage(dir string, size uint64) (*FileStorage, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	s := &FileStorage{dir: dir, size: size}
+	if err := s.open(); err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *FileStorage) open() error {
+	f, err := s.levelFile(0)
+	if err != nil {
+		return err
+	}
+	fileSize, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	stored := uint64(fileSize) / crypto.HashSize
+	if stored < s.size {
+		return fmt.Errorf("merkle storage %q holds only %d leaves, expected %d", s.dir, stored, s.size)
+	}
+	if err := f.Truncate(int64(s.size * crypto.HashSize)); err != nil {
+		return fmt.Errorf("truncating leaves failed: %v", err)
+	}
+	for level := uint(1); level < 64; level++ {
+		if err := s.recoverLevel(level); err != nil {
+			return err
+		}
+	}
+	return s.openIndex(stored > s.size)
+}
+
+// Truncates any nodes beyond the current size, and recomputes any
+// missing nodes from the level below.
+func (s *FileStorage) recoverLevel(level uint) error {
+	want := s.size >> level
+	if want == 0 {
+		err := os.Truncate(filepath.Join(s.dir, levelFileName(level)), 0)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	f, err := s.levelFile(level)
+	if err != nil {
+		return err
+	}
+	fileSize, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	have := min(uint64(fileSize)/crypto.HashSize, want)
+	if err := f.Truncate(int64(have * crypto.HashSize)); err != nil {
+		return fmt.Errorf("truncating level %d failed: %v", level, err)
+	}
+	for i := have; i < want; i++ {
+		left, err := s.GetNodeHash(level-1, 2*i)
+		if err != nil {
+			return err
+		}
+		right, err := s.GetNodeHash(level-1, 2*i+1)
+		if err != nil {
+			return err
+		}
+		h := HashInteriorNode(&left, &right)
+		if _, err := f.WriteAt(h[:], int64(i*crypto.HashSize)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Opens the leaf index, and adds any missing leaves. If the index is
+// invalid, too small, or may refer to discarded leaves, it is
+// rebuilt.
+func (s *FileStorage) openIndex(discarded bool) error {
+	f, err := os.OpenFile(filepath.Join(s.dir, leafIndexFileName), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	s.index = f
+	fileSize, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	slots := uint64(max(fileSize-indexHeaderSize, 0)) / indexSlotSize
+	count, err := s.readIndexCount()
+	if discarded || err != nil || count > s.size ||
+		fileSize != indexHeaderSize+int64(slots*indexSlotSize) ||
+		bits.OnesCount64(slots) != 1 || slots < minIndexSlots {
+		return s.rebuildIndex(s.size)
+	}
+	s.slots = slots
+	if slots < 2*s.size {
+		return s.rebuildIndex(s.size)
+	}
+	for i := count; i < s.size; i++ {
+		h, err := s.GetNodeHash(0, i)
+		if err != nil {
+			return err
+		}
+		if err := insertIndex(s.index, s.slots, i, &h); err != nil {
+			return err
+		}
+	}
+	return s.writeIndexCount(s.size)
+}
+
+// Rebuilds the leaf index for the first n leaves, with room for at
+// least twice as many, and atomically replaces the old index.
+func (s *FileStorage) rebuildIndex(n uint64) error {
+	slots := uint64(minIndexSlots)
+	for slots < 2*n {
+		slots *= 2
+	}
+	name := filepath.Join(s.dir, leafIndexFileName)
+	f, err := safefile.Create(name, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := f.Truncate(int64(indexHeaderSize + slots*indexSlotSize)); err != nil {
+		return err
+	}
+	leaves, err := s.levelFile(0)
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, rebuildBatchSize*crypto.HashSize)
+	for start := uint64(0); start < n; start += rebuildBatchSize {
+		batch := buf[:min(n-start, rebuildBatchSize)*crypto.HashSize]
+		if _, err := leaves.ReadAt(batch, int64(start*crypto.HashSize)); err != nil {
+			return fmt.Errorf("reading leaves failed: %v", err)
+		}
+		for i := uint64(0); i < uint64(len(batch))/crypto.HashSize; i++ {
+			h := crypto.Hash(batch[i*crypto.HashSize : (i+1)*crypto.HashSize])
+			if err := insertIndex(f.File, slots, start+i, &h); err != nil {
+				return err
+			}
+		}
+	}
+	var header [indexHeaderSize]byte
+	binary.BigEndian.PutUint64(header[:], n)
+	if _, err := f.WriteAt(header[:], 0); err != nil {
+		return err
+	}
+	if err := f.Commit(); err != nil {
+		return err
+	}
+	index, err := os.OpenFile(name, os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	if s.index != nil {
+		s.index.Close()
+	}
+	s.index = index
+	s.slots = slots
+	return nil
+}
+
+func (s *FileStorage) readIndexCount() (uint64, error) {
+	var header [indexHeaderSize]byte
+	if _, err := s.index.ReadAt(header[:], 0); err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint64(header[:]), nil
+}
+
+func (s *FileStorage) writeIndexCount(count uint64) error {
+	var header [indexHeaderSize]byte
+	binary.BigEndian.PutUint64(header[:], count)
+	_, err := s.index.WriteAt(header[:], 0)
+	return err
+}
+
+func homeSlot(leafHash *crypto.Hash, slots uint64) uint64 {
+	return binary.BigEndian.Uint64(leafHash[:8]) & (slots - 1)
+}
+
+func readSlot(f *os.File, slot uint64) (uint64, error) {
+	var buf [indexSlotSize]byte
+	if _, err := f.ReadAt(buf[:], int64(indexHeaderSize+slot*indexSlotSize)); err != nil {
+		return 0, fmt.Errorf("reading leaf index failed: %v", err)
+	}
+	return binary.BigEndian.Uint64(buf[:]), nil
+}
+
+// Adds the leaf at the given index, unless already present. Slots
+// referring to larger indices are stale (left behind by a crash), and
+// may be reused.
+func insertIndex(f *os.File, slots, index uint64, leafHash *crypto.Hash) error {
+	for slot := homeSlot(leafHash, slots); ; slot = (slot + 1) & (slots - 1) {
+		v, err := readSlot(f, slot)
+		if err != nil {
+			return err
+		}
+		if v == index+1 {
+			return nil
+		}
+		if v == 0 || v > index+1 {
+			var buf [indexSlotSize]byte
+			binary.BigEndian.PutUint64(buf[:], index+1)
+			_, err := f.WriteAt(buf[:], int64(indexHeaderSize+slot*indexSlotSize))
+			return err
+		}
+	}
+}
+
+func (s *FileStorage) levelFile(level uint) (*os.File, error) {
+	if s.levels[level] == nil {
+		f, err := os.OpenFile(filepath.Join(s.dir, levelFileName(level)), os.O_RDWR|os.O_CREATE, 0644)
+		if err != nil {
+			return nil, err
+		}
+		s.levels[level] = f
+	}
+	return s.levels[level], nil
+}
+
+func (s *FileStorage) Size() uint64 {
+	return s.size
+}
+
+func (s *FileStorage) Append(nodes []crypto.Hash) error {
+	if got, want := len(nodes), bits.TrailingZeros64(s.size+1)+1; got != want {
+		return fmt.Errorf("internal error, got %d nodes, expected %d", got, want)
+	}
+	err := s.appendNodes(nodes)
+	if err == nil {
+		if 2*(s.size+1) > s.slots {
+			err = s.rebuildIndex(s.size + 1)
+		} else if err = insertIndex(s.index, s.slots, s.size, &nodes[0]); err == nil {
+			err = s.writeIndexCount(s.size + 1)
+		}
+	}
+	if err != nil {
+		// Try to discard any partially written nodes, so
+		// they're not mistaken for valid nodes later on.
+		for level := range nodes {
+			if f := s.levels[level]; f != nil {
+				f.Truncate(int64((s.size >> level) * crypto.HashSize))
+			}
+		}
+		return err
+	}
+	s.size++
+	return nil
+}
+
+func (s *FileStorage) appendNodes(nodes []crypto.Hash) error {
+	for level, h := range nodes {
+		f, err := s.levelFile(uint(level))
+		if err != nil {
+			return err
+		}
+		if _, err := f.WriteAt(h[:], int64((s.size>>level)*crypto.HashSize)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *FileStorage) GetNodeHash(level uint, index uint64) (crypto.Hash, error) {
+	if level >= 64 || index >= s.size>>level {
+		return crypto.Hash{}, fmt.Errorf("invalid argument level %d, index %d, tree %d", level, index, s.size)
+	}
+	f, err := s.levelFile(level)
+	if err != nil {
+		return crypto.Hash{}, err
+	}
+	var h crypto.Hash
+	if _, err := f.ReadAt(h[:], int64(index*crypto.HashSize)); err != nil {
+		return crypto.Hash{}, fmt.Errorf("reading node at level %d, index %d failed: %v", level, index, err)
+	}
+	return h, nil
+}
+
+func (s *FileStorage) LookupLeaf(leafHash *crypto.Hash) (uint64, bool, error) {
+	for slot := homeSlot(leafHash, s.slots); ; slot = (slot + 1) & (s.slots - 1) {
+		v, err := readSlot(s.index, slot)
+		if err != nil {
+			return 0, false, err
+		}
+		if v == 0 {
+			return 0, false, nil
+		}
+		if v > s.size {
+			// Stale slot.
+			continue
+		}
+		h, err := s.GetNodeHash(0, v-1)
+		if err != nil {
+			return 0, false, err
+		}
+		if h == *leafHash {
+			return v - 1, true, nil
+		}
+	}
+}
+
+// Sync flushes all appended data to disk.
+func (s *FileStorage) Sync() error {
+	for _, f := range s.levels {
+		if f != nil {
+			if err := f.Sync(); err != nil {
+				return err
+			}
+		}
+	}
+	return s.index.Sync()
+}
+
+func (s *FileStorage) Close() error {
+	var err error
+	for i, f := range s.levels {
+		if f != nil {
+			if e := f.Close(); err == nil {
+				err = e
+			}
+			s.levels[i] = nil
+		}
+	}
+	if s.index != nil {
+		if e := s.index.Close(); err == nil {
+			err = e
+		}
+		s.index = nil
+	}
+	return err
+}
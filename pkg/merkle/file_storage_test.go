@@ -0,0 +1,187 @@
+package merkle
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"sigsum.org/sigsum-go/pkg/crypto"
+)
+
+func newFileTree(t *testing.T, dir string, size uint64) (Tree, *FileStorage) {
+	t.Helper()
+	s, err := OpenFileStorage(dir, size)
+	if err != nil {
+		t.Fatalf("OpenFileStorage failed: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	tree, err := NewTreeWithStorage(s)
+	if err != nil {
+		t.Fatalf("NewTreeWithStorage failed: %v", err)
+	}
+	return tree, s
+}
+
+// Checks that the trees have the same size, root hash and proofs, and
+// that all leaves can be looked up.
+func checkSameTree(t *testing.T, got, want *Tree, leaves []crypto.Hash) {
+	t.Helper()
+	if got.Size() != want.Size() {
+		t.Fatalf("unexpected size %d, want %d", got.Size(), want.Size())
+	}
+	if got.GetRootHash() != want.GetRootHash() {
+		t.Fatalf("unexpected root hash at size %d", got.Size())
+	}
+	size := got.Size()
+	for i := uint64(0); i < size; i++ {
+		if index, err := got.GetLeafIndex(&leaves[i]); err != nil || index != i {
+			t.Fatalf("GetLeafIndex %d failed, got %d, err %v", i, index, err)
+		}
+	}
+	if size < uint64(len(leaves)) {
+		if _, err := got.GetLeafIndex(&leaves[size]); err == nil {
+			t.Errorf("leaf at index %d unexpectedly present", size)
+		}
+	}
+	if size == 0 {
+		return
+	}
+	for _, i := range []uint64{0, size / 3, size - 1} {
+		gotProof, err := got.ProveInclusion(i, size)
+		if err != nil {
+			t.Fatalf("ProveInclusion %d, %d failed: %v", i, size, err)
+		}
+		wantProof, err := want.ProveInclusion(i, size)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(gotProof, wantProof) {
+			t.Errorf("unexpected inclusion proof for index %d, size %d", i, size)
+		}
+		gotProof, err = got.ProveConsistency(i+1, size)
+		if err != nil {
+			t.Fatalf("ProveConsistency %d, %d failed: %v", i+1, size, err)
+		}
+		wantProof, err = want.ProveConsistency(i+1, size)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(gotProof, wantProof) {
+			t.Errorf("unexpected consistency proof for sizes %d, %d", i+1, size)
+		}
+	}
+}
+
+func newMemoryTree(leaves []crypto.Hash) Tree {
+	tree := NewTree()
+	for _, h := range leaves {
+		tree.AddLeafHash(&h)
+	}
+	return tree
+}
+
+func TestFileStorage(t *testing.T) {
+	// Large enough to grow the leaf index.
+	leaves := newLeaves(1500)
+	tree, _ := newFileTree(t, t.TempDir(), 0)
+	want := NewTree()
+	for i, h := range leaves {
+		added, err := tree.AppendLeafHash(&h)
+		if err != nil || !added {
+			t.Fatalf("AppendLeafHash failed at index %d, added %v, err %v", i, added, err)
+		}
+		want.AddLeafHash(&h)
+		if got := tree.GetRootHash(); got != want.GetRootHash() {
+			t.Fatalf("unexpected root hash at size %d", tree.Size())
+		}
+	}
+	if added, err := tree.AppendLeafHash(&leaves[17]); err != nil || added {
+		t.Errorf("duplicate leaf: added %v, err %v", added, err)
+	}
+	checkSameTree(t, &tree, &want, leaves)
+}
+
+func TestFileStorageReopen(t *testing.T) {
+	dir := t.TempDir()
+	leaves := newLeaves(100)
+	tree, s := newFileTree(t, dir, 0)
+	for _, h := range leaves {
+		if _, err := tree.AppendLeafHash(&h); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	if _, err := OpenFileStorage(dir, 101); err == nil {
+		t.Errorf("reopening with too large size succeeded")
+	}
+	for _, size := range []int{100, 70, 64, 3, 0} {
+		tree, s = newFileTree(t, dir, uint64(size))
+		want := newMemoryTree(leaves[:size])
+		checkSameTree(t, &tree, &want, leaves)
+		s.Close()
+	}
+	// Append different leaves after truncation.
+	other := newLeaves(120)[90:]
+	tree, s = newFileTree(t, dir, 0)
+	for _, h := range other {
+		if _, err := tree.AppendLeafHash(&h); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := newMemoryTree(other)
+	checkSameTree(t, &tree, &want, other)
+}
+
+func TestFileStorageRecovery(t *testing.T) {
+	dir := t.TempDir()
+	leaves := newLeaves(50)
+	tree, s := newFileTree(t, dir, 0)
+	for _, h := range leaves[:40] {
+		if _, err := tree.AppendLeafHash(&h); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.Close()
+
+	// Simulate a crash in the middle of appending leaves: a
+	// partial leaf record, missing interior nodes, and a leaf
+	// index that is behind.
+	f, err := os.OpenFile(filepath.Join(dir, levelFileName(0)), os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write(leaves[40][:10]); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	if err := os.Truncate(filepath.Join(dir, levelFileName(2)), 3*crypto.HashSize+5); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(dir, levelFileName(3))); err != nil {
+		t.Fatal(err)
+	}
+	f, err = os.OpenFile(filepath.Join(dir, leafIndexFileName), os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteAt(make([]byte, indexHeaderSize), 0); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	tree, s = newFileTree(t, dir, 40)
+	want := newMemoryTree(leaves[:40])
+	checkSameTree(t, &tree, &want, leaves)
+	for _, h := range leaves[40:] {
+		if _, err := tree.AppendLeafHash(&h); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want = newMemoryTree(leaves)
+	checkSameTree(t, &tree, &want, leaves)
+}
@@ -0,0 +1,236 @@
+package merkle
+
+import (
+	"fmt"
+	"math/bits"
+	"slices"
+
+	"sigsum.org/sigsum-go/pkg/crypto"
+)
+
+// Returns the hash of the complete subtree of height level, with the
+// given index among nodes at that level.
+type GetNodeHashFunc func(level uint, index uint64) (crypto.Hash, error)
+
+// Returns the root hash of leaves [start, end), where start must be a
+// multiple of the largest power of two not exceeding end - start.
+// Such a range is then decomposed into complete subtrees, largest
+// first.
+func rootOfRange(getNode GetNodeHashFunc, start, end uint64) (crypto.Hash, error) {
+	cr := compactRange{}
+	for start < end {
+		level := uint(bits.Len64(end-start) - 1)
+		h, err := getNode(level, start>>level)
+		if err != nil {
+			return crypto.Hash{}, err
+		}
+		cr = append(cr, h)
+		start += uint64(1) << level
+	}
+	return cr.getRootHash(), nil
+}
+
+// Returns the root hash of leaves [start, end), for a subtree on a
+// proof path.
+type subtreeFunc func(start, end uint64) (crypto.Hash, error)
+
+func subtreeFromNodes(getNode GetNodeHashFunc) subtreeFunc {
+	return func(start, end uint64) (crypto.Hash, error) {
+		return rootOfRange(getNode, start, end)
+	}
+}
+
+// Produces inclusion path in rfc 9162 order, for index m, in the
+// subtree of leaves [start, end).
+func inclusionPath(subtree subtreeFunc, m, start, end uint64) ([]crypto.Hash, error) {
+	if end-start == 1 {
+		return []crypto.Hash{}, nil
+	}
+	k := split(end - start)
+	var p []crypto.Hash
+	var h crypto.Hash
+	var err error
+	if m < start+k {
+		if p, err = inclusionPath(subtree, m, start, start+k); err != nil {
+			return nil, err
+		}
+		h, err = subtree(start+k, end)
+	} else {
+		if p, err = inclusionPath(subtree, m, start+k, end); err != nil {
+			return nil, err
+		}
+		h, err = subtree(start, start+k)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return append(p, h), nil
+}
+
+// ProveInclusionFromNodes produces an inclusion proof for the leaf
+// at index, in the tree of the given size, using only hashes of
+// complete subtrees. The number of getNode calls is O(log(size)): all
+// subtrees on the path are complete, except possibly one sibling
+// along the right edge of the tree, which is decomposed into at most
+// log(size) complete subtrees.
+func ProveInclusionFromNodes(getNode GetNodeHashFunc, index, size uint64) ([]crypto.Hash, error) {
+	if index >= size {
+		return nil, fmt.Errorf("invalid argument index %d, size %d", index, size)
+	}
+	return inclusionPath(subtreeFromNodes(getNode), index, 0, size)
+}
+
+// Based on RFC 9162, 2.1.4.1, for the subtree of leaves [start, end).
+func consistencyPath(subtree subtreeFunc, m, start, end uint64, complete bool) ([]crypto.Hash, error) {
+	if m == end {
+		if complete {
+			return []crypto.Hash{}, nil
+		}
+		h, err := subtree(start, end)
+		if err != nil {
+			return nil, err
+		}
+		return []crypto.Hash{h}, nil
+	}
+	k := split(end - start)
+	var p []crypto.Hash
+	var h crypto.Hash
+	var err error
+	if m <= start+k {
+		if p, err = consistencyPath(subtree, m, start, start+k, complete); err != nil {
+			return nil, err
+		}
+		h, err = subtree(start+k, end)
+	} else {
+		if p, err = consistencyPath(subtree, m, start+k, end, false); err != nil {
+			return nil, err
+		}
+		h, err = subtree(start, start+k)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return append(p, h), nil
+}
+
+// ProveConsistencyFromNodes produces a consistency proof between
+// trees of size m and n, using only hashes of complete subtrees. Like
+// for ProveInclusionFromNodes, the number of getNode calls is
+// O(log(n)).
+func ProveConsistencyFromNodes(getNode GetNodeHashFunc, m, n uint64) ([]crypto.Hash, error) {
+	if m > n {
+		return nil, fmt.Errorf("invalid argument m %d, n %d", m, n)
+	}
+	if m == 0 || m == n {
+		return []crypto.Hash{}, nil
+	}
+	return consistencyPath(subtreeFromNodes(getNode), m, 0, n, true)
+}
+
+// Returns the leaf ranges [start, end) of the subtrees on a proof
+// path, in the same order as the path.
+func pathRanges(prove func(subtreeFunc) ([]crypto.Hash, error)) [][2]uint64 {
+	var ranges [][2]uint64
+	prove(func(start, end uint64) (crypto.Hash, error) {
+		ranges = append(ranges, [2]uint64{start, end})
+		return crypto.Hash{}, nil
+	})
+	return ranges
+}
+
+// ProveRangeFromNodes produces a proof that the leaves [start, end)
+// are included in the tree of the given size. The proof consists of
+// the hashes of the complete subtrees covering [0, start), followed
+// by the hashes of the subtrees to the right of the range on the
+// inclusion path of the last leaf, all in left to right order. These
+// are the hashes from the inclusion paths of the first and last
+// leaves (as used by VerifyInclusionBatch) that can't be computed
+// from the leaves themselves, with each hash included only once. See
+// VerifyRange.
+func ProveRangeFromNodes(getNode GetNodeHashFunc, start, end, size uint64) ([]crypto.Hash, error) {
+	if start >= end || end > size {
+		return nil, fmt.Errorf("invalid argument start %d, end %d, size %d", start, end, size)
+	}
+	p := []crypto.Hash{}
+	var index uint64
+	for _, level := range rangeLevels(0, start) {
+		h, err := getNode(level, index>>level)
+		if err != nil {
+			return nil, err
+		}
+		p = append(p, h)
+		index += uint64(1) << level
+	}
+	subtree := subtreeFromNodes(getNode)
+	for _, r := range pathRanges(func(subtree subtreeFunc) ([]crypto.Hash, error) {
+		return inclusionPath(subtree, end-1, 0, size)
+	}) {
+		if r[0] < end {
+			continue
+		}
+		h, err := subtree(r[0], r[1])
+		if err != nil {
+			return nil, err
+		}
+		p = append(p, h)
+	}
+	return p, nil
+}
+
+// Checks that indices is non-empty, strictly increasing, and that all
+// indices are within a tree of the given size.
+func checkMultiIndices(indices []uint64, size uint64) error {
+	if len(indices) == 0 {
+		return fmt.Errorf("invalid argument, no leaf indices")
+	}
+	for i := 1; i < len(indices); i++ {
+		if indices[i] <= indices[i-1] {
+			return fmt.Errorf("invalid argument, leaf indices not strictly increasing")
+		}
+	}
+	if last := indices[len(indices)-1]; last >= size {
+		return fmt.Errorf("invalid argument index %d, size %d", last, size)
+	}
+	return nil
+}
+
+// Produces the multiproof path for the subtree of leaves [start,
+// end), containing the given sorted indices.
+func multiPath(subtree subtreeFunc, indices []uint64, start, end uint64) ([]crypto.Hash, error) {
+	if len(indices) == 0 {
+		h, err := subtree(start, end)
+		if err != nil {
+			return nil, err
+		}
+		return []crypto.Hash{h}, nil
+	}
+	if end-start == 1 {
+		return []crypto.Hash{}, nil
+	}
+	k := split(end - start)
+	i, _ := slices.BinarySearch(indices, start+k)
+	left, err := multiPath(subtree, indices[:i], start, start+k)
+	if err != nil {
+		return nil, err
+	}
+	right, err := multiPath(subtree, indices[i:], start+k, end)
+	if err != nil {
+		return nil, err
+	}
+	return append(left, right...), nil
+}
+
+// ProveMultiFromNodes produces a proof that the leaves at the given
+// indices, which must be strictly increasing, are included in the
+// tree of the given size. The proof consists of the hashes of the
+// maximal subtrees that contain none of the leaves, in left to right
+// order. Each of these hashes appears on the inclusion path of at
+// least one of the leaves, but hashes shared between paths, or that
+// can be computed from the leaves themselves, are omitted. See
+// VerifyMulti.
+func ProveMultiFromNodes(getNode GetNodeHashFunc, indices []uint64, size uint64) ([]crypto.Hash, error) {
+	if err := checkMultiIndices(indices, size); err != nil {
+		return nil, err
+	}
+	return multiPath(subtreeFromNodes(getNode), indices, 0, size)
+}
@@ -0,0 +1,76 @@
+package merkle
+
+import (
+	"fmt"
+
+	"sigsum.org/sigsum-go/pkg/crypto"
+)
+
+// Storage holds the leaf hashes of a Tree, and possibly hashes of
+// interior nodes. Like Tree, implementations need not be concurrency
+// safe.
+type Storage interface {
+	// Number of leaves stored.
+	Size() uint64
+	// Appends a leaf. The nodes are the hashes of all complete
+	// subtrees ending with the new leaf: nodes[0] is the leaf
+	// hash itself, and nodes[level] is the hash of the node at
+	// that level, for levels up to the number of trailing zeros
+	// of the new size. Storage may cache these hashes, or
+	// ignore all but the leaf hash.
+	Append(nodes []crypto.Hash) error
+	// Returns the hash of the complete subtree of height level,
+	// with the given index among nodes at that level. Level zero
+	// corresponds to leaf hashes. Callers must ensure that the
+	// node is within the stored tree.
+	GetNodeHash(level uint, index uint64) (crypto.Hash, error)
+	// Returns the index of a leaf hash, and false if not
+	// present.
+	LookupLeaf(leafHash *crypto.Hash) (uint64, bool, error)
+}
+
+// The default storage, keeping all leaf hashes and the hashes of all
+// complete interior nodes in memory. Since any node hash is available
+// without rehashing, proofs need only O(log n) lookups. Caching the
+// interior nodes roughly doubles the memory needed for leaf hashes.
+type memoryStorage struct {
+	// Node hashes, indexed by level; level zero holds the leaf
+	// hashes.
+	levels [][]crypto.Hash
+	// Maps leaf hash to index.
+	leafIndex map[crypto.Hash]int
+}
+
+func newMemoryStorage() *memoryStorage {
+	return &memoryStorage{levels: [][]crypto.Hash{nil}, leafIndex: make(map[crypto.Hash]int)}
+}
+
+func (s *memoryStorage) Size() uint64 {
+	return uint64(len(s.levels[0]))
+}
+
+func (s *memoryStorage) Append(nodes []crypto.Hash) error {
+	if len(nodes) == 0 {
+		return fmt.Errorf("internal error, no leaf hash")
+	}
+	s.leafIndex[nodes[0]] = len(s.levels[0])
+	for level, h := range nodes {
+		if level == len(s.levels) {
+			s.levels = append(s.levels, nil)
+		}
+		s.levels[level] = append(s.levels[level], h)
+	}
+	return nil
+}
+
+func (s *memoryStorage) GetNodeHash(level uint, index uint64) (crypto.Hash, error) {
+	if level >= uint(len(s.levels)) || index >= uint64(len(s.levels[level])) {
+		return crypto.Hash{}, fmt.Errorf("invalid argument level %d, index %d, tree %d", level, index, s.Size())
+	}
+	return s.levels[level][index], nil
+}
+
+func (s *memoryStorage) LookupLeaf(leafHash *crypto.Hash) (uint64, bool, error) {
+	i, ok := s.leafIndex[*leafHash]
+	return uint64(i), ok, nil
+}
@@ -7,8 +7,7 @@ import (
 	"sigsum.org/sigsum-go/pkg/crypto"
 )
 
-// Represents a compact range starting at index zero. See
-// https://github.com/transparency-dev/merkle/blob/main/docs/compact_ranges.md
+// Represents a compact range starting at index zero, see CompactRange
 // for the general definition.
 type compactRange []crypto.Hash
 
@@ -23,7 +22,7 @@ func (cr compactRange) extend(i uint64, h crypto.Hash,
 }
 
 // Returns a compact range for leaves starting at index zero.
-func newCompactRange(leaves []crypto.Hash) compactRange {
+func compactRangeOf(leaves []crypto.Hash) compactRange {
 	cr := compactRange{}
 	for i, leaf := range leaves {
 		cr = cr.extend(uint64(i), leaf, HashInteriorNode)
@@ -45,167 +44,138 @@ func (cr compactRange) getRootHash() crypto.Hash {
 // Represents a tree of leaf hashes. Not concurrency safe; needs
 // external synchronization.
 type Tree struct {
-	leaves []crypto.Hash
-	// Maps leaf hash to index.
-	leafIndex map[crypto.Hash]int
+	storage Storage
 	// Compact range; hash of one power-of-two subtree per one-bit
 	// in current size.
 	cRange compactRange
 }
 
+// Returns an empty tree, kept in memory.
 func NewTree() Tree {
-	return Tree{leafIndex: make(map[crypto.Hash]int)}
+	return Tree{storage: newMemoryStorage()}
+}
+
+// Returns a tree backed by the given storage, which may already
+// hold leaves, e.g., a FileStorage reopened at some size.
+func NewTreeWithStorage(s Storage) (Tree, error) {
+	size := s.Size()
+	cRange := compactRange{}
+	for start := uint64(0); start < size; {
+		level := uint(bits.Len64(size-start) - 1)
+		h, err := s.GetNodeHash(level, start>>level)
+		if err != nil {
+			return Tree{}, fmt.Errorf("reading node at level %d, index %d failed: %v", level, start>>level, err)
+		}
+		cRange = append(cRange, h)
+		start += uint64(1) << level
+	}
+	return Tree{storage: s, cRange: cRange}, nil
 }
 
 func (t *Tree) Size() uint64 {
-	return uint64(len(t.leaves))
+	return t.storage.Size()
 }
 
-// Returns true if added, false for duplicates.
+// Returns true if added, false for duplicates. Panics if the storage
+// fails, so it should be used only with storage that can't fail,
+// like the default in-memory storage.
 func (t *Tree) AddLeafHash(leafHash *crypto.Hash) bool {
-	if _, ok := t.leafIndex[*leafHash]; ok {
-		return false
+	added, err := t.AppendLeafHash(leafHash)
+	if err != nil {
+		panic(fmt.Errorf("merkle tree storage failed: %v", err))
+	}
+	return added
+}
+
+// Like AddLeafHash, but returns any storage error. On error, the
+// tree is left unchanged.
+func (t *Tree) AppendLeafHash(leafHash *crypto.Hash) (bool, error) {
+	if _, ok, err := t.storage.LookupLeaf(leafHash); err != nil || ok {
+		return false, err
 	}
+	// Collect hashes of the complete subtrees ending with the
+	// new leaf, without modifying cRange until the storage has
+	// accepted them.
 	h := *leafHash
-	t.leafIndex[h] = len(t.leaves)
-	t.leaves = append(t.leaves, h)
-	t.cRange = t.cRange.extend(uint64(len(t.leaves))-1, h, HashInteriorNode)
-	return true
+	nodes := []crypto.Hash{h}
+	i := len(t.cRange)
+	for s := t.Size() + 1; i > 0 && isEven(s); s >>= 1 {
+		i--
+		h = HashInteriorNode(&t.cRange[i], &h)
+		nodes = append(nodes, h)
+	}
+	if err := t.storage.Append(nodes); err != nil {
+		return false, err
+	}
+	t.cRange = append(t.cRange[:i], h)
+	return true, nil
 }
 
 func (t *Tree) GetLeafIndex(leafHash *crypto.Hash) (uint64, error) {
-	if i, ok := t.leafIndex[*leafHash]; ok {
-		return uint64(i), nil
+	i, ok, err := t.storage.LookupLeaf(leafHash)
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		return 0, fmt.Errorf("leaf hash not present")
 	}
-	return 0, fmt.Errorf("leaf hash not present")
+	return i, nil
 }
 
 func (t *Tree) GetRootHash() crypto.Hash {
 	return t.cRange.getRootHash()
 }
 
-func rootOf(leaves []crypto.Hash) crypto.Hash {
-	return newCompactRange(leaves).getRootHash()
-}
-
-func reversePath(p []crypto.Hash) []crypto.Hash {
-	n := len(p)
-	for i := 0; i < n-1-i; i++ {
-		p[i], p[n-1-i] = p[n-1-i], p[i]
+// Returns the hash of the complete subtree of height level, with
+// the given index among nodes at that level.
+func (t *Tree) GetNodeHash(level uint, index uint64) (crypto.Hash, error) {
+	size := t.Size()
+	if level >= 64 || index >= size>>level {
+		return crypto.Hash{}, fmt.Errorf("invalid argument level %d, index %d, tree %d", level, index, size)
+	}
+	// Nodes on the compact range are available without asking
+	// the storage; useful for proofs close to the end of the
+	// tree.
+	if (size>>level)&1 == 1 && index == (size>>level)-1 {
+		return t.cRange[bits.OnesCount64(size>>(level+1))], nil
 	}
-	return p
+	return t.storage.GetNodeHash(level, index)
 }
 
-// Produces inclusion path from root down (opposite to rfc 9162 order).
-// cRange and size represent the larger tree, where leaves is a prefix.
-func inclusion(leaves []crypto.Hash, m uint64, cRange []crypto.Hash, size uint64) []crypto.Hash {
-	p := []crypto.Hash{}
-
-	// Try reusing hashes of internal nodes on the cRange; useful
-	// if m and len(leaves) are close to the end of the tree.
-	for len(leaves) > 1 && len(cRange) > 1 {
-		// Size of subtree represented by cRange[0]
-		k := split(size)
-		if m < k {
-			// Could possibly use some other elements of
-			// cRange, but it gets complicated.
-			break
-		}
-		// k gives a valid split also for the subtree
-		// for which we prove inclusion.
-		p = append(p, cRange[0])
-		cRange = cRange[1:]
-		size -= k
-		leaves = leaves[k:]
-		m -= k
-	}
-
-	for len(leaves) > 1 {
-		n := uint64(len(leaves))
-		k := split(n)
-
-		// We select the subtree which m is in, for further
-		// processing, after adding the hash of the other
-		// subtree to the path.
-		if m < k {
-			p = append(p, rootOf(leaves[k:]))
-			leaves = leaves[:k]
-		} else {
-			p = append(p, rootOf(leaves[:k]))
-			leaves = leaves[k:]
-			m -= k
-		}
-	}
-	return p
+func rootOf(leaves []crypto.Hash) crypto.Hash {
+	return compactRangeOf(leaves).getRootHash()
 }
 
 func (t *Tree) ProveInclusion(index, size uint64) ([]crypto.Hash, error) {
 	if index >= size || size > t.Size() {
 		return nil, fmt.Errorf("invalid argument index %d, size %d, tree %d", index, size, t.Size())
 	}
-	return reversePath(inclusion(t.leaves[:size], index, t.cRange, t.Size())), nil
-}
-
-// Based on RFC 9162, 2.1.4.1, but produces path in opposite order.
-func consistency(leaves []crypto.Hash, m uint64, cRange []crypto.Hash, size uint64) []crypto.Hash {
-	p := []crypto.Hash{}
-	complete := true
-
-	// Try reusing hashes of internal nodes on the cRange; useful
-	// if m and len(leaves) are close to the end of the tree.
-	for len(cRange) > 1 {
-		n := uint64(len(leaves))
-		if m == n {
-			break
-		}
-		// Size of subtree represented by cRange[0]
-		k := split(size)
-		if m <= k {
-			// Could possibly use some other elements of
-			// cRange, but it gets complicated.
-			break
-		}
-		// k gives a valid split also for the subtree
-		// for which we prove consistency.
-		p = append(p, cRange[0])
-		cRange = cRange[1:]
-		size -= k
-		leaves = leaves[k:]
-		m -= k
-		complete = false
-	}
-	for {
-		n := uint64(len(leaves))
-		if m > n {
-			panic(fmt.Errorf("internal error, m %d, n %d", m, n))
-		}
-		if m == n {
-			if complete {
-				return p
-			}
-			return append(p, rootOf(leaves))
-		}
-		k := split(n)
-		if m <= k {
-			p = append(p, rootOf(leaves[k:]))
-			leaves = leaves[:k]
-		} else {
-			p = append(p, rootOf(leaves[:k]))
-			leaves = leaves[k:]
-			m -= k
-			complete = false
-		}
-	}
+	return ProveInclusionFromNodes(t.GetNodeHash, index, size)
 }
 
 func (t *Tree) ProveConsistency(m, n uint64) ([]crypto.Hash, error) {
 	if n > t.Size() || m > n {
 		return nil, fmt.Errorf("invalid argument m %d, n %d, tree %d", m, n, t.Size())
 	}
-	if m == 0 || m == n {
-		return []crypto.Hash{}, nil
+	return ProveConsistencyFromNodes(t.GetNodeHash, m, n)
+}
+
+// Returns a proof that the leaves [start, end) are included in the
+// tree of the given size, see ProveRangeFromNodes.
+func (t *Tree) ProveRange(start, end, size uint64) ([]crypto.Hash, error) {
+	if start >= end || end > size || size > t.Size() {
+		return nil, fmt.Errorf("invalid argument start %d, end %d, size %d, tree %d", start, end, size, t.Size())
+	}
+	return ProveRangeFromNodes(t.GetNodeHash, start, end, size)
+}
+
+// Returns a multiproof for the leaves at the given indices, in the
+// tree of the given size, see ProveMultiFromNodes.
+func (t *Tree) ProveMulti(indices []uint64, size uint64) ([]crypto.Hash, error) {
+	if size > t.Size() {
+		return nil, fmt.Errorf("invalid argument size %d, tree %d", size, t.Size())
 	}
-	return reversePath(consistency(t.leaves[:n], m, t.cRange, t.Size())), nil
+	return ProveMultiFromNodes(t.GetNodeHash, indices, size)
 }
 
 // Returns largest power of 2 smaller than n. Requires n >= 2.
@@ -1,6 +1,7 @@
 package merkle
 
 import (
+	"fmt"
 	"slices"
 	"testing"
 
@@ -49,11 +50,17 @@ func TestInternal(t *testing.T) {
 		if !tree.AddLeafHash(&h) {
 			t.Fatalf("AddLeafHash failed at size %d", tree.Size())
 		}
-		if len(tree.leaves) != len(tree.leafIndex) {
+		s := tree.storage.(*memoryStorage)
+		if len(s.levels[0]) != len(s.leafIndex) {
 			t.Fatalf("invalid state: %d leaves, %d index entries",
-				len(tree.leaves), len(tree.leafIndex))
+				len(s.levels[0]), len(s.leafIndex))
 		}
-		if popc := bits.OnesCount(uint(len(tree.leaves))); popc != len(tree.cRange) {
+		for level := 1; level < len(s.levels); level++ {
+			if got, want := len(s.levels[level]), len(s.levels[0])>>level; got != want {
+				t.Fatalf("invalid state: %d nodes at level %d, want %d", got, level, want)
+			}
+		}
+		if popc := bits.OnesCount(uint(len(s.levels[0]))); popc != len(tree.cRange) {
 			t.Fatalf("internal error: popc %d, len 0x%x", popc, len(tree.cRange))
 		}
 	}
@@ -368,3 +375,298 @@ func newLeaves(n int) []crypto.Hash {
 	}
 	return hashes
 }
+
+func TestProveFromNodes(t *testing.T) {
+	leaves := newLeaves(70)
+	tree := NewTree()
+	for _, h := range leaves {
+		if !tree.AddLeafHash(&h) {
+			t.Fatalf("AddLeafHash failed at size %d", tree.Size())
+		}
+	}
+	// Computes all nodes from the leaves, bypassing the tree's
+	// compact range.
+	getNode := func(level uint, index uint64) (crypto.Hash, error) {
+		return rootOf(leaves[index<<level : (index+1)<<level]), nil
+	}
+	for n := uint64(1); n <= tree.Size(); n++ {
+		for i := uint64(0); i < n; i++ {
+			want, err := tree.ProveInclusion(i, n)
+			if err != nil {
+				t.Fatalf("ProveInclusion %d, %d failed: %v", i, n, err)
+			}
+			got, err := ProveInclusionFromNodes(getNode, i, n)
+			if err != nil {
+				t.Fatalf("ProveInclusionFromNodes %d, %d failed: %v", i, n, err)
+			}
+			if !slices.Equal(got, want) {
+				t.Errorf("unexpected inclusion path i %d, n %d\n  got: %x\n want: %x\n",
+					i, n, got, want)
+			}
+		}
+		for m := uint64(0); m <= n; m++ {
+			want, err := tree.ProveConsistency(m, n)
+			if err != nil {
+				t.Fatalf("ProveConsistency %d, %d failed: %v", m, n, err)
+			}
+			got, err := ProveConsistencyFromNodes(getNode, m, n)
+			if err != nil {
+				t.Fatalf("ProveConsistencyFromNodes %d, %d failed: %v", m, n, err)
+			}
+			if !slices.Equal(got, want) {
+				t.Errorf("unexpected consistency path m %d, n %d\n  got: %x\n want: %x\n",
+					m, n, got, want)
+			}
+		}
+	}
+}
+
+// Checks that proofs need only O(log n) node lookups.
+func TestProofNodeCount(t *testing.T) {
+	tree := NewTree()
+	for _, h := range newLeaves(300) {
+		if !tree.AddLeafHash(&h) {
+			t.Fatalf("AddLeafHash failed at size %d", tree.Size())
+		}
+	}
+	count := 0
+	getNode := func(level uint, index uint64) (crypto.Hash, error) {
+		count++
+		return tree.storage.GetNodeHash(level, index)
+	}
+	for n := uint64(1); n <= tree.Size(); n++ {
+		limit := 2 * bits.Len64(n)
+		for i := uint64(0); i < n; i++ {
+			count = 0
+			if _, err := ProveInclusionFromNodes(getNode, i, n); err != nil {
+				t.Fatal(err)
+			}
+			if count > limit {
+				t.Errorf("inclusion proof for index %d, size %d needed %d lookups, max %d", i, n, count, limit)
+			}
+			count = 0
+			if _, err := ProveConsistencyFromNodes(getNode, i, n); err != nil {
+				t.Fatal(err)
+			}
+			if count > limit {
+				t.Errorf("consistency proof for sizes %d, %d needed %d lookups, max %d", i, n, count, limit)
+			}
+		}
+	}
+}
+
+var benchmarkSizes = []int{1 << 10, 1 << 16, 1 << 20}
+
+func newBenchmarkTree(b *testing.B, leaves []crypto.Hash) Tree {
+	b.Helper()
+	tree := NewTree()
+	for _, h := range leaves {
+		if !tree.AddLeafHash(&h) {
+			b.Fatalf("AddLeafHash failed at size %d", tree.Size())
+		}
+	}
+	return tree
+}
+
+func BenchmarkAddLeafHash(b *testing.B) {
+	leaves := newLeaves(b.N)
+	b.ResetTimer()
+	newBenchmarkTree(b, leaves)
+}
+
+func BenchmarkProveInclusion(b *testing.B) {
+	for _, size := range benchmarkSizes {
+		b.Run(fmt.Sprintf("size=%d", size), func(b *testing.B) {
+			tree := newBenchmarkTree(b, newLeaves(size))
+			r := rand.New(rand.NewSource(17))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				// Random index and historical tree size.
+				n := 1 + uint64(r.Int63n(int64(size)))
+				if _, err := tree.ProveInclusion(uint64(r.Int63n(int64(n))), n); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkProveConsistency(b *testing.B) {
+	for _, size := range benchmarkSizes {
+		b.Run(fmt.Sprintf("size=%d", size), func(b *testing.B) {
+			tree := newBenchmarkTree(b, newLeaves(size))
+			r := rand.New(rand.NewSource(17))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				n := 1 + uint64(r.Int63n(int64(size)))
+				if _, err := tree.ProveConsistency(uint64(r.Int63n(int64(n))), n); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestProveRange(t *testing.T) {
+	leaves := newLeaves(40)
+	tree := newMemoryTree(leaves)
+	for size := uint64(1); size <= 40; size++ {
+		root := rootOf(leaves[:size])
+		for start := uint64(0); start < size; start++ {
+			startPath, err := tree.ProveInclusion(start, size)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for end := start + 1; end <= size; end++ {
+				proof, err := tree.ProveRange(start, end, size)
+				if err != nil {
+					t.Fatalf("ProveRange %d, %d, %d failed: %v", start, end, size, err)
+				}
+				if err := VerifyRange(leaves[start:end], start, size, &root, proof); err != nil {
+					t.Errorf("VerifyRange %d, %d, %d failed: %v", start, end, size, err)
+				}
+				// All hashes are on the inclusion path of
+				// the first or the last leaf.
+				endPath, err := tree.ProveInclusion(end-1, size)
+				if err != nil {
+					t.Fatal(err)
+				}
+				for _, h := range proof {
+					if !slices.Contains(startPath, h) && !slices.Contains(endPath, h) {
+						t.Errorf("ProveRange %d, %d, %d: unexpected hash %x", start, end, size, h)
+					}
+				}
+				// Modified leaves are rejected.
+				bad := slices.Clone(leaves[start:end])
+				bad[len(bad)/2][0] ^= 1
+				if err := VerifyRange(bad, start, size, &root, proof); err == nil {
+					t.Errorf("VerifyRange %d, %d, %d accepted modified leaves", start, end, size)
+				}
+				if len(proof) > 0 {
+					if err := VerifyRange(leaves[start:end], start, size, &root, proof[1:]); err == nil {
+						t.Errorf("VerifyRange %d, %d, %d accepted truncated proof", start, end, size)
+					}
+				}
+				if err := VerifyRange(leaves[start:end], start, size, &root, append(proof, root)); err == nil {
+					t.Errorf("VerifyRange %d, %d, %d accepted extended proof", start, end, size)
+				}
+			}
+		}
+	}
+}
+
+func TestProveMulti(t *testing.T) {
+	leaves := newLeaves(70)
+	tree := newMemoryTree(leaves)
+
+	check := func(indices []uint64, size uint64) {
+		t.Helper()
+		root := rootOf(leaves[:size])
+		proof, err := tree.ProveMulti(indices, size)
+		if err != nil {
+			t.Fatalf("ProveMulti %v, %d failed: %v", indices, size, err)
+		}
+		var selected []crypto.Hash
+		var paths [][]crypto.Hash
+		for _, i := range indices {
+			selected = append(selected, leaves[i])
+			path, err := tree.ProveInclusion(i, size)
+			if err != nil {
+				t.Fatal(err)
+			}
+			paths = append(paths, path)
+		}
+		if err := VerifyMulti(selected, indices, size, &root, proof); err != nil {
+			t.Errorf("VerifyMulti %v, %d failed: %v", indices, size, err)
+		}
+		// All hashes are on some inclusion path, and there
+		// are no more of them than in the inclusion path of
+		// the first leaf, plus one per additional leaf and
+		// level.
+		for _, h := range proof {
+			if !slices.ContainsFunc(paths, func(p []crypto.Hash) bool { return slices.Contains(p, h) }) {
+				t.Errorf("ProveMulti %v, %d: unexpected hash %x", indices, size, h)
+			}
+		}
+		if got, limit := len(proof), len(paths[0])+(len(indices)-1)*bits.Len64(size-1); got > limit {
+			t.Errorf("ProveMulti %v, %d: too large proof, %d hashes, limit %d", indices, size, got, limit)
+		}
+		// Modified leaves are rejected.
+		bad := slices.Clone(selected)
+		bad[len(bad)/2][0] ^= 1
+		if err := VerifyMulti(bad, indices, size, &root, proof); err == nil {
+			t.Errorf("VerifyMulti %v, %d accepted modified leaves", indices, size)
+		}
+		if len(indices) > 1 {
+			swapped := slices.Clone(selected)
+			swapped[0], swapped[1] = swapped[1], swapped[0]
+			if err := VerifyMulti(swapped, indices, size, &root, proof); err == nil {
+				t.Errorf("VerifyMulti %v, %d accepted swapped leaves", indices, size)
+			}
+			if err := VerifyMulti(selected[1:], indices[1:], size, &root, proof); err == nil {
+				t.Errorf("VerifyMulti %v, %d accepted missing leaf", indices, size)
+			}
+		}
+		if len(proof) > 0 {
+			if err := VerifyMulti(selected, indices, size, &root, proof[1:]); err == nil {
+				t.Errorf("VerifyMulti %v, %d accepted truncated proof", indices, size)
+			}
+		}
+		if err := VerifyMulti(selected, indices, size, &root, append(proof, root)); err == nil {
+			t.Errorf("VerifyMulti %v, %d accepted extended proof", indices, size)
+		}
+	}
+	// All subsets of small trees.
+	for size := uint64(1); size <= 9; size++ {
+		for set := uint64(1); set < uint64(1)<<size; set++ {
+			var indices []uint64
+			for i := uint64(0); i < size; i++ {
+				if set&(uint64(1)<<i) != 0 {
+					indices = append(indices, i)
+				}
+			}
+			check(indices, size)
+		}
+	}
+	// Random subsets of larger trees.
+	r := rand.New(rand.NewSource(17))
+	for size := uint64(10); size <= 70; size++ {
+		for count := 1; count <= 20; count++ {
+			var indices []uint64
+			for _, i := range r.Perm(int(size))[:min(count, int(size))] {
+				indices = append(indices, uint64(i))
+			}
+			slices.Sort(indices)
+			check(indices, size)
+		}
+	}
+	// Single leaf, same hashes as the inclusion proof.
+	for _, i := range []uint64{0, 17, 69} {
+		proof, err := tree.ProveMulti([]uint64{i}, 70)
+		if err != nil {
+			t.Fatal(err)
+		}
+		path, err := tree.ProveInclusion(i, 70)
+		if err != nil {
+			t.Fatal(err)
+		}
+		slices.SortFunc(proof, func(a, b crypto.Hash) int { return slices.Compare(a[:], b[:]) })
+		slices.SortFunc(path, func(a, b crypto.Hash) int { return slices.Compare(a[:], b[:]) })
+		if !slices.Equal(proof, path) {
+			t.Errorf("ProveMulti for single leaf %d differs from inclusion proof", i)
+		}
+	}
+	// Invalid indices.
+	for _, indices := range [][]uint64{nil, {3, 2}, {2, 2}, {5, 70}} {
+		if _, err := tree.ProveMulti(indices, 70); err == nil {
+			t.Errorf("ProveMulti %v unexpectedly succeeded", indices)
+		}
+	}
+	if _, err := tree.ProveMulti([]uint64{1}, 71); err == nil {
+		t.Errorf("ProveMulti for size larger than tree unexpectedly succeeded")
+	}
+	root := rootOf(leaves[:10])
+	if err := VerifyMulti(leaves[2:4], []uint64{3, 2}, 10, &root, nil); err == nil {
+		t.Errorf("VerifyMulti with unsorted indices unexpectedly succeeded")
+	}
+}
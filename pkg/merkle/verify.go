@@ -228,7 +228,7 @@ func VerifyInclusionBatch(leaves []crypto.Hash, fn, size uint64, root *crypto.Ha
 
 	// Construct the right part of the compact range of the
 	// intermediate leaves.
-	rightRange := newCompactRange(leaves[split-fn : len(leaves)-1])
+	rightRange := compactRangeOf(leaves[split-fn : len(leaves)-1])
 
 	// Process right path; left siblings for the first k levels
 	// should match the compact range.
@@ -300,6 +300,126 @@ func VerifyInclusionTail(leaves []crypto.Hash, fn uint64, root *crypto.Hash, pat
 	return VerifyInclusion(&fr, fn>>(k+1), (sn>>(k+1))+1, root, path[k+1:])
 }
 
+// VerifyRange verifies that a consecutive sequence of leaves,
+// starting at index start, is included in a Merkle tree, using a
+// range proof as produced by ProveRangeFromNodes.
+func VerifyRange(leaves []crypto.Hash, start, size uint64, root *crypto.Hash, path []crypto.Hash) error {
+	if len(leaves) == 0 {
+		return fmt.Errorf("range must be non-empty")
+	}
+	end := start + uint64(len(leaves))
+	if end > size || end < start {
+		return fmt.Errorf("end of range exceeds tree size")
+	}
+	n := len(rangeLevels(0, start))
+	if len(path) < n {
+		return fmt.Errorf("proof input is malformed: path length %d, should be at least %d", len(path), n)
+	}
+	// Compact range for [0, end), from the left part of the path
+	// and the leaves.
+	cr, err := NewCompactRangeFromHashes(0, start, path[:n])
+	if err != nil {
+		return err
+	}
+	for i := range leaves {
+		cr.Append(&leaves[i])
+	}
+	nodes := make(map[node]crypto.Hash)
+	cr.addNodes(nodes)
+
+	// Subtrees to the right of the range, left to right.
+	right := path[n:]
+	var subtree func(lo, hi uint64) (crypto.Hash, error)
+	subtree = func(lo, hi uint64) (crypto.Hash, error) {
+		if lo >= end {
+			if len(right) == 0 {
+				return crypto.Hash{}, fmt.Errorf("proof input is malformed: path too short")
+			}
+			h := right[0]
+			right = right[1:]
+			return h, nil
+		}
+		if hi <= end && bits.OnesCount64(hi-lo) == 1 {
+			level := uint(bits.TrailingZeros64(hi - lo))
+			if h, ok := nodes[node{level: level, index: lo >> level}]; ok {
+				return h, nil
+			}
+			if level == 0 {
+				panic(fmt.Sprintf("internal error, leaf %d not in compact range", lo))
+			}
+		}
+		k := split(hi - lo)
+		lh, err := subtree(lo, lo+k)
+		if err != nil {
+			return crypto.Hash{}, err
+		}
+		rh, err := subtree(lo+k, hi)
+		if err != nil {
+			return crypto.Hash{}, err
+		}
+		return HashInteriorNode(&lh, &rh), nil
+	}
+	r, err := subtree(0, size)
+	if err != nil {
+		return err
+	}
+	if len(right) > 0 {
+		return fmt.Errorf("proof input is malformed: path too long")
+	}
+	if r != *root {
+		return fmt.Errorf("invalid proof: root mismatch")
+	}
+	return nil
+}
+
+// VerifyMulti verifies that the leaves are included in a Merkle tree,
+// at the given indices, which must be strictly increasing, using a
+// multiproof as produced by ProveMultiFromNodes.
+func VerifyMulti(leaves []crypto.Hash, indices []uint64, size uint64, root *crypto.Hash, path []crypto.Hash) error {
+	if len(leaves) != len(indices) {
+		return fmt.Errorf("got %d leaves, but %d indices", len(leaves), len(indices))
+	}
+	if err := checkMultiIndices(indices, size); err != nil {
+		return fmt.Errorf("proof input is malformed: %v", err)
+	}
+	var subtree func(leaves []crypto.Hash, indices []uint64, start, end uint64) (crypto.Hash, error)
+	subtree = func(leaves []crypto.Hash, indices []uint64, start, end uint64) (crypto.Hash, error) {
+		if len(indices) == 0 {
+			if len(path) == 0 {
+				return crypto.Hash{}, fmt.Errorf("proof input is malformed: path too short")
+			}
+			h := path[0]
+			path = path[1:]
+			return h, nil
+		}
+		if end-start == 1 {
+			return leaves[0], nil
+		}
+		k := split(end - start)
+		i, _ := slices.BinarySearch(indices, start+k)
+		lh, err := subtree(leaves[:i], indices[:i], start, start+k)
+		if err != nil {
+			return crypto.Hash{}, err
+		}
+		rh, err := subtree(leaves[i:], indices[i:], start+k, end)
+		if err != nil {
+			return crypto.Hash{}, err
+		}
+		return HashInteriorNode(&lh, &rh), nil
+	}
+	r, err := subtree(leaves, indices, 0, size)
+	if err != nil {
+		return err
+	}
+	if len(path) > 0 {
+		return fmt.Errorf("proof input is malformed: path too long")
+	}
+	if r != *root {
+		return fmt.Errorf("invalid proof: root mismatch")
+	}
+	return nil
+}
+
 func isOdd(num uint64) bool {
 	return (num & 1) != 0
 }
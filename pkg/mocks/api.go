@@ -113,6 +113,21 @@ func (mr *MockLogMockRecorder) GetTreeHead(arg0 interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetTreeHead", reflect.TypeOf((*MockLog)(nil).GetTreeHead), arg0)
 }
 
+// WaitTreeHead mocks base method.
+func (m *MockLog) WaitTreeHead(arg0 context.Context, arg1 requests.TreeHead) (types.CosignedTreeHead, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "WaitTreeHead", arg0, arg1)
+	ret0, _ := ret[0].(types.CosignedTreeHead)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// WaitTreeHead indicates an expected call of WaitTreeHead.
+func (mr *MockLogMockRecorder) WaitTreeHead(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "WaitTreeHead", reflect.TypeOf((*MockLog)(nil).WaitTreeHead), arg0, arg1)
+}
+
 // MockSecondary is a mock of Secondary interface.
 type MockSecondary struct {
 	ctrl     *gomock.Controller
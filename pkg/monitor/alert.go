@@ -12,6 +12,16 @@ const (
 	AlertLogError
 	AlertInvalidLogSignature
 	AlertInconsistentTreeHead
+	// A witness that cosigned the previous tree head didn't
+	// cosign the current one.
+	AlertWitnessStoppedCosigning
+	AlertInvalidCosignature
+	// Verified cosignatures don't satisfy the policy's quorum.
+	AlertNoWitnessQuorum
+	// Newest cosignatures satisfying the quorum are too old.
+	AlertStaleCosignatures
+	// Log doesn't include accepted submissions in its tree.
+	AlertLogNotAdvancing
 )
 
 func (t AlertType) String() string {
@@ -25,11 +35,55 @@ func (t AlertType) String() string {
 		return "Invalid log signature"
 	case AlertInconsistentTreeHead:
 		return "Log tree head not consistent"
+	case AlertWitnessStoppedCosigning:
+		return "Witness stopped cosigning"
+	case AlertInvalidCosignature:
+		return "Invalid witness cosignature"
+	case AlertNoWitnessQuorum:
+		return "Witness quorum not satisfied"
+	case AlertStaleCosignatures:
+		return "Witness cosignatures not fresh"
+	case AlertLogNotAdvancing:
+		return "Log tree not advancing"
 	default:
 		return fmt.Sprintf("Unknown alert type %d", t)
 	}
 }
 
+// Short identifiers, for machine-readable output. Must not change
+// once released.
+var alertTypeNames = map[AlertType]string{
+	AlertOther:                   "other",
+	AlertLogError:                "log-error",
+	AlertInvalidLogSignature:     "invalid-log-signature",
+	AlertInconsistentTreeHead:    "inconsistent-tree-head",
+	AlertWitnessStoppedCosigning: "witness-stopped-cosigning",
+	AlertInvalidCosignature:      "invalid-cosignature",
+	AlertNoWitnessQuorum:         "no-witness-quorum",
+	AlertStaleCosignatures:       "stale-cosignatures",
+	AlertLogNotAdvancing:         "log-not-advancing",
+}
+
+// Returns a short identifier for the alert type, e.g.,
+// "log-error". Used for encoding as json.
+func (t AlertType) MarshalText() ([]byte, error) {
+	name, ok := alertTypeNames[t]
+	if !ok {
+		return nil, fmt.Errorf("unknown alert type %d", t)
+	}
+	return []byte(name), nil
+}
+
+func (t *AlertType) UnmarshalText(text []byte) error {
+	for k, name := range alertTypeNames {
+		if name == string(text) {
+			*t = k
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown alert type %q", text)
+}
+
 type Alert struct {
 	Type AlertType
 	Err  error
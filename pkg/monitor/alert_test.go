@@ -0,0 +1,24 @@
+package monitor
+
+import (
+	"testing"
+)
+
+func TestAlertTypeText(t *testing.T) {
+	for alertType := range alertTypeNames {
+		text, err := alertType.MarshalText()
+		if err != nil {
+			t.Fatalf("marshal of %v failed: %v", alertType, err)
+		}
+		var got AlertType
+		if err := got.UnmarshalText(text); err != nil {
+			t.Fatalf("unmarshal of %q failed: %v", text, err)
+		}
+		if got != alertType {
+			t.Errorf("got %v, want %v", got, alertType)
+		}
+	}
+	if _, err := AlertType(-1).MarshalText(); err == nil {
+		t.Errorf("marshal of invalid alert type succeeded")
+	}
+}
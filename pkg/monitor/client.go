@@ -2,12 +2,17 @@ package monitor
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"sync/atomic"
+	"time"
 
 	"sigsum.org/sigsum-go/pkg/api"
 	"sigsum.org/sigsum-go/pkg/client"
 	"sigsum.org/sigsum-go/pkg/crypto"
+	"sigsum.org/sigsum-go/pkg/log"
 	"sigsum.org/sigsum-go/pkg/merkle"
+	"sigsum.org/sigsum-go/pkg/policy"
 	"sigsum.org/sigsum-go/pkg/requests"
 	"sigsum.org/sigsum-go/pkg/types"
 )
@@ -16,40 +21,139 @@ import (
 // and it verifies consistency and inclusion of anything it returns.
 type monitoringLogClient struct {
 	logKey crypto.PublicKey // Identifies the log monitored.
-	client api.Log
+	client api.LogReader
+	// Policy for witness cosignatures. If nil, cosignatures
+	// are not checked.
+	policy *policy.Policy
+	// Witnesses that cosigned the previous tree head.
+	cosigning map[crypto.Hash]struct{}
+	// Maximum number of leaves the log has been observed to
+	// return for a get-leaves request, or zero if unknown.
+	leafLimit atomic.Uint64
+	// Set when the log has been found not to support range
+	// proofs, see api.RangeProver, to the time (in Unix
+	// nanoseconds) when support should be probed again.
+	noRangeProofsUntil atomic.Int64
 }
 
-func newMonitoringLogClient(logKey *crypto.PublicKey, URL string) *monitoringLogClient {
+// How long to use inclusion proofs only, after finding that a log
+// doesn't support range proofs. Support is then probed again, in
+// case the log has been upgraded.
+const rangeProofProbeInterval = time.Hour
+
+// Implemented by clients that can wait for the log's tree to grow,
+// see api.Log.
+type treeHeadWaiter interface {
+	WaitTreeHead(context.Context, requests.TreeHead) (types.CosignedTreeHead, error)
+}
+
+// The queryInterval is used as the client's poll delay, so that
+// waiting for a log that doesn't support waiting makes at most one
+// get-tree-head request per interval, and the resulting tree head
+// is used by the monitor without another request. If the log has mirrors,
+// requests for proofs and leaves are distributed over the log's url
+// and all mirrors, see client.MultiClient.
+func newMonitoringLogClient(l *policy.Entity, policy *policy.Policy, queryInterval time.Duration) *monitoringLogClient {
+	var cli api.LogReader
+	if len(l.MirrorURLs) > 0 {
+		cli = client.NewMulti(client.MultiConfig{URLs: []string{l.URL}, MirrorURLs: l.MirrorURLs,
+			UserAgent: "sigsum-monitor", PollDelay: queryInterval})
+	} else {
+		cli = client.New(client.Config{URL: l.URL, UserAgent: "sigsum-monitor", PollDelay: queryInterval})
+	}
 	return &monitoringLogClient{
-		logKey: *logKey,
-		client: client.New(client.Config{URL: URL, UserAgent: "sigsum-monitor"}),
+		logKey: l.PublicKey,
+		client: cli,
+		policy: policy,
 	}
 }
 
+// Waits until the context expires, or, if supported by the client,
+// until the log has a tree head larger than size. In the latter case,
+// returns the new tree head, which must be checked using
+// checkTreeHead, and true.
+func (c *monitoringLogClient) waitForTreeHead(ctx context.Context, size uint64) (types.CosignedTreeHead, bool) {
+	if w, ok := c.client.(treeHeadWaiter); ok {
+		if cth, err := w.WaitTreeHead(ctx, requests.TreeHead{OldSize: size}); err == nil {
+			return cth, true
+		}
+	}
+	<-ctx.Done()
+	return types.CosignedTreeHead{}, false
+}
+
 // Request log's tree head, and check that it is consistent with local
-// state. TODO: Figure out cosignatures should be processed; it would
-// make some sense to return a CosignedTreeHead but where only
-// properly verified cosignatures are kept.
-func (c *monitoringLogClient) getTreeHead(ctx context.Context, treeHead *types.TreeHead) (types.SignedTreeHead, error) {
+// state. Cosignatures are not checked, see verifyCosignatures.
+func (c *monitoringLogClient) getTreeHead(ctx context.Context, treeHead *types.TreeHead) (types.CosignedTreeHead, error) {
 	cth, err := c.client.GetTreeHead(ctx)
 	if err != nil {
-		return types.SignedTreeHead{}, newAlert(AlertLogError, "get-tree-head failed: %v", err)
+		return types.CosignedTreeHead{}, newAlert(AlertLogError, "get-tree-head failed: %v", err)
 	}
-	// For now, only check log's signature. TODO: Also check cosignatures.
+	return c.checkTreeHead(ctx, &cth, treeHead)
+}
+
+// Check that a tree head retrieved from the log is consistent with
+// local state.
+func (c *monitoringLogClient) checkTreeHead(ctx context.Context, cth *types.CosignedTreeHead, treeHead *types.TreeHead) (types.CosignedTreeHead, error) {
 	if !cth.Verify(&c.logKey) {
-		return types.SignedTreeHead{}, newAlert(AlertInvalidLogSignature, "log signature invalid")
+		return types.CosignedTreeHead{}, newAlert(AlertInvalidLogSignature, "log signature invalid")
 	}
 	if cth.Size < treeHead.Size {
-		return types.SignedTreeHead{}, newAlert(AlertInconsistentTreeHead, "monitored log has shrunk, size %d, previous size %d", cth.Size, treeHead.Size)
+		return types.CosignedTreeHead{}, newAlert(AlertInconsistentTreeHead, "monitored log has shrunk, size %d, previous size %d", cth.Size, treeHead.Size)
 	}
 	proof, err := c.client.GetConsistencyProof(ctx, requests.ConsistencyProof{OldSize: treeHead.Size, NewSize: cth.Size})
 	if err != nil {
-		return types.SignedTreeHead{}, newAlert(AlertLogError, "get-consistency-proof failed: %v", err)
+		return types.CosignedTreeHead{}, newAlert(AlertLogError, "get-consistency-proof failed: %v", err)
 	}
 	if err := proof.Verify(treeHead, &cth.TreeHead); err != nil {
-		return types.SignedTreeHead{}, newAlert(AlertInconsistentTreeHead, "consistency proof not valid: %v", err)
+		return types.CosignedTreeHead{}, newAlert(AlertInconsistentTreeHead, "consistency proof not valid: %v", err)
+	}
+	return *cth, nil
+}
+
+// Verifies the cosignatures on a tree head, and returns a tree head
+// including only verified cosignatures. Invalid cosignatures, and
+// witnesses that cosigned the previous tree head but not this one,
+// are reported via the alert function. If the verified cosignatures
+// don't satisfy the policy's quorum, an alert is returned as error.
+func (c *monitoringLogClient) verifyCosignatures(cth *types.CosignedTreeHead, alert func(*Alert)) (types.CosignedTreeHead, error) {
+	if c.policy == nil {
+		return *cth, nil
 	}
-	return cth.SignedTreeHead, nil
+	cosignatures, failed := c.policy.VerifyCosignatures(&c.logKey, cth)
+	invalid := make(map[crypto.Hash]struct{})
+	for _, keyHash := range failed {
+		alert(newAlert(AlertInvalidCosignature, "invalid cosignature by witness %x, tree size %d", keyHash, cth.Size))
+		invalid[keyHash] = struct{}{}
+	}
+	verified := make(map[crypto.Hash]struct{})
+	for keyHash := range cosignatures {
+		verified[keyHash] = struct{}{}
+	}
+	for keyHash := range c.cosigning {
+		_, ok := verified[keyHash]
+		if _, bad := invalid[keyHash]; !ok && !bad {
+			alert(newAlert(AlertWitnessStoppedCosigning, "no cosignature by witness %x, tree size %d", keyHash, cth.Size))
+		}
+	}
+	c.cosigning = verified
+	if !c.policy.IsQuorum(verified) {
+		return types.CosignedTreeHead{}, newAlert(AlertNoWitnessQuorum,
+			"cosignatures don't satisfy policy quorum, tree size %d, verified: %d, failed to verify: %d",
+			cth.Size, len(verified), len(failed))
+	}
+	return types.CosignedTreeHead{SignedTreeHead: cth.SignedTreeHead, Cosignatures: cosignatures}, nil
+}
+
+// Checks if the leaf is included in the tree, using an inclusion
+// proof. Any failure to get a valid proof is treated as the leaf not
+// being included.
+func (c *monitoringLogClient) isIncluded(ctx context.Context, leafHash *crypto.Hash, treeHead *types.TreeHead) bool {
+	if treeHead.Size == 0 {
+		return false
+	}
+	proof, err := c.client.GetInclusionProof(ctx, requests.InclusionProof{Size: treeHead.Size, LeafHash: *leafHash})
+	return err == nil && proof.Verify(leafHash, treeHead) == nil
 }
 
 func (c *monitoringLogClient) getInclusionProofAtIndex(ctx context.Context,
@@ -65,72 +169,169 @@ func (c *monitoringLogClient) getInclusionProofAtIndex(ctx context.Context,
 	return proof, nil
 }
 
-// Caches previous leaf hash and inclusion proof. Valid only for
-// retrieving the next range starting at LeafIndex + 1, and with the
-// same tree head.
+// Caches the leaf hash and inclusion proof for the last leaf of the
+// previous range. Valid only for verifying the next range starting at
+// LeafIndex + 1, and with the same tree head.
 type getLeavesState struct {
 	leafHash crypto.Hash
 	proof    types.InclusionProof
 }
 
-// Retrieves at most count leaves, starting at index, and check that
-// they are included in the latest retrieved tree head.
-func (c *monitoringLogClient) getLeaves(ctx context.Context, state *getLeavesState, treeHead *types.TreeHead, req requests.Leaves) ([]types.Leaf, *getLeavesState, error) {
-	leaves, err := c.client.GetLeaves(ctx, req)
-	if err != nil {
-		return nil, nil, err
+// A range of leaves retrieved from the log, not yet verified.
+type leafRange struct {
+	start  uint64
+	leaves []types.Leaf
+	// Inclusion proof for the last leaf, or nil if the range
+	// extends to the end of the tree.
+	endProof *types.InclusionProof
+	// Proof for the complete range, if supported by the log. If
+	// set, endProof is not used.
+	rangeProof *types.RangeProof
+}
+
+// Retrieves the leaves start <= index < end, using as many get-leaves
+// requests as needed, and a range proof, if supported by the log.
+// Otherwise, retrieves the inclusion proof for the last leaf, if end
+// is less than the tree size. The inclusion proof for the first leaf
+// is not retrieved, since normally the end proof of the previous
+// range can be used instead, see verifyRange.
+func (c *monitoringLogClient) fetchRange(ctx context.Context, treeHead *types.TreeHead, start, end uint64) (*leafRange, error) {
+	r := leafRange{start: start, leaves: make([]types.Leaf, 0, end-start)}
+	for next := start; next < end; next = start + uint64(len(r.leaves)) {
+		leaves, err := c.client.GetLeaves(ctx, requests.Leaves{StartIndex: next, EndIndex: end})
+		if err != nil {
+			return nil, newAlert(AlertLogError, "get-leaves failed: %v", err)
+		}
+		if len(leaves) == 0 || uint64(len(leaves)) > end-next {
+			return nil, newAlert(AlertLogError, "unexpected number of leaves from get-leaves, got %d, requested %d",
+				len(leaves), end-next)
+		}
+		if uint64(len(leaves)) < end-next {
+			c.setLeafLimit(uint64(len(leaves)))
+		}
+		r.leaves = append(r.leaves, leaves...)
 	}
+	if prover, ok := c.client.(api.RangeProver); ok && time.Now().UnixNano() >= c.noRangeProofsUntil.Load() {
+		proof, err := prover.GetRangeProof(ctx, requests.RangeProof{StartIndex: start, EndIndex: end, Size: treeHead.Size})
+		if err == nil {
+			r.rangeProof = &proof
+			return &r, nil
+		}
+		if errors.Is(err, api.ErrNotFound) {
+			// The log doesn't support range proofs, use
+			// inclusion proofs for a while.
+			c.noRangeProofsUntil.Store(time.Now().Add(rangeProofProbeInterval).UnixNano())
+		} else {
+			// Possibly a transient failure, fall back to
+			// inclusion proofs for this range only.
+			log.Info("get-range-proof failed, using inclusion proofs: %v", err)
+		}
+	}
+	if end < treeHead.Size {
+		leafHash := r.leaves[len(r.leaves)-1].ToHash()
+		proof, err := c.getInclusionProofAtIndex(ctx, end-1,
+			requests.InclusionProof{Size: treeHead.Size, LeafHash: leafHash})
+		if err != nil {
+			return nil, err
+		}
+		r.endProof = &proof
+	}
+	return &r, nil
+}
 
-	start := req.StartIndex
-	end := req.StartIndex + uint64(len(leaves))
+// Records that the log returned only limit leaves for a larger
+// request.
+func (c *monitoringLogClient) setLeafLimit(limit uint64) {
+	for {
+		old := c.leafLimit.Load()
+		if old > 0 && old <= limit {
+			return
+		}
+		if c.leafLimit.CompareAndSwap(old, limit) {
+			return
+		}
+	}
+}
 
-	leafHashes := make([]crypto.Hash, 0, len(leaves)+1)
+// Returns the batch size to use, considering the number of leaves the
+// log has been observed to return per request.
+func (c *monitoringLogClient) batchSize(configured uint64) uint64 {
+	if limit := c.leafLimit.Load(); limit > 0 && limit < configured {
+		return limit
+	}
+	return configured
+}
+
+// Checks that the leaves of a range are included in the tree head.
+// The state, if non-nil, must correspond to the leaf just before the
+// range, and it is used in place of an inclusion proof for the first
+// leaf. Returns the state for verifying the next range, or nil if
+// the range extends to the end of the tree, or was verified using a
+// range proof.
+func (c *monitoringLogClient) verifyRange(ctx context.Context, state *getLeavesState, treeHead *types.TreeHead, r *leafRange) (*getLeavesState, error) {
+	start := r.start
+	end := r.start + uint64(len(r.leaves))
+
+	if r.rangeProof != nil {
+		leafHashes := make([]crypto.Hash, 0, len(r.leaves))
+		for _, leaf := range r.leaves {
+			leafHashes = append(leafHashes, leaf.ToHash())
+		}
+		if err := r.rangeProof.Verify(leafHashes, start, treeHead); err != nil {
+			return nil, newAlert(AlertLogError, "range proof not valid for range %d:%d: %v", start, end, err)
+		}
+		return nil, nil
+	}
+
+	leafHashes := make([]crypto.Hash, 0, len(r.leaves)+1)
 	var proof types.InclusionProof
 
 	if state != nil {
-		if state.proof.LeafIndex+1 != req.StartIndex {
+		if state.proof.LeafIndex+1 != r.start {
 			panic(fmt.Sprintf("invalid state, LeafIndex (%d), StartIndex (%d) should be adjacent",
-				state.proof.LeafIndex, req.StartIndex))
+				state.proof.LeafIndex, r.start))
 		}
 		start = state.proof.LeafIndex
 		proof = state.proof
 		leafHashes = append(leafHashes, state.leafHash)
 	}
-	for _, leaf := range leaves {
+	for _, leaf := range r.leaves {
 		leafHashes = append(leafHashes, leaf.ToHash())
 	}
 	if state == nil {
-		var err error
-		proof, err = c.getInclusionProofAtIndex(ctx, start,
-			requests.InclusionProof{Size: treeHead.Size, LeafHash: leafHashes[0]})
-		if err != nil {
-			return nil, nil, err
+		if len(leafHashes) == 1 && r.endProof != nil {
+			proof = *r.endProof
+		} else {
+			var err error
+			proof, err = c.getInclusionProofAtIndex(ctx, start,
+				requests.InclusionProof{Size: treeHead.Size, LeafHash: leafHashes[0]})
+			if err != nil {
+				return nil, err
+			}
 		}
 	}
 
-	if len(leaves) == 1 {
+	if len(leafHashes) == 1 {
 		if err := proof.Verify(&leafHashes[0], treeHead); err != nil {
-			return nil, nil, newAlert(AlertLogError, "inclusion proof for leaf %d not valid", proof.LeafIndex)
+			return nil, newAlert(AlertLogError, "inclusion proof for leaf %d not valid", proof.LeafIndex)
 		}
-		return leaves, &getLeavesState{leafHash: leafHashes[0], proof: proof}, nil
+		if r.endProof == nil {
+			return nil, nil
+		}
+		return &getLeavesState{leafHash: leafHashes[0], proof: proof}, nil
 	}
 
-	if end == treeHead.Size {
+	if r.endProof == nil {
 		if err := merkle.VerifyInclusionTail(leafHashes, start, &treeHead.RootHash, proof.Path); err != nil {
-			return nil, nil, newAlert(AlertLogError, "inclusion proof not valid for tail range %d:%d: %v",
+			return nil, newAlert(AlertLogError, "inclusion proof not valid for tail range %d:%d: %v",
 				start, end, err)
 		}
-		return leaves, nil, nil
+		return nil, nil
 	}
 
-	endProof, err := c.getInclusionProofAtIndex(ctx, end-1,
-		requests.InclusionProof{Size: treeHead.Size, LeafHash: leafHashes[len(leafHashes)-1]})
-	if err != nil {
-		return nil, nil, err
-	}
-	if err := merkle.VerifyInclusionBatch(leafHashes, start, treeHead.Size, &treeHead.RootHash, proof.Path, endProof.Path); err != nil {
-		return nil, nil, newAlert(AlertLogError, "inclusion proof not valid for range %d:%d: %v", start, end, err)
+	if err := merkle.VerifyInclusionBatch(leafHashes, start, treeHead.Size, &treeHead.RootHash, proof.Path, r.endProof.Path); err != nil {
+		return nil, newAlert(AlertLogError, "inclusion proof not valid for range %d:%d: %v", start, end, err)
 	}
 
-	return leaves, &getLeavesState{leafHash: leafHashes[len(leafHashes)-1], proof: endProof}, nil
+	return &getLeavesState{leafHash: leafHashes[len(leafHashes)-1], proof: *r.endProof}, nil
 }
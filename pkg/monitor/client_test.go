@@ -5,15 +5,21 @@ import (
 	"encoding/binary"
 	"fmt"
 	"math/rand"
+	"net/http/httptest"
+	"slices"
 	"testing"
+	"time"
 
 	"github.com/golang/mock/gomock"
 
 	"sigsum.org/sigsum-go/pkg/api"
 	"sigsum.org/sigsum-go/pkg/crypto"
+	"sigsum.org/sigsum-go/pkg/logserver"
 	"sigsum.org/sigsum-go/pkg/merkle"
 	"sigsum.org/sigsum-go/pkg/mocks"
+	"sigsum.org/sigsum-go/pkg/policy"
 	"sigsum.org/sigsum-go/pkg/requests"
+	"sigsum.org/sigsum-go/pkg/server"
 	token "sigsum.org/sigsum-go/pkg/submit-token"
 	"sigsum.org/sigsum-go/pkg/types"
 )
@@ -181,6 +187,48 @@ func TestGetTreeHeadErrors(t *testing.T) {
 	oneTest("bad consistency", nil, nil)
 }
 
+// A mirror that lags behind the log must not be mistaken for the log
+// shrinking.
+func TestGetTreeHeadLaggingMirror(t *testing.T) {
+	ctx := context.Background()
+	logSigner := crypto.NewEd25519Signer(&crypto.PrivateKey{2})
+	leafSigner := crypto.NewEd25519Signer(&crypto.PrivateKey{3})
+	newLog := func(n int) *httptest.Server {
+		l, err := logserver.New(&logserver.Config{Signer: logSigner})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { l.Close() })
+		for i := 0; i < n; i++ {
+			if _, err := l.AddLeaf(ctx, makeLeafRequest(t, leafSigner, &crypto.Hash{byte(i)}), nil); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := l.Publish(ctx); err != nil {
+			t.Fatal(err)
+		}
+		s := httptest.NewServer(server.NewLog(&server.Config{}, l))
+		t.Cleanup(s.Close)
+		return s
+	}
+	primary, mirror := newLog(10), newLog(5)
+
+	monitorClient := newMonitoringLogClient(&policy.Entity{
+		PublicKey: logSigner.Public(), URL: primary.URL, MirrorURLs: []string{mirror.URL},
+	}, nil, time.Second)
+	prevTree := types.NewEmptyTreeHead()
+	for i := 0; i < 4; i++ {
+		cth, err := monitorClient.getTreeHead(ctx, &prevTree)
+		if err != nil {
+			t.Fatalf("getTreeHead failed: %v", err)
+		}
+		if cth.Size != 10 {
+			t.Errorf("unexpected tree size %d, want 10", cth.Size)
+		}
+		prevTree = cth.TreeHead
+	}
+}
+
 func addLeaves(t *testing.T, log *testLog, signer crypto.Signer, id, count uint64) {
 	oldSize := log.tree.Size()
 	for j := uint64(0); j < count; j++ {
@@ -196,3 +244,113 @@ func addLeaves(t *testing.T, log *testLog, signer crypto.Signer, id, count uint6
 		t.Fatalf("Unexpected merkle tree size: got %d, want %d", got, want)
 	}
 }
+
+func TestWaitForTreeHead(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockLog := mocks.NewMockLog(ctrl)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	mockLog.EXPECT().WaitTreeHead(gomock.Any(), requests.TreeHead{OldSize: 5}).Return(
+		types.CosignedTreeHead{SignedTreeHead: types.SignedTreeHead{TreeHead: types.TreeHead{Size: 6}}}, nil)
+	cth, ok := (&monitoringLogClient{client: mockLog}).waitForTreeHead(ctx, 5)
+	if ctx.Err() != nil {
+		t.Errorf("waiting didn't return before end of interval")
+	}
+	if !ok || cth.Size != 6 {
+		t.Errorf("unexpected waiting result, size %d, ok %v", cth.Size, ok)
+	}
+
+	// A failing wait, or a client that doesn't support waiting,
+	// waits until end of interval.
+	mockLog.EXPECT().WaitTreeHead(gomock.Any(), gomock.Any()).Return(
+		types.CosignedTreeHead{}, fmt.Errorf("mock error"))
+	for _, client := range []api.LogReader{mockLog, &testLog{}} {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		if _, ok := (&monitoringLogClient{client: client}).waitForTreeHead(ctx, 5); ok {
+			t.Errorf("unexpected tree head from failed wait")
+		}
+		if ctx.Err() == nil {
+			t.Errorf("waiting returned before end of interval")
+		}
+		cancel()
+	}
+}
+
+func TestVerifyCosignatures(t *testing.T) {
+	logSigner := crypto.NewEd25519Signer(&crypto.PrivateKey{2})
+	logKey := logSigner.Public()
+	witnessSigners := []crypto.Signer{
+		crypto.NewEd25519Signer(&crypto.PrivateKey{4}),
+		crypto.NewEd25519Signer(&crypto.PrivateKey{5}),
+		crypto.NewEd25519Signer(&crypto.PrivateKey{6}),
+	}
+	var witnessKeys []crypto.PublicKey
+	for _, s := range witnessSigners {
+		witnessKeys = append(witnessKeys, s.Public())
+	}
+	// Third witness is not part of the policy.
+	p, err := policy.NewKofNPolicy([]crypto.PublicKey{logKey}, witnessKeys[:2], 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	monitorClient := monitoringLogClient{logKey: logKey, policy: p}
+
+	th := types.TreeHead{Size: 3}
+	sth, err := th.Sign(logSigner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Returns a tree head cosigned by the given witnesses; a
+	// negative index means an invalid cosignature.
+	cosign := func(witnesses ...int) *types.CosignedTreeHead {
+		cth := types.CosignedTreeHead{SignedTreeHead: sth, Cosignatures: make(map[crypto.Hash]types.Cosignature)}
+		for _, w := range witnesses {
+			i := w
+			if i < 0 {
+				i = -i - 1
+			}
+			cs, err := th.Cosign(witnessSigners[i], types.SigsumCheckpointOrigin(&logKey), 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if w < 0 {
+				cs.Timestamp++
+			}
+			cth.Cosignatures[crypto.HashBytes(witnessKeys[i][:])] = cs
+		}
+		return &cth
+	}
+	for _, table := range []struct {
+		desc       string
+		cth        *types.CosignedTreeHead
+		wantCount  int
+		wantAlerts []AlertType
+		wantErr    bool
+	}{
+		{"all witnesses", cosign(0, 1, 2), 2, nil, false},
+		{"stopped", cosign(0), 1, []AlertType{AlertWitnessStoppedCosigning}, false},
+		{"resumed", cosign(0, 1), 2, nil, false},
+		{"invalid", cosign(0, -2), 1, []AlertType{AlertInvalidCosignature}, false},
+		{"no quorum", cosign(), 0, []AlertType{AlertWitnessStoppedCosigning}, true},
+		{"no quorum, invalid", cosign(-1), 0, []AlertType{AlertInvalidCosignature}, true},
+	} {
+		var alerts []AlertType
+		cth, err := monitorClient.verifyCosignatures(table.cth, func(alert *Alert) {
+			alerts = append(alerts, alert.Type)
+		})
+		if table.wantErr {
+			if alert, ok := err.(*Alert); !ok || alert.Type != AlertNoWitnessQuorum {
+				t.Errorf("%s: unexpected error: %v", table.desc, err)
+			}
+		} else if err != nil {
+			t.Errorf("%s: failed: %v", table.desc, err)
+		} else if got, want := len(cth.Cosignatures), table.wantCount; got != want {
+			t.Errorf("%s: unexpected number of cosignatures, got %d, want %d", table.desc, got, want)
+		}
+		if !slices.Equal(alerts, table.wantAlerts) {
+			t.Errorf("%s: unexpected alerts, got %v, want %v", table.desc, alerts, table.wantAlerts)
+		}
+	}
+}
@@ -0,0 +1,238 @@
+package monitor
+
+import (
+	"context"
+	"sync"
+
+	"sigsum.org/sigsum-go/pkg/crypto"
+	"sigsum.org/sigsum-go/pkg/policy"
+	"sigsum.org/sigsum-go/pkg/types"
+)
+
+const DefaultMaxUnackedEvents = 8
+
+// An Event is produced by the monitor for a single log. Events for
+// the same log are delivered in order. The application must call Ack
+// when it is done processing an event, e.g., after persisting the
+// result of State(). Acknowledgements are cumulative: acknowledging
+// an event implies acknowledgement of all earlier events for the same
+// log.
+type Event interface {
+	LogKeyHash() crypto.Hash
+	// The monitor state for the log, after the event. Resuming
+	// monitoring from this state doesn't repeat the event.
+	State() MonitorState
+	Ack()
+}
+
+// Fields and methods common to all event types.
+type eventBase struct {
+	log   *eventLog
+	seq   uint64
+	state MonitorState
+}
+
+func (e *eventBase) LogKeyHash() crypto.Hash { return e.log.keyHash }
+func (e *eventBase) State() MonitorState     { return e.state }
+func (e *eventBase) Ack()                    { e.log.ack(e.seq, &e.state) }
+
+// A new tree head was accepted, see Callbacks.NewTreeHead.
+type TreeHeadEvent struct {
+	eventBase
+	CosignedTreeHead types.CosignedTreeHead
+}
+
+// A batch of leaves including at least one leaf of interest, see
+// Callbacks.NewLeaves. State().NextLeafIndex is the number of leaves
+// processed.
+type LeavesEvent struct {
+	eventBase
+	Indices []uint64
+	Leaves  []types.Leaf
+}
+
+// A batch of leaves was processed, without any leaves of interest.
+type ProgressEvent struct {
+	eventBase
+}
+
+// A problem with the log was detected. Err is usually an *Alert.
+type AlertEvent struct {
+	eventBase
+	Err error
+}
+
+// Per-log state of an event stream.
+type eventLog struct {
+	keyHash crypto.Hash
+
+	// Monitor state reflecting all events sent so far. Only
+	// accessed from the log's monitoring goroutine.
+	state MonitorState
+
+	// Protects the below fields.
+	m          sync.Mutex
+	sent       uint64
+	acked      uint64
+	ackedState MonitorState
+	// Signalled on acknowledgement.
+	ackCh chan struct{}
+}
+
+func (l *eventLog) ack(seq uint64, state *MonitorState) {
+	l.m.Lock()
+	defer l.m.Unlock()
+	if seq <= l.acked {
+		return
+	}
+	l.acked = seq
+	l.ackedState = *state
+	select {
+	case l.ackCh <- struct{}{}:
+	default:
+	}
+}
+
+// Waits until the number of unacknowledged events is below the
+// limit, and allocates a sequence number for the next event. Returns
+// false if ctx is cancelled.
+func (l *eventLog) next(ctx context.Context, maxUnacked uint64) (uint64, bool) {
+	for {
+		l.m.Lock()
+		if l.sent-l.acked < maxUnacked {
+			l.sent++
+			seq := l.sent
+			l.m.Unlock()
+			return seq, true
+		}
+		l.m.Unlock()
+		select {
+		case <-ctx.Done():
+			return 0, false
+		case <-l.ackCh:
+		}
+	}
+}
+
+// An EventStream delivers monitoring events for all logs on a single
+// channel, as an alternative to the Callbacks interface. A consumer
+// that is slow to receive or acknowledge events holds up monitoring
+// of the corresponding log, but doesn't lose any events.
+type EventStream struct {
+	ctx        context.Context
+	maxUnacked uint64
+	events     chan Event
+	logs       map[crypto.Hash]*eventLog
+}
+
+// Like StartMonitoring, but delivers events on the returned stream,
+// instead of calling config.Callbacks (which is ignored). For each
+// log, at most config.MaxUnackedEvents events are outstanding without
+// acknowledgement; the monitoring of that log is paused until the
+// application acknowledges older events.
+func StartEventMonitoring(ctx context.Context, p *policy.Policy, config *Config,
+	state map[crypto.Hash]MonitorState) *EventStream {
+	initialState := make(map[crypto.Hash]MonitorState)
+	for _, l := range p.GetLogsWithUrl() {
+		keyHash := crypto.HashBytes(l.PublicKey[:])
+		logState, ok := state[keyHash]
+		if !ok {
+			logState = MonitorState{TreeHead: types.NewEmptyTreeHead()}
+		}
+		initialState[keyHash] = logState
+	}
+	s := newEventStream(ctx, config.MaxUnackedEvents, initialState)
+	c := *config
+	c.Callbacks = s
+	done := StartMonitoring(ctx, p, &c, initialState)
+	go func() {
+		<-done
+		close(s.events)
+	}()
+	return s
+}
+
+// Creates a stream for the logs with the given initial state.
+func newEventStream(ctx context.Context, maxUnacked int, state map[crypto.Hash]MonitorState) *EventStream {
+	if maxUnacked <= 0 {
+		maxUnacked = DefaultMaxUnackedEvents
+	}
+	s := &EventStream{
+		ctx:        ctx,
+		maxUnacked: uint64(maxUnacked),
+		events:     make(chan Event),
+		logs:       make(map[crypto.Hash]*eventLog),
+	}
+	for keyHash, logState := range state {
+		s.logs[keyHash] = &eventLog{
+			keyHash:    keyHash,
+			state:      logState,
+			ackedState: logState,
+			ackCh:      make(chan struct{}, 1),
+		}
+	}
+	return s
+}
+
+// Returns the channel of events. The channel is closed when
+// monitoring has stopped, after the context passed to
+// StartEventMonitoring is cancelled.
+func (s *EventStream) Events() <-chan Event {
+	return s.events
+}
+
+// Returns the monitor state as of the latest acknowledged event for
+// each log. After monitoring has stopped, this can be passed to
+// StartEventMonitoring to resume without repeating or skipping any
+// acknowledged events.
+func (s *EventStream) AckedState() map[crypto.Hash]MonitorState {
+	state := make(map[crypto.Hash]MonitorState)
+	for keyHash, l := range s.logs {
+		l.m.Lock()
+		state[keyHash] = l.ackedState
+		l.m.Unlock()
+	}
+	return state
+}
+
+// Sends an event, blocking until it is received. The event's base is
+// filled in from the log's current state.
+func (s *EventStream) send(l *eventLog, base *eventBase, e Event) {
+	seq, ok := l.next(s.ctx, s.maxUnacked)
+	if !ok {
+		return
+	}
+	*base = eventBase{log: l, seq: seq, state: l.state}
+	select {
+	case <-s.ctx.Done():
+	case s.events <- e:
+	}
+}
+
+// Callbacks implementation, invoked from the per-log monitoring
+// goroutines.
+
+func (s *EventStream) NewTreeHead(logKeyHash crypto.Hash, cosignedTreeHead types.CosignedTreeHead) {
+	l := s.logs[logKeyHash]
+	l.state.TreeHead = cosignedTreeHead.TreeHead
+	e := &TreeHeadEvent{CosignedTreeHead: cosignedTreeHead}
+	s.send(l, &e.eventBase, e)
+}
+
+func (s *EventStream) NewLeaves(logKeyHash crypto.Hash, numberOfProcessedLeaves uint64, indices []uint64, leaves []types.Leaf) {
+	l := s.logs[logKeyHash]
+	l.state.NextLeafIndex = numberOfProcessedLeaves
+	if len(leaves) == 0 {
+		e := &ProgressEvent{}
+		s.send(l, &e.eventBase, e)
+		return
+	}
+	e := &LeavesEvent{Indices: indices, Leaves: leaves}
+	s.send(l, &e.eventBase, e)
+}
+
+func (s *EventStream) Alert(logKeyHash crypto.Hash, err error) {
+	l := s.logs[logKeyHash]
+	e := &AlertEvent{Err: err}
+	s.send(l, &e.eventBase, e)
+}
@@ -0,0 +1,113 @@
+package monitor
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"sigsum.org/sigsum-go/pkg/crypto"
+	"sigsum.org/sigsum-go/pkg/types"
+)
+
+func receiveEvent(t *testing.T, s *EventStream) Event {
+	t.Helper()
+	select {
+	case e := <-s.Events():
+		return e
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timeout waiting for event")
+		return nil
+	}
+}
+
+func TestEventStream(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	logKeyHash := crypto.Hash{1}
+	s := newEventStream(ctx, 2, map[crypto.Hash]MonitorState{
+		logKeyHash: MonitorState{TreeHead: types.NewEmptyTreeHead()},
+	})
+	th := types.TreeHead{Size: 3, RootHash: crypto.Hash{2}}
+	leaf := types.Leaf{Checksum: crypto.Hash{3}}
+
+	// Mimic the calls made by MonitorLog.
+	done := make(chan struct{})
+	go func() {
+		s.NewTreeHead(logKeyHash, types.CosignedTreeHead{SignedTreeHead: types.SignedTreeHead{TreeHead: th}})
+		s.NewLeaves(logKeyHash, 2, nil, nil)
+		s.Alert(logKeyHash, newAlert(AlertLogError, "oops"))
+		s.NewLeaves(logKeyHash, 3, []uint64{2}, []types.Leaf{leaf})
+		close(done)
+	}()
+
+	e := receiveEvent(t, s)
+	if the, ok := e.(*TreeHeadEvent); !ok || the.CosignedTreeHead.TreeHead != th {
+		t.Fatalf("unexpected event %#v, expected tree head", e)
+	}
+	if got, want := e.State(), (MonitorState{TreeHead: th}); got != want {
+		t.Errorf("unexpected state, got %v, want %v", got, want)
+	}
+	if e.LogKeyHash() != logKeyHash {
+		t.Errorf("unexpected log key hash %x", e.LogKeyHash())
+	}
+	progress := receiveEvent(t, s)
+	if _, ok := progress.(*ProgressEvent); !ok {
+		t.Fatalf("unexpected event %#v, expected progress", progress)
+	}
+
+	// With two unacknowledged events, the monitor must wait.
+	select {
+	case e := <-s.Events():
+		t.Fatalf("unexpected event before ack: %#v", e)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if got, want := s.AckedState()[logKeyHash].TreeHead.Size, uint64(0); got != want {
+		t.Errorf("unexpected acked size, got %d, want %d", got, want)
+	}
+	// Cumulative ack.
+	progress.Ack()
+	if got, want := s.AckedState()[logKeyHash], (MonitorState{TreeHead: th, NextLeafIndex: 2}); got != want {
+		t.Errorf("unexpected acked state, got %v, want %v", got, want)
+	}
+	// Ack of older event has no effect.
+	e.Ack()
+	if got := s.AckedState()[logKeyHash].NextLeafIndex; got != 2 {
+		t.Errorf("old ack changed state, got index %d", got)
+	}
+
+	e = receiveEvent(t, s)
+	if alert, ok := e.(*AlertEvent); !ok || alert.Err.(*Alert).Type != AlertLogError {
+		t.Fatalf("unexpected event %#v, expected alert", e)
+	}
+	e = receiveEvent(t, s)
+	if leaves, ok := e.(*LeavesEvent); !ok || len(leaves.Leaves) != 1 || leaves.Indices[0] != 2 {
+		t.Fatalf("unexpected event %#v, expected leaves", e)
+	}
+	<-done
+	e.Ack()
+	if got, want := s.AckedState()[logKeyHash], (MonitorState{TreeHead: th, NextLeafIndex: 3}); got != want {
+		t.Errorf("unexpected acked state, got %v, want %v", got, want)
+	}
+}
+
+func TestEventStreamCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	logKeyHash := crypto.Hash{1}
+	s := newEventStream(ctx, 1, map[crypto.Hash]MonitorState{
+		logKeyHash: MonitorState{TreeHead: types.NewEmptyTreeHead()},
+	})
+	done := make(chan struct{})
+	go func() {
+		// Nobody receives; must not block after cancel.
+		s.Alert(logKeyHash, newAlert(AlertLogError, "oops"))
+		s.Alert(logKeyHash, newAlert(AlertLogError, "oops"))
+		close(done)
+	}()
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("event stream blocked after cancel")
+	}
+}
@@ -0,0 +1,124 @@
+package monitor
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"sigsum.org/sigsum-go/pkg/crypto"
+	"sigsum.org/sigsum-go/pkg/policy"
+	"sigsum.org/sigsum-go/pkg/types"
+)
+
+// Returns the timestamp of the newest set of cosignatures that
+// satisfies the policy's quorum, i.e., the largest timestamp t such
+// that the cosignatures with timestamp at least t form a quorum.
+// Returns false if the quorum is satisfied without any cosignatures,
+// or not satisfied at all.
+func quorumTimestamp(p *policy.Policy, cosignatures map[crypto.Hash]types.Cosignature) (uint64, bool) {
+	if p.IsQuorum(map[crypto.Hash]struct{}{}) {
+		return 0, false
+	}
+	keyHashes := make([]crypto.Hash, 0, len(cosignatures))
+	for keyHash := range cosignatures {
+		keyHashes = append(keyHashes, keyHash)
+	}
+	sort.Slice(keyHashes, func(i, j int) bool {
+		return cosignatures[keyHashes[i]].Timestamp > cosignatures[keyHashes[j]].Timestamp
+	})
+	witnesses := make(map[crypto.Hash]struct{})
+	for _, keyHash := range keyHashes {
+		witnesses[keyHash] = struct{}{}
+		if p.IsQuorum(witnesses) {
+			return cosignatures[keyHash].Timestamp, true
+		}
+	}
+	return 0, false
+}
+
+// Per-log state of the freshness checks, so that a problem is
+// alerted when it is first detected, rather than on every query
+// interval.
+type freshnessState struct {
+	// Set while the newest cosignatures satisfying the quorum
+	// are too old.
+	staleCosignatures bool
+	// Tree size and number of overdue leaves at the latest
+	// AlertLogNotAdvancing alert, zero when there are no overdue
+	// leaves.
+	overdueSize  uint64
+	overdueCount int
+	// Overdue leaves found not to be included, mapped to the
+	// tree size of the inclusion proof lookup, so that each leaf
+	// is looked up at most once per tree head.
+	notIncluded map[crypto.Hash]uint64
+}
+
+// Submissions records leaves that logs have accepted, e.g., an
+// add-leaf request that got a 202 Accepted response, but which the
+// monitor hasn't yet seen in the log. A log that doesn't include an
+// accepted leaf in its tree within reasonable time may be frozen, or
+// withholding a view of the log from the monitor. A leaf may also be
+// recorded after the monitor has processed it, e.g., when pending
+// submissions are read periodically; such leaves are found using an
+// inclusion proof before any alert is raised. Safe for concurrent
+// use.
+type Submissions struct {
+	m sync.Mutex
+	// Maps log key hash and leaf hash to the time the
+	// submission was accepted.
+	pending map[crypto.Hash]map[crypto.Hash]time.Time
+}
+
+func NewSubmissions() *Submissions {
+	return &Submissions{pending: make(map[crypto.Hash]map[crypto.Hash]time.Time)}
+}
+
+// Records a leaf accepted by the log.
+func (s *Submissions) Add(logKeyHash, leafHash crypto.Hash, accepted time.Time) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	leaves, ok := s.pending[logKeyHash]
+	if !ok {
+		leaves = make(map[crypto.Hash]time.Time)
+		s.pending[logKeyHash] = leaves
+	}
+	if t, ok := leaves[leafHash]; !ok || accepted.Before(t) {
+		leaves[leafHash] = accepted
+	}
+}
+
+// Removes leaves that have been seen in the log.
+func (s *Submissions) remove(logKeyHash crypto.Hash, leaves []types.Leaf) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	pending := s.pending[logKeyHash]
+	if len(pending) == 0 {
+		return
+	}
+	for _, leaf := range leaves {
+		delete(pending, leaf.ToHash())
+	}
+}
+
+// Removes a single leaf, e.g., one found to be included using an
+// inclusion proof.
+func (s *Submissions) removeLeafHash(logKeyHash, leafHash crypto.Hash) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	delete(s.pending[logKeyHash], leafHash)
+}
+
+// Returns the pending leaves accepted before the given time, mapping
+// leaf hash to acceptance time.
+func (s *Submissions) pendingBefore(logKeyHash crypto.Hash, t time.Time) map[crypto.Hash]time.Time {
+	s.m.Lock()
+	defer s.m.Unlock()
+	leaves := make(map[crypto.Hash]time.Time)
+	for leafHash, accepted := range s.pending[logKeyHash] {
+		if accepted.Before(t) {
+			leaves[leafHash] = accepted
+		}
+	}
+	return leaves
+}
@@ -0,0 +1,174 @@
+package monitor
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"sigsum.org/sigsum-go/pkg/crypto"
+	"sigsum.org/sigsum-go/pkg/merkle"
+	"sigsum.org/sigsum-go/pkg/policy"
+	"sigsum.org/sigsum-go/pkg/requests"
+	"sigsum.org/sigsum-go/pkg/types"
+)
+
+func newTestPolicy(t *testing.T, k int, witnesses ...crypto.PublicKey) *policy.Policy {
+	t.Helper()
+	p, err := policy.NewKofNPolicy([]crypto.PublicKey{crypto.PublicKey{1}}, witnesses, k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestQuorumTimestamp(t *testing.T) {
+	witnesses := []crypto.PublicKey{{2}, {3}, {4}}
+	cosignatures := make(map[crypto.Hash]types.Cosignature)
+	for i, w := range witnesses {
+		cosignatures[crypto.HashBytes(w[:])] = types.Cosignature{Timestamp: uint64(100 * (i + 1))}
+	}
+	for _, table := range []struct {
+		desc string
+		k    int
+		want uint64
+		ok   bool
+	}{
+		{"1 of 3", 1, 300, true},
+		{"2 of 3", 2, 200, true},
+		{"3 of 3", 3, 100, true},
+		{"0 of 3", 0, 0, false},
+	} {
+		got, ok := quorumTimestamp(newTestPolicy(t, table.k, witnesses...), cosignatures)
+		if got != table.want || ok != table.ok {
+			t.Errorf("%s: got (%d, %v), want (%d, %v)", table.desc, got, ok, table.want, table.ok)
+		}
+	}
+	// No quorum.
+	delete(cosignatures, crypto.HashBytes(witnesses[0][:]))
+	if _, ok := quorumTimestamp(newTestPolicy(t, 3, witnesses...), cosignatures); ok {
+		t.Errorf("got timestamp without quorum")
+	}
+}
+
+func TestCheckCosignatureAge(t *testing.T) {
+	witness := crypto.PublicKey{2}
+	p := newTestPolicy(t, 1, witness)
+	now := time.Unix(10000, 0)
+	config := (&Config{now: func() time.Time { return now }}).applyDefaults()
+
+	cth := types.CosignedTreeHead{
+		Cosignatures: map[crypto.Hash]types.Cosignature{
+			crypto.HashBytes(witness[:]): types.Cosignature{},
+		},
+	}
+	var fs freshnessState
+	for _, table := range []struct {
+		desc      string
+		timestamp time.Time
+		alert     bool
+	}{
+		{"fresh", now.Add(-time.Minute), false},
+		{"future", now.Add(time.Minute), false},
+		{"stale", now.Add(-2 * time.Hour), true},
+		// Alerted only once.
+		{"still stale", now.Add(-2 * time.Hour), false},
+		{"fresh again", now.Add(-time.Minute), false},
+		{"stale again", now.Add(-2 * time.Hour), true},
+	} {
+		cth.Cosignatures[crypto.HashBytes(witness[:])] = types.Cosignature{Timestamp: uint64(table.timestamp.Unix())}
+		alert := config.checkCosignatureAge(p, &cth, &fs)
+		if !table.alert {
+			if alert != nil {
+				t.Errorf("%s: unexpected alert: %v", table.desc, alert)
+			}
+		} else if alert == nil || alert.Type != AlertStaleCosignatures {
+			t.Errorf("%s: got alert %v, want type %v", table.desc, alert, AlertStaleCosignatures)
+		}
+	}
+	// No check without policy.
+	if alert := config.checkCosignatureAge(nil, &cth, &freshnessState{}); alert != nil {
+		t.Errorf("unexpected alert without policy: %v", alert)
+	}
+}
+
+// Counts inclusion proof requests.
+type countingLog struct {
+	*testLog
+	inclusionProofs int
+}
+
+func (l *countingLog) GetInclusionProof(ctx context.Context, req requests.InclusionProof) (types.InclusionProof, error) {
+	l.inclusionProofs++
+	return l.testLog.GetInclusionProof(ctx, req)
+}
+
+func TestCheckSubmissions(t *testing.T) {
+	ctx := context.Background()
+	logSigner := crypto.NewEd25519Signer(&crypto.PrivateKey{2})
+	log := testLog{signer: logSigner, tree: merkle.NewTree()}
+	addLeaves(t, &log, crypto.NewEd25519Signer(&crypto.PrivateKey{3}), 0, 5)
+	cth, err := log.GetTreeHead(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	counter := countingLog{testLog: &log}
+	client := monitoringLogClient{logKey: logSigner.Public(), client: &counter}
+	logKeyHash := crypto.HashBytes(client.logKey[:])
+
+	now := time.Unix(10000, 0)
+	submissions := NewSubmissions()
+	config := (&Config{
+		Submissions: submissions,
+		now:         func() time.Time { return now },
+	}).applyDefaults()
+
+	leaves := []types.Leaf{{Checksum: crypto.Hash{2}}, {Checksum: crypto.Hash{3}}}
+	submissions.Add(logKeyHash, leaves[0].ToHash(), now.Add(-time.Hour))
+	submissions.Add(logKeyHash, leaves[1].ToHash(), now.Add(-time.Minute))
+	// Different log.
+	submissions.Add(crypto.Hash{4}, crypto.Hash{5}, now.Add(-time.Hour))
+
+	var fs freshnessState
+	if alert := config.checkSubmissions(ctx, &client, logKeyHash, &cth.TreeHead, &fs); alert == nil || alert.Type != AlertLogNotAdvancing {
+		t.Errorf("got alert %v, want type %v", alert, AlertLogNotAdvancing)
+	}
+	// Not alerted again for the same tree head, and the
+	// inclusion proof lookup isn't repeated.
+	if alert := config.checkSubmissions(ctx, &client, logKeyHash, &cth.TreeHead, &fs); alert != nil {
+		t.Errorf("unexpected repeated alert: %v", alert)
+	}
+	if got := counter.inclusionProofs; got != 1 {
+		t.Errorf("unexpected number of inclusion proof requests, got %d, want 1", got)
+	}
+	// But alerted again for a new tree head.
+	addLeaves(t, &log, crypto.NewEd25519Signer(&crypto.PrivateKey{3}), 5, 6)
+	cth, err = log.GetTreeHead(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if alert := config.checkSubmissions(ctx, &client, logKeyHash, &cth.TreeHead, &fs); alert == nil || alert.Type != AlertLogNotAdvancing {
+		t.Errorf("got alert %v for new tree head, want type %v", alert, AlertLogNotAdvancing)
+	}
+	if got := counter.inclusionProofs; got != 2 {
+		t.Errorf("unexpected number of inclusion proof requests, got %d, want 2", got)
+	}
+	submissions.remove(logKeyHash, leaves[:1])
+	// Remaining leaf is recent.
+	if alert := config.checkSubmissions(ctx, &client, logKeyHash, &cth.TreeHead, &fs); alert != nil {
+		t.Errorf("unexpected alert: %v", alert)
+	}
+	if got := len(submissions.pendingBefore(logKeyHash, now)); got != 1 {
+		t.Errorf("unexpected number of pending leaves, got %d, want 1", got)
+	}
+
+	// A leaf recorded after it was processed is found in the
+	// tree, and removed without any alert.
+	included := log.leaves[3].ToHash()
+	submissions.Add(logKeyHash, included, now.Add(-time.Hour))
+	if alert := config.checkSubmissions(ctx, &client, logKeyHash, &cth.TreeHead, &fs); alert != nil {
+		t.Errorf("unexpected alert for included leaf: %v", alert)
+	}
+	if _, ok := submissions.pendingBefore(logKeyHash, now)[included]; ok {
+		t.Errorf("included leaf not removed")
+	}
+}
@@ -7,23 +7,26 @@ import (
 
 	"sigsum.org/sigsum-go/pkg/crypto"
 	"sigsum.org/sigsum-go/pkg/policy"
-	"sigsum.org/sigsum-go/pkg/requests"
 	"sigsum.org/sigsum-go/pkg/types"
 )
 
 const (
-	DefaultBatchSize     = 512
-	DefaultQueryInterval = 10 * time.Minute
+	DefaultBatchSize           = 512
+	DefaultMaxConcurrentRanges = 4
+	DefaultQueryInterval       = 10 * time.Minute
+	DefaultMaxCosignatureAge   = time.Hour
+	DefaultMaxSubmissionDelay  = 10 * time.Minute
 )
 
-// TODO: Figure out the proper interface to the monitor. Are callbacks
-// the right way, or should we instead have one or more channels to
-// pass new data items and alerts?
+// Callbacks are invoked synchronously by the monitoring goroutine of
+// each log, so a slow callback delays monitoring of that log. See
+// StartEventMonitoring for a channel-based alternative.
 type Callbacks interface {
 	// Called when a log (identified by key hash) has a new tree
 	// head; application can use this to persist the tree head.
-	// TODO: Also include cosignatures?
-	NewTreeHead(logKeyHash crypto.Hash, signedTreeHead types.SignedTreeHead)
+	// Only cosignatures that have been verified, by witnesses
+	// listed in the policy, are included.
+	NewTreeHead(logKeyHash crypto.Hash, cosignedTreeHead types.CosignedTreeHead)
 	// Called when there are new leaves with submit key of
 	// interest. Includes only leaves with a known submit key, and
 	// where signature and inclusion proof are valid.
@@ -46,12 +49,34 @@ type MonitorState struct {
 
 type Config struct {
 	QueryInterval time.Duration
-	// Maximum number of leaves to request at a time
+	// Maximum number of leaves to request at a time. Reduced
+	// automatically if the log returns fewer leaves than
+	// requested.
 	BatchSize uint64
+	// Maximum number of leaf ranges, each of at most BatchSize
+	// leaves, to retrieve concurrently. Zero implies a default.
+	MaxConcurrentRanges int
 	// Keys of interest. If nil, all keys are of interest (but no
 	// signatures are verified).
 	SubmitKeys map[crypto.Hash]crypto.PublicKey
 	Callbacks  Callbacks
+
+	// Maximum age of the newest cosignatures that satisfy the
+	// policy's quorum. Zero implies a default, a negative value
+	// disables the check.
+	MaxCosignatureAge time.Duration
+	// Optional record of submissions accepted by logs. A log
+	// that hasn't included an accepted leaf in its tree within
+	// MaxSubmissionDelay (zero implies a default) is alerted.
+	Submissions        *Submissions
+	MaxSubmissionDelay time.Duration
+
+	// Used only by StartEventMonitoring: Maximum number of
+	// unacknowledged events per log. Zero implies a default.
+	MaxUnackedEvents int
+
+	// For tests. If nil, defaults to time.Now.
+	now func() time.Time
 }
 
 func (c *Config) applyDefaults() Config {
@@ -62,9 +87,84 @@ func (c *Config) applyDefaults() Config {
 	if r.BatchSize == 0 {
 		r.BatchSize = DefaultBatchSize
 	}
+	if r.MaxConcurrentRanges <= 0 {
+		r.MaxConcurrentRanges = DefaultMaxConcurrentRanges
+	}
+	if r.MaxCosignatureAge == 0 {
+		r.MaxCosignatureAge = DefaultMaxCosignatureAge
+	}
+	if r.MaxSubmissionDelay <= 0 {
+		r.MaxSubmissionDelay = DefaultMaxSubmissionDelay
+	}
+	if r.now == nil {
+		r.now = time.Now
+	}
 	return r
 }
 
+// Checks that the newest cosignatures satisfying the quorum are
+// recent enough. Alerts only when the cosignatures become too old,
+// not again until they have been fresh in between.
+func (c *Config) checkCosignatureAge(p *policy.Policy, cth *types.CosignedTreeHead, fs *freshnessState) *Alert {
+	if c.MaxCosignatureAge < 0 || p == nil {
+		return nil
+	}
+	timestamp, ok := quorumTimestamp(p, cth.Cosignatures)
+	if !ok {
+		return nil
+	}
+	age := c.now().Sub(time.Unix(int64(timestamp), 0))
+	if age <= c.MaxCosignatureAge {
+		fs.staleCosignatures = false
+		return nil
+	}
+	if fs.staleCosignatures {
+		return nil
+	}
+	fs.staleCosignatures = true
+	return newAlert(AlertStaleCosignatures,
+		"newest cosignatures satisfying the quorum are %v old, tree size %d",
+		age.Truncate(time.Second), cth.Size)
+}
+
+// Checks that there are no accepted submissions that the log has
+// failed to include in its tree. Must only be called when all leaves
+// of the current tree head have been processed. Overdue leaves are
+// looked up using inclusion proofs, since they may have been included
+// in leaves processed before they were recorded. Alerts at most once
+// per tree head, unless more leaves become overdue.
+func (c *Config) checkSubmissions(ctx context.Context, client *monitoringLogClient,
+	logKeyHash crypto.Hash, treeHead *types.TreeHead, fs *freshnessState) *Alert {
+	if c.Submissions == nil {
+		return nil
+	}
+	count := 0
+	var oldest time.Time
+	notIncluded := make(map[crypto.Hash]uint64)
+	for leafHash, accepted := range c.Submissions.pendingBefore(logKeyHash, c.now().Add(-c.MaxSubmissionDelay)) {
+		if size, ok := fs.notIncluded[leafHash]; !ok || size != treeHead.Size {
+			if client.isIncluded(ctx, &leafHash, treeHead) {
+				c.Submissions.removeLeafHash(logKeyHash, leafHash)
+				continue
+			}
+		}
+		notIncluded[leafHash] = treeHead.Size
+		count++
+		if oldest.IsZero() || accepted.Before(oldest) {
+			oldest = accepted
+		}
+	}
+	fs.notIncluded = notIncluded
+	alert := count > 0 && (treeHead.Size != fs.overdueSize || count > fs.overdueCount)
+	fs.overdueSize, fs.overdueCount = treeHead.Size, count
+	if !alert {
+		return nil
+	}
+	return newAlert(AlertLogNotAdvancing,
+		"%d accepted leaves not included in tree of size %d, oldest accepted at %v",
+		count, treeHead.Size, oldest.UTC().Format(time.RFC3339))
+}
+
 func (c *Config) filterLeaves(
 	leaves []types.Leaf, startIndex uint64, alertCallback func(*Alert)) ([]uint64, []types.Leaf) {
 	if c.SubmitKeys == nil {
@@ -97,44 +197,123 @@ func (c *Config) filterLeaves(
 	return indices, matchedLeaves
 }
 
+// Retrieves and verifies all leaves from state.NextLeafIndex up to
+// the tree size, and reports them via callbacks. Ranges of leaves are
+// retrieved concurrently, but verified and reported in order. Stops at
+// the first error, which is reported as an alert.
+func (c *Config) processLeaves(ctx context.Context, client *monitoringLogClient, keyHash crypto.Hash, state *MonitorState) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		r   *leafRange
+		err error
+	}
+	// Each queued channel receives the result for one range, in
+	// order. Together with the range being processed, the queue
+	// bounds the number of concurrent ranges.
+	queue := make(chan chan result, c.MaxConcurrentRanges-1)
+	treeHead := state.TreeHead
+	go func() {
+		defer close(queue)
+		for start := state.NextLeafIndex; start < treeHead.Size; {
+			end := treeHead.Size
+			if batchSize := client.batchSize(c.BatchSize); end-start > batchSize {
+				end = start + batchSize
+			}
+			ch := make(chan result, 1)
+			select {
+			case <-ctx.Done():
+				return
+			case queue <- ch:
+			}
+			go func(start, end uint64) {
+				r, err := client.fetchRange(ctx, &treeHead, start, end)
+				ch <- result{r: r, err: err}
+			}(start, end)
+			start = end
+		}
+	}()
+
+	var glState *getLeavesState
+	for ch := range queue {
+		res := <-ch
+		if res.err != nil {
+			c.Callbacks.Alert(keyHash, res.err)
+			return
+		}
+		var err error
+		glState, err = client.verifyRange(ctx, glState, &treeHead, res.r)
+		if err != nil {
+			c.Callbacks.Alert(keyHash, err)
+			return
+		}
+		indices, leaves := c.filterLeaves(res.r.leaves, res.r.start, func(alert *Alert) {
+			c.Callbacks.Alert(keyHash, alert)
+		})
+		if c.Submissions != nil {
+			c.Submissions.remove(keyHash, res.r.leaves)
+		}
+		state.NextLeafIndex += uint64(len(res.r.leaves))
+		c.Callbacks.NewLeaves(keyHash, state.NextLeafIndex, indices, leaves)
+	}
+}
+
 // Monitor a single sigsum log. A monitor program is expected to call
 // this function in one goroutine per log it monitors.
 func MonitorLog(ctx context.Context, client *monitoringLogClient,
 	state MonitorState, c *Config) {
 	config := c.applyDefaults()
 	keyHash := crypto.HashBytes(client.logKey[:])
+	var freshness freshnessState
+	// Tree head received while waiting, if any.
+	var waited *types.CosignedTreeHead
 	for ctx.Err() == nil {
 		updateCtx, _ := context.WithTimeout(ctx, config.QueryInterval)
 		if state.TreeHead.Size == state.NextLeafIndex {
-			cth, err := client.getTreeHead(ctx, &state.TreeHead)
+			var cth types.CosignedTreeHead
+			var err error
+			if waited != nil {
+				cth, err = client.checkTreeHead(ctx, waited, &state.TreeHead)
+				waited = nil
+			} else {
+				cth, err = client.getTreeHead(ctx, &state.TreeHead)
+			}
+			if err == nil {
+				cth, err = client.verifyCosignatures(&cth, func(alert *Alert) {
+					config.Callbacks.Alert(keyHash, alert)
+				})
+			}
 			if err != nil {
 				config.Callbacks.Alert(keyHash, err)
-			} else if cth.Size > state.TreeHead.Size {
-				config.Callbacks.NewTreeHead(keyHash, cth)
-				state.TreeHead = cth.TreeHead
+			} else {
+				if alert := config.checkCosignatureAge(client.policy, &cth, &freshness); alert != nil {
+					config.Callbacks.Alert(keyHash, alert)
+				}
+				if cth.Size > state.TreeHead.Size {
+					config.Callbacks.NewTreeHead(keyHash, cth)
+					state.TreeHead = cth.TreeHead
+				}
 			}
 		}
-		for glState := (*getLeavesState)(nil); state.NextLeafIndex < state.TreeHead.Size; {
-			end := state.TreeHead.Size
-			if end-state.NextLeafIndex > config.BatchSize {
-				end = state.NextLeafIndex + config.BatchSize
+		if state.NextLeafIndex < state.TreeHead.Size {
+			config.processLeaves(ctx, client, keyHash, &state)
+		}
+		if state.NextLeafIndex == state.TreeHead.Size {
+			if alert := config.checkSubmissions(ctx, client, keyHash, &state.TreeHead, &freshness); alert != nil {
+				config.Callbacks.Alert(keyHash, alert)
 			}
-			var allLeaves []types.Leaf
-			var err error
-			allLeaves, glState, err = client.getLeaves(ctx, glState, &state.TreeHead,
-				requests.Leaves{StartIndex: state.NextLeafIndex, EndIndex: end})
-			if err != nil {
-				config.Callbacks.Alert(keyHash, err)
-				break
+		}
+		if state.NextLeafIndex == state.TreeHead.Size {
+			// Waits until end of interval, or until
+			// the log's tree grows.
+			if cth, ok := client.waitForTreeHead(updateCtx, state.TreeHead.Size); ok {
+				waited = &cth
 			}
-			indices, leaves := config.filterLeaves(allLeaves, state.NextLeafIndex, func(alert *Alert) {
-				config.Callbacks.Alert(keyHash, err)
-			})
-			state.NextLeafIndex += uint64(len(allLeaves))
-			config.Callbacks.NewLeaves(keyHash, state.NextLeafIndex, indices, leaves)
+		} else {
+			// Waits until end of interval
+			<-updateCtx.Done()
 		}
-		// Waits until end of interval
-		<-updateCtx.Done()
 	}
 }
 
@@ -143,6 +322,7 @@ func StartMonitoring(
 	ctx context.Context, p *policy.Policy,
 	config *Config,
 	state map[crypto.Hash]MonitorState) <-chan struct{} {
+	queryInterval := config.applyDefaults().QueryInterval
 	var wg sync.WaitGroup
 	for _, l := range p.GetLogsWithUrl() {
 		keyHash := crypto.HashBytes(l.PublicKey[:])
@@ -156,7 +336,7 @@ func StartMonitoring(
 
 		wg.Add(1)
 		go func(l policy.Entity) {
-			MonitorLog(ctx, newMonitoringLogClient(&l.PublicKey, l.URL), initialState, config)
+			MonitorLog(ctx, newMonitoringLogClient(&l, p, queryInterval), initialState, config)
 			wg.Done()
 		}(l)
 	}
@@ -0,0 +1,286 @@
+package monitor
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"sigsum.org/sigsum-go/pkg/api"
+	"sigsum.org/sigsum-go/pkg/crypto"
+	"sigsum.org/sigsum-go/pkg/merkle"
+	"sigsum.org/sigsum-go/pkg/requests"
+	"sigsum.org/sigsum-go/pkg/types"
+)
+
+// Returns at most maxLeaves leaves per request, and counts requests.
+type limitedLog struct {
+	*testLog
+	maxLeaves       uint64
+	leafRequests    atomic.Int64
+	inclusionProofs atomic.Int64
+	// If set, corrupt the leaf with this index + 1.
+	badLeaf uint64
+}
+
+func (l *limitedLog) GetLeaves(ctx context.Context, req requests.Leaves) ([]types.Leaf, error) {
+	l.leafRequests.Add(1)
+	if req.EndIndex-req.StartIndex > l.maxLeaves {
+		req.EndIndex = req.StartIndex + l.maxLeaves
+	}
+	leaves, err := l.testLog.GetLeaves(ctx, req)
+	if err != nil || l.badLeaf == 0 {
+		return leaves, err
+	}
+	leaves = append([]types.Leaf(nil), leaves...)
+	for i := range leaves {
+		if req.StartIndex+uint64(i) == l.badLeaf-1 {
+			leaves[i].Checksum[0] ^= 1
+		}
+	}
+	return leaves, nil
+}
+
+func (l *limitedLog) GetInclusionProof(ctx context.Context, req requests.InclusionProof) (types.InclusionProof, error) {
+	l.inclusionProofs.Add(1)
+	return l.testLog.GetInclusionProof(ctx, req)
+}
+
+// Adds support for range proofs, unless unsupported is set. If
+// failing is set, requests fail with a server error.
+type rangeProvingLog struct {
+	*limitedLog
+	unsupported bool
+	failing     bool
+	rangeProofs atomic.Int64
+}
+
+func (l *rangeProvingLog) GetRangeProof(_ context.Context, req requests.RangeProof) (types.RangeProof, error) {
+	l.rangeProofs.Add(1)
+	if l.unsupported {
+		return types.RangeProof{}, api.ErrNotFound
+	}
+	if l.failing {
+		return types.RangeProof{}, api.NewError(http.StatusServiceUnavailable, fmt.Errorf("mock error"))
+	}
+	path, err := l.tree.ProveRange(req.StartIndex, req.EndIndex, req.Size)
+	return types.RangeProof{Path: path}, err
+}
+
+type recordingCallbacks struct {
+	next   uint64
+	leaves []uint64
+	alerts []error
+}
+
+func (c *recordingCallbacks) NewTreeHead(crypto.Hash, types.CosignedTreeHead) {}
+func (c *recordingCallbacks) NewLeaves(_ crypto.Hash, numberOfProcessedLeaves uint64, indices []uint64, _ []types.Leaf) {
+	c.next = numberOfProcessedLeaves
+	c.leaves = append(c.leaves, indices...)
+}
+func (c *recordingCallbacks) Alert(_ crypto.Hash, err error) {
+	c.alerts = append(c.alerts, err)
+}
+
+func TestProcessLeaves(t *testing.T) {
+	logSigner := crypto.NewEd25519Signer(&crypto.PrivateKey{2})
+	leafSigner := crypto.NewEd25519Signer(&crypto.PrivateKey{3})
+	log := testLog{signer: logSigner, tree: merkle.NewTree()}
+	addLeaves(t, &log, leafSigner, 0, 100)
+	th := types.TreeHead{Size: log.tree.Size(), RootHash: log.tree.GetRootHash()}
+
+	for _, table := range []struct {
+		desc       string
+		maxLeaves  uint64
+		batchSize  uint64
+		concurrent int
+		start      uint64
+	}{
+		{"sequential", 100, 16, 1, 0},
+		{"concurrent", 100, 16, 3, 0},
+		{"short responses", 7, 16, 3, 0},
+		{"single leaf batches", 100, 1, 5, 90},
+		{"single leaf responses", 1, 16, 2, 95},
+		{"last leaf only", 100, 16, 2, 99},
+	} {
+		l := limitedLog{testLog: &log, maxLeaves: table.maxLeaves}
+		client := monitoringLogClient{logKey: logSigner.Public(), client: &l}
+		callbacks := recordingCallbacks{}
+		config := (&Config{
+			BatchSize:           table.batchSize,
+			MaxConcurrentRanges: table.concurrent,
+			Callbacks:           &callbacks,
+		}).applyDefaults()
+		state := MonitorState{TreeHead: th, NextLeafIndex: table.start}
+		config.processLeaves(context.Background(), &client, crypto.Hash{}, &state)
+
+		if len(callbacks.alerts) > 0 {
+			t.Fatalf("%s: unexpected alerts: %v", table.desc, callbacks.alerts)
+		}
+		if state.NextLeafIndex != th.Size || callbacks.next != th.Size {
+			t.Errorf("%s: not all leaves processed, next index %d, reported %d",
+				table.desc, state.NextLeafIndex, callbacks.next)
+		}
+		for i, index := range callbacks.leaves {
+			if index != table.start+uint64(i) {
+				t.Fatalf("%s: leaves out of order, got index %d at position %d", table.desc, index, i)
+			}
+		}
+		if got, want := uint64(len(callbacks.leaves)), th.Size-table.start; got != want {
+			t.Errorf("%s: got %d leaves, want %d", table.desc, got, want)
+		}
+		// One inclusion proof per range, except the last range
+		// which needs none, plus one for the first leaf.
+		batchSize := client.batchSize(table.batchSize)
+		ranges := (th.Size - table.start + batchSize - 1) / batchSize
+		if got, max := l.inclusionProofs.Load(), int64(ranges)+1; got > max {
+			t.Errorf("%s: too many inclusion proof requests, got %d, max %d", table.desc, got, max)
+		}
+		if table.maxLeaves < table.batchSize {
+			if got, want := client.leafLimit.Load(), table.maxLeaves; got != want {
+				t.Errorf("%s: unexpected leaf limit, got %d, want %d", table.desc, got, want)
+			}
+		}
+	}
+}
+
+func TestProcessLeavesInvalid(t *testing.T) {
+	logSigner := crypto.NewEd25519Signer(&crypto.PrivateKey{2})
+	leafSigner := crypto.NewEd25519Signer(&crypto.PrivateKey{3})
+	log := testLog{signer: logSigner, tree: merkle.NewTree()}
+	addLeaves(t, &log, leafSigner, 0, 100)
+	th := types.TreeHead{Size: log.tree.Size(), RootHash: log.tree.GetRootHash()}
+
+	l := limitedLog{testLog: &log, maxLeaves: 100, badLeaf: 51}
+	client := monitoringLogClient{logKey: logSigner.Public(), client: &l}
+	callbacks := recordingCallbacks{}
+	config := (&Config{
+		BatchSize:           10,
+		MaxConcurrentRanges: 4,
+		Callbacks:           &callbacks,
+	}).applyDefaults()
+	state := MonitorState{TreeHead: th}
+	config.processLeaves(context.Background(), &client, crypto.Hash{}, &state)
+	if len(callbacks.alerts) != 1 {
+		t.Fatalf("expected a single alert, got %v", callbacks.alerts)
+	}
+	// Processing stops at the range with the bad leaf.
+	if state.NextLeafIndex != 50 {
+		t.Errorf("unexpected next index after bad leaf, got %d, want 50", state.NextLeafIndex)
+	}
+}
+
+func TestProcessLeavesRangeProofs(t *testing.T) {
+	logSigner := crypto.NewEd25519Signer(&crypto.PrivateKey{2})
+	leafSigner := crypto.NewEd25519Signer(&crypto.PrivateKey{3})
+	log := testLog{signer: logSigner, tree: merkle.NewTree()}
+	addLeaves(t, &log, leafSigner, 0, 100)
+	th := types.TreeHead{Size: log.tree.Size(), RootHash: log.tree.GetRootHash()}
+
+	for _, table := range []struct {
+		desc            string
+		unsupported     bool
+		failing         bool
+		badLeaf         uint64
+		wantRangeProofs int64
+		wantNext        uint64
+	}{
+		{"supported", false, false, 0, 7, 100},
+		{"unsupported", true, false, 0, 1, 100},
+		{"failing", false, true, 0, 7, 100},
+		{"bad leaf", false, false, 51, 4, 48},
+	} {
+		l := rangeProvingLog{limitedLog: &limitedLog{testLog: &log, maxLeaves: 100, badLeaf: table.badLeaf},
+			unsupported: table.unsupported, failing: table.failing}
+		client := monitoringLogClient{logKey: logSigner.Public(), client: &l}
+		callbacks := recordingCallbacks{}
+		config := (&Config{
+			BatchSize:           16,
+			MaxConcurrentRanges: 1,
+			Callbacks:           &callbacks,
+		}).applyDefaults()
+		state := MonitorState{TreeHead: th}
+		config.processLeaves(context.Background(), &client, crypto.Hash{}, &state)
+
+		if table.badLeaf > 0 {
+			if len(callbacks.alerts) != 1 {
+				t.Errorf("%s: expected a single alert, got %v", table.desc, callbacks.alerts)
+			}
+		} else if len(callbacks.alerts) > 0 {
+			t.Errorf("%s: unexpected alerts: %v", table.desc, callbacks.alerts)
+		}
+		if state.NextLeafIndex != table.wantNext {
+			t.Errorf("%s: unexpected next index, got %d, want %d", table.desc, state.NextLeafIndex, table.wantNext)
+		}
+		if got, want := l.rangeProofs.Load(), table.wantRangeProofs; got != want {
+			t.Errorf("%s: unexpected number of range proof requests, got %d, want %d", table.desc, got, want)
+		}
+		if got := l.inclusionProofs.Load(); !table.unsupported && !table.failing && got > 0 {
+			t.Errorf("%s: unexpected inclusion proof requests: %d", table.desc, got)
+		}
+		if table.unsupported {
+			// Probed again, once the interval has passed.
+			client.noRangeProofsUntil.Store(time.Now().Add(-time.Second).UnixNano())
+			if _, err := client.fetchRange(context.Background(), &th, 0, 16); err != nil {
+				t.Errorf("%s: fetching range failed: %v", table.desc, err)
+			}
+			if got, want := l.rangeProofs.Load(), table.wantRangeProofs+1; got != want {
+				t.Errorf("%s: range proofs not probed again, got %d requests, want %d", table.desc, got, want)
+			}
+		}
+	}
+}
+
+// Adds leaves while waiting, and counts get-tree-head requests.
+type waitingLog struct {
+	*testLog
+	t          *testing.T
+	leafSigner crypto.Signer
+	cancel     func()
+	waits      int
+	treeHeads  atomic.Int64
+}
+
+func (l *waitingLog) GetTreeHead(ctx context.Context) (types.CosignedTreeHead, error) {
+	l.treeHeads.Add(1)
+	return l.testLog.GetTreeHead(ctx)
+}
+
+func (l *waitingLog) WaitTreeHead(ctx context.Context, req requests.TreeHead) (types.CosignedTreeHead, error) {
+	l.waits++
+	if l.waits > 1 {
+		l.cancel()
+		return types.CosignedTreeHead{}, ctx.Err()
+	}
+	addLeaves(l.t, l.testLog, l.leafSigner, 1, 10)
+	return l.testLog.GetTreeHead(ctx)
+}
+
+// The tree head returned by WaitTreeHead is used without another
+// get-tree-head request.
+func TestMonitorLogWaitedTreeHead(t *testing.T) {
+	logSigner := crypto.NewEd25519Signer(&crypto.PrivateKey{2})
+	leafSigner := crypto.NewEd25519Signer(&crypto.PrivateKey{3})
+	log := testLog{signer: logSigner, tree: merkle.NewTree()}
+	addLeaves(t, &log, leafSigner, 0, 10)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	l := waitingLog{testLog: &log, t: t, leafSigner: leafSigner, cancel: cancel}
+	client := monitoringLogClient{logKey: logSigner.Public(), client: &l}
+	callbacks := recordingCallbacks{}
+	MonitorLog(ctx, &client, MonitorState{TreeHead: types.NewEmptyTreeHead()},
+		&Config{QueryInterval: time.Minute, Callbacks: &callbacks})
+
+	if len(callbacks.alerts) > 0 {
+		t.Fatalf("unexpected alerts: %v", callbacks.alerts)
+	}
+	if got, want := callbacks.next, uint64(20); got != want {
+		t.Errorf("unexpected number of processed leaves, got %d, want %d", got, want)
+	}
+	if got := l.treeHeads.Load(); got != 1 {
+		t.Errorf("unexpected number of get-tree-head requests, got %d, want 1", got)
+	}
+}
@@ -0,0 +1,205 @@
+package monitor
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/dchest/safefile"
+
+	"sigsum.org/sigsum-go/pkg/ascii"
+	"sigsum.org/sigsum-go/pkg/crypto"
+	"sigsum.org/sigsum-go/pkg/types"
+)
+
+const (
+	// Name of the file recording the submit keys of interest.
+	submitKeysFileName = "submit-keys"
+)
+
+// Monitor state for a single log, as persisted, including the log's
+// signature on the tree head.
+type StoredState struct {
+	SignedTreeHead types.SignedTreeHead
+	// Index of next leaf to process.
+	NextLeafIndex uint64
+}
+
+func (s *StoredState) MonitorState() MonitorState {
+	return MonitorState{
+		TreeHead:      s.SignedTreeHead.TreeHead,
+		NextLeafIndex: s.NextLeafIndex,
+	}
+}
+
+// Format is a signed tree head, followed by an empty line and a
+// next_leaf_index line.
+func (s *StoredState) ToASCII(w io.Writer) error {
+	if err := s.SignedTreeHead.ToASCII(w); err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "\n"); err != nil {
+		return err
+	}
+	return ascii.WriteInt(w, "next_leaf_index", s.NextLeafIndex)
+}
+
+func (s *StoredState) FromASCII(r io.Reader) error {
+	p := ascii.NewParser(r)
+	if err := s.SignedTreeHead.Parse(&p); err != nil {
+		return err
+	}
+	if err := p.GetEmptyLine(); err != nil {
+		return err
+	}
+	var err error
+	if s.NextLeafIndex, err = p.GetInt("next_leaf_index"); err != nil {
+		return err
+	}
+	if s.NextLeafIndex > s.SignedTreeHead.Size {
+		return fmt.Errorf("invalid state, next_leaf_index %d larger than tree size %d",
+			s.NextLeafIndex, s.SignedTreeHead.Size)
+	}
+	return p.GetEOF()
+}
+
+// A StateDirectory persists monitor state, with one file per log,
+// named by the lowercase hex hash of the log's key. The directory
+// also records the set of submit keys of interest. If new keys are
+// added, leaves processed earlier may include leaves of interest
+// that were not reported, and then monitoring must be restarted from
+// the first leaf.
+type StateDirectory struct {
+	dir string
+}
+
+// Opens the state directory, and returns the state for each log
+// found. If the set of submit keys includes any key not present when
+// the state was stored, the returned states are reset to restart
+// processing from the first leaf. Nil or empty submitKeys means
+// that all keys are of interest.
+func OpenStateDirectory(dir string, submitKeys map[crypto.Hash]crypto.PublicKey) (*StateDirectory, map[crypto.Hash]StoredState, error) {
+	d := &StateDirectory{dir: dir}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	states := make(map[crypto.Hash]StoredState)
+	for _, e := range entries {
+		keyHash, err := crypto.HashFromHex(e.Name())
+		if err != nil || !e.Type().IsRegular() {
+			// Not a state file.
+			continue
+		}
+		state, err := readStoredState(filepath.Join(dir, e.Name()))
+		if err != nil {
+			return nil, nil, fmt.Errorf("reading state for log %x failed: %v", keyHash, err)
+		}
+		states[keyHash] = state
+	}
+	oldKeys, err := d.readSubmitKeys()
+	if err != nil {
+		return nil, nil, err
+	}
+	if !isKeySubset(submitKeys, oldKeys) {
+		for keyHash, state := range states {
+			state.NextLeafIndex = 0
+			if err := d.Store(&keyHash, &state); err != nil {
+				return nil, nil, err
+			}
+			states[keyHash] = state
+		}
+	}
+	if err := d.writeSubmitKeys(submitKeys); err != nil {
+		return nil, nil, err
+	}
+	return d, states, nil
+}
+
+// Atomically replaces the state file for the given log.
+func (d *StateDirectory) Store(logKeyHash *crypto.Hash, state *StoredState) error {
+	f, err := safefile.Create(filepath.Join(d.dir, fmt.Sprintf("%x", *logKeyHash)), 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := state.ToASCII(f); err != nil {
+		return err
+	}
+	return f.Commit()
+}
+
+func readStoredState(fileName string) (StoredState, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return StoredState{}, err
+	}
+	defer f.Close()
+	var state StoredState
+	err = state.FromASCII(f)
+	return state, err
+}
+
+// Returns nil if there's no submit keys file, or if it is empty,
+// meaning that all keys were of interest.
+func (d *StateDirectory) readSubmitKeys() (map[crypto.Hash]struct{}, error) {
+	f, err := os.Open(filepath.Join(d.dir, submitKeysFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var keys map[crypto.Hash]struct{}
+	p := ascii.NewParser(f)
+	for {
+		keyHash, err := p.GetHash("key_hash")
+		if err == io.EOF {
+			return keys, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid submit keys file: %v", err)
+		}
+		if keys == nil {
+			keys = make(map[crypto.Hash]struct{})
+		}
+		keys[keyHash] = struct{}{}
+	}
+}
+
+func (d *StateDirectory) writeSubmitKeys(keys map[crypto.Hash]crypto.PublicKey) error {
+	f, err := safefile.Create(filepath.Join(d.dir, submitKeysFileName), 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	for keyHash := range keys {
+		if err := ascii.WriteHash(f, "key_hash", &keyHash); err != nil {
+			return err
+		}
+	}
+	return f.Commit()
+}
+
+// Checks if keys is a subset of oldKeys, where an empty set
+// represents all keys.
+func isKeySubset(keys map[crypto.Hash]crypto.PublicKey, oldKeys map[crypto.Hash]struct{}) bool {
+	if len(oldKeys) == 0 {
+		return true
+	}
+	if len(keys) == 0 {
+		return false
+	}
+	for keyHash := range keys {
+		if _, ok := oldKeys[keyHash]; !ok {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,99 @@
+package monitor
+
+import (
+	"bytes"
+	"testing"
+
+	"sigsum.org/sigsum-go/pkg/crypto"
+	"sigsum.org/sigsum-go/pkg/types"
+)
+
+func TestStoredStateASCII(t *testing.T) {
+	state := StoredState{
+		SignedTreeHead: types.SignedTreeHead{
+			TreeHead:  types.TreeHead{Size: 17, RootHash: crypto.Hash{1}},
+			Signature: crypto.Signature{2},
+		},
+		NextLeafIndex: 10,
+	}
+	buf := bytes.Buffer{}
+	if err := state.ToASCII(&buf); err != nil {
+		t.Fatal(err)
+	}
+	want := "size=17\n" +
+		"root_hash=0100000000000000000000000000000000000000000000000000000000000000\n" +
+		"signature=0200000000000000000000000000000000000000000000000000000000000000" +
+		"0000000000000000000000000000000000000000000000000000000000000000\n" +
+		"\n" +
+		"next_leaf_index=10\n"
+	if got := buf.String(); got != want {
+		t.Errorf("got state\n%s\nwant\n%s", got, want)
+	}
+	var parsed StoredState
+	if err := parsed.FromASCII(bytes.NewBufferString(want)); err != nil {
+		t.Fatal(err)
+	}
+	if parsed != state {
+		t.Errorf("got state %v, want %v", parsed, state)
+	}
+
+	// Next leaf index beyond tree size is invalid.
+	if err := parsed.FromASCII(bytes.NewBufferString(want[:len(want)-3] + "18\n")); err == nil {
+		t.Errorf("invalid next_leaf_index accepted")
+	}
+}
+
+func TestStateDirectory(t *testing.T) {
+	dir := t.TempDir()
+	logKeyHash := crypto.Hash{1}
+	keys := func(hashes ...crypto.Hash) map[crypto.Hash]crypto.PublicKey {
+		m := make(map[crypto.Hash]crypto.PublicKey)
+		for _, h := range hashes {
+			m[h] = crypto.PublicKey{}
+		}
+		return m
+	}
+	open := func(submitKeys map[crypto.Hash]crypto.PublicKey) (*StateDirectory, map[crypto.Hash]StoredState) {
+		t.Helper()
+		d, states, err := OpenStateDirectory(dir, submitKeys)
+		if err != nil {
+			t.Fatalf("opening state directory failed: %v", err)
+		}
+		return d, states
+	}
+	d, states := open(keys(crypto.Hash{2}, crypto.Hash{3}))
+	if len(states) > 0 {
+		t.Fatalf("unexpected state in empty directory: %v", states)
+	}
+	state := StoredState{
+		SignedTreeHead: types.SignedTreeHead{TreeHead: types.TreeHead{Size: 17}},
+		NextLeafIndex:  10,
+	}
+	if err := d.Store(&logKeyHash, &state); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, table := range []struct {
+		desc      string
+		keys      map[crypto.Hash]crypto.PublicKey
+		wantIndex uint64
+	}{
+		{"same keys", keys(crypto.Hash{3}, crypto.Hash{2}), 10},
+		{"removed key", keys(crypto.Hash{3}), 10},
+		{"added key", keys(crypto.Hash{3}, crypto.Hash{4}), 0},
+		{"all keys", nil, 0},
+		{"keys after all keys", keys(crypto.Hash{5}), 10},
+	} {
+		if err := d.Store(&logKeyHash, &state); err != nil {
+			t.Fatal(err)
+		}
+		d, states = open(table.keys)
+		got, ok := states[logKeyHash]
+		if !ok {
+			t.Fatalf("%s: state missing", table.desc)
+		}
+		if got.SignedTreeHead != state.SignedTreeHead || got.NextLeafIndex != table.wantIndex {
+			t.Errorf("%s: got state %v, want next index %d", table.desc, got, table.wantIndex)
+		}
+	}
+}
@@ -12,7 +12,7 @@ import (
 )
 
 // Config file syntax is
-//   log <pubkey> [<url>]
+//   log <pubkey> [<url> [<mirror url>...]]
 //   witness <name> <pubkey> [<url>]
 //   group <name> <threshold> <name>...
 //   quorum <name>
@@ -36,18 +36,20 @@ func (c *config) ifdef(name string) bool {
 }
 
 func (c *config) parseLog(args []string) error {
-	if len(args) < 1 || len(args) > 2 {
-		return fmt.Errorf("invalid log policy line, public key required, url optional")
+	if len(args) < 1 {
+		return fmt.Errorf("invalid log policy line, public key required, urls optional")
 	}
 	key, err := crypto.PublicKeyFromHex(args[0])
 	if err != nil {
 		return err
 	}
 	var url string
+	var mirrors []string
 	if len(args) > 1 {
 		url = args[1]
+		mirrors = args[2:]
 	}
-	_, err = c.policy.addLog(&Entity{PublicKey: key, URL: url})
+	_, err = c.policy.addLog(&Entity{PublicKey: key, URL: url, MirrorURLs: mirrors})
 	return err
 }
 
@@ -181,6 +181,26 @@ quorum G
 	}
 }
 
+func TestLogMirrors(t *testing.T) {
+	policy, err := ParseConfig(bytes.NewBufferString(`
+log aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa http://primary http://mirror1 http://mirror2
+quorum none
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	logs := policy.GetLogsWithUrl()
+	if got, want := len(logs), 1; got != want {
+		t.Fatalf("Unexpected number of logs with url in policy, got %d, expected %d", got, want)
+	}
+	if got, want := logs[0].URL, "http://primary"; got != want {
+		t.Errorf("Unexpected log url, got %q, expected %q", got, want)
+	}
+	if got, want := strings.Join(logs[0].MirrorURLs, " "), "http://mirror1 http://mirror2"; got != want {
+		t.Errorf("Unexpected mirror urls, got %q, expected %q", got, want)
+	}
+}
+
 func TestInvalidConfig(t *testing.T) {
 	for _, table := range []struct {
 		desc   string
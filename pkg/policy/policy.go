@@ -1,8 +1,10 @@
 package policy
 
 import (
+	"bytes"
 	"fmt"
 	"math/rand"
+	"sort"
 
 	"sigsum.org/sigsum-go/pkg/crypto"
 	"sigsum.org/sigsum-go/pkg/types"
@@ -11,6 +13,9 @@ import (
 type Entity struct {
 	PublicKey crypto.PublicKey
 	URL       string
+	// For logs only: URLs of read-only mirrors, which can be used
+	// for any request except add-leaf and get-tree-head.
+	MirrorURLs []string
 }
 
 // The method gets a set of witnesses for which a cosignature was
@@ -19,6 +24,27 @@ type Quorum interface {
 	IsQuorum(map[crypto.Hash]struct{}) bool
 }
 
+// Error returned by VerifyCosignedTreeHead when the log's signature
+// is valid, but the verified cosignatures don't satisfy the quorum.
+// This is typically a temporary condition, e.g., when witnesses have
+// not yet cosigned the log's latest tree head.
+type QuorumError struct {
+	// Number of cosignatures on the tree head.
+	Total int
+	// Number of valid cosignatures by witnesses in the policy.
+	Verified int
+	// Key hashes of witnesses with invalid cosignatures.
+	Failed []crypto.Hash
+	// Witnesses in the policy without any valid cosignature,
+	// ordered by key hash.
+	Missing []Entity
+}
+
+func (e *QuorumError) Error() string {
+	return fmt.Sprintf("not enough cosignatures, total: %d, verified: %d, failed to verify: %d",
+		e.Total, e.Verified, len(e.Failed))
+}
+
 type Policy struct {
 	logs      map[crypto.Hash]Entity
 	witnesses map[crypto.Hash]Entity
@@ -34,22 +60,61 @@ func (p *Policy) VerifyCosignedTreeHead(logKeyHash *crypto.Hash,
 	if !cth.Verify(&log.PublicKey) {
 		return fmt.Errorf("invalid log signature")
 	}
-	origin := types.SigsumCheckpointOrigin(&log.PublicKey)
+	cosignatures, failed := p.VerifyCosignatures(&log.PublicKey, cth)
 	verified := make(map[crypto.Hash]struct{})
-	failed := 0
+	for keyHash := range cosignatures {
+		verified[keyHash] = struct{}{}
+	}
+	if !p.quorum.IsQuorum(verified) {
+		return &QuorumError{
+			Total:    len(cth.Cosignatures),
+			Verified: len(verified),
+			Failed:   failed,
+			Missing:  p.missingWitnesses(verified),
+		}
+	}
+	return nil
+}
+
+// Returns the policy's witnesses that are not in the verified set,
+// ordered by key hash.
+func (p *Policy) missingWitnesses(verified map[crypto.Hash]struct{}) []Entity {
+	var keyHashes []crypto.Hash
+	for keyHash := range p.witnesses {
+		if _, ok := verified[keyHash]; !ok {
+			keyHashes = append(keyHashes, keyHash)
+		}
+	}
+	sort.Slice(keyHashes, func(i, j int) bool {
+		return bytes.Compare(keyHashes[i][:], keyHashes[j][:]) < 0
+	})
+	missing := make([]Entity, 0, len(keyHashes))
+	for _, keyHash := range keyHashes {
+		missing = append(missing, p.witnesses[keyHash])
+	}
+	return missing
+}
+
+// Verifies the cosignatures on a tree head of the log with the given
+// key. Returns the valid cosignatures, and the key hashes of the
+// witnesses with invalid cosignatures. Cosignatures by witnesses not
+// listed in the policy are ignored. Neither the log's signature nor
+// the quorum is checked.
+func (p *Policy) VerifyCosignatures(logKey *crypto.PublicKey,
+	cth *types.CosignedTreeHead) (map[crypto.Hash]types.Cosignature, []crypto.Hash) {
+	origin := types.SigsumCheckpointOrigin(logKey)
+	verified := make(map[crypto.Hash]types.Cosignature)
+	var failed []crypto.Hash
 	for keyHash, cs := range cth.Cosignatures {
 		if witness, ok := p.witnesses[keyHash]; ok {
 			if cs.Verify(&witness.PublicKey, origin, &cth.TreeHead) {
-				verified[keyHash] = struct{}{}
+				verified[keyHash] = cs
 			} else {
-				failed++
+				failed = append(failed, keyHash)
 			}
 		}
 	}
-	if !p.quorum.IsQuorum(verified) {
-		return fmt.Errorf("not enough cosignatures, total: %d, verified: %d, failed to verify: %d", len(cth.Cosignatures), len(verified), failed)
-	}
-	return nil
+	return verified, failed
 }
 
 type quorumSingle struct {
@@ -147,3 +212,9 @@ func NewKofNPolicy(logs, witnesses []crypto.PublicKey, k int) (*Policy, error) {
 	p.quorum = &quorumKofN{subQuorums: subQuorums, k: k}
 	return p, nil
 }
+
+// Returns whether or not the set of witnesses, identified by key
+// hashes, satisfies the policy's quorum.
+func (p *Policy) IsQuorum(verified map[crypto.Hash]struct{}) bool {
+	return p.quorum.IsQuorum(verified)
+}
@@ -1,6 +1,7 @@
 package policy
 
 import (
+	"errors"
 	"testing"
 
 	"sigsum.org/sigsum-go/pkg/crypto"
@@ -114,5 +115,34 @@ func TestWitnessPolicy(t *testing.T) {
 		if !s.expectValid && err == nil {
 			t.Errorf("%s: Expected error, but got none", s.desc)
 		}
+		if s.expectValid {
+			continue
+		}
+		var quorumErr *QuorumError
+		if !errors.As(err, &quorumErr) {
+			t.Errorf("%s: Expected QuorumError, got: %v", s.desc, err)
+			continue
+		}
+		// Witnesses in the policy, without a valid cosignature.
+		wantMissing := make(map[crypto.Hash]bool)
+		for i := 0; i < 4; i++ {
+			wantMissing[witnessHashes[i]] = true
+		}
+		for _, i := range s.w {
+			if i != s.invalidate {
+				delete(wantMissing, witnessHashes[i])
+			}
+		}
+		if got, want := len(quorumErr.Missing), len(wantMissing); got != want {
+			t.Errorf("%s: Unexpected number of missing witnesses, got %d, want %d", s.desc, got, want)
+		}
+		for _, entity := range quorumErr.Missing {
+			if !wantMissing[crypto.HashBytes(entity.PublicKey[:])] {
+				t.Errorf("%s: Unexpected missing witness %x", s.desc, entity.PublicKey)
+			}
+		}
+		if s.invalidate >= 0 && s.invalidate < 4 && len(quorumErr.Failed) != 1 {
+			t.Errorf("%s: Unexpected failed witnesses: %x", s.desc, quorumErr.Failed)
+		}
 	}
 }
@@ -10,6 +10,12 @@ import (
 	"sigsum.org/sigsum-go/pkg/types"
 )
 
+// Request for a tree head larger than OldSize, typically the size of
+// the latest tree head already known to the client.
+type TreeHead struct {
+	OldSize uint64
+}
+
 type Leaf struct {
 	Message   crypto.Hash
 	Signature crypto.Signature
@@ -31,6 +37,14 @@ type ConsistencyProof struct {
 	NewSize uint64
 }
 
+// Request for a proof that the leaves StartIndex <= index < EndIndex
+// are included in the tree of size Size.
+type RangeProof struct {
+	StartIndex uint64
+	EndIndex   uint64
+	Size       uint64
+}
+
 func (req *Leaf) ToASCII(w io.Writer) error {
 	if err := ascii.WriteLine(w, "message", req.Message[:]); err != nil {
 		return err
@@ -53,6 +67,11 @@ func (req *Leaf) Verify() (types.Leaf, error) {
 	}, nil
 }
 
+// ToURL encodes request parameters at the end of a slash-terminated URL
+func (req *TreeHead) ToURL(url string) string {
+	return url + fmt.Sprintf("%d", req.OldSize)
+}
+
 // ToURL encodes request parameters at the end of a slash-terminated URL
 func (req *Leaves) ToURL(url string) string {
 	return url + fmt.Sprintf("%d/%d", req.StartIndex, req.EndIndex)
@@ -68,8 +87,22 @@ func (req *ConsistencyProof) ToURL(url string) string {
 	return url + fmt.Sprintf("%d/%d", req.OldSize, req.NewSize)
 }
 
+// ToURL encodes request parameters at the end of a slash-terminated URL
+func (req *RangeProof) ToURL(url string) string {
+	return url + fmt.Sprintf("%d/%d/%d", req.StartIndex, req.EndIndex, req.Size)
+}
+
 func (req *Leaf) FromASCII(r io.Reader) error {
 	p := ascii.NewParser(r)
+	if err := req.Parse(&p); err != nil {
+		return err
+	}
+	return p.GetEOF()
+}
+
+// Parses the request's lines, without requiring EOF, so that it can
+// be used as part of a larger file.
+func (req *Leaf) Parse(p *ascii.Parser) error {
 	var err error
 	req.Message, err = p.GetHash("message")
 	if err != nil {
@@ -80,10 +113,12 @@ func (req *Leaf) FromASCII(r io.Reader) error {
 		return err
 	}
 	req.PublicKey, err = p.GetPublicKey("public_key")
-	if err != nil {
-		return err
-	}
-	return p.GetEOF()
+	return err
+}
+
+func (req *TreeHead) FromURLArgs(oldSize string) (err error) {
+	req.OldSize, err = ascii.IntFromDecimal(oldSize)
+	return err
 }
 
 func (req *Leaves) FromURLArgs(start, end string) (err error) {
@@ -109,3 +144,14 @@ func (req *ConsistencyProof) FromURLArgs(old, new string) (err error) {
 	req.NewSize, err = ascii.IntFromDecimal(new)
 	return err
 }
+
+func (req *RangeProof) FromURLArgs(start, end, size string) (err error) {
+	if req.StartIndex, err = ascii.IntFromDecimal(start); err != nil {
+		return err
+	}
+	if req.EndIndex, err = ascii.IntFromDecimal(end); err != nil {
+		return err
+	}
+	req.Size, err = ascii.IntFromDecimal(size)
+	return err
+}
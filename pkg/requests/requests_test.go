@@ -22,6 +22,15 @@ func TestLeafToASCII(t *testing.T) {
 	}
 }
 
+func TestTreeHeadToURL(t *testing.T) {
+	url := types.EndpointGetTreeHead.Path("https://poc.sigsum.org") + "/"
+	req := TreeHead{17}
+	want := url + "17"
+	if got := req.ToURL(url); got != want {
+		t.Errorf("got url %s but wanted %s", got, want)
+	}
+}
+
 func TestLeavesToURL(t *testing.T) {
 	url := types.EndpointGetLeaves.Path("https://poc.sigsum.org")
 	req := Leaves{1, 2}
@@ -49,6 +58,15 @@ func TestConsistencyProofToURL(t *testing.T) {
 	}
 }
 
+func TestRangeProofToURL(t *testing.T) {
+	url := types.EndpointGetRangeProof.Path("https://poc.sigsum.org")
+	req := RangeProof{StartIndex: 1, EndIndex: 2, Size: 3}
+	want := url + "1/2/3"
+	if got := req.ToURL(url); got != want {
+		t.Errorf("got url %s but wanted %s", got, want)
+	}
+}
+
 func TestLeafFromASCII(t *testing.T) {
 	for _, table := range []struct {
 		desc       string
@@ -81,6 +99,18 @@ func TestLeafFromASCII(t *testing.T) {
 	}
 }
 
+func TestTreeHeadFromURLArgs(t *testing.T) {
+	var req TreeHead
+	if err := req.FromURLArgs("17"); err != nil {
+		t.Errorf("valid size: %v", err)
+	} else if req.OldSize != 17 {
+		t.Errorf("got size %d, want 17", req.OldSize)
+	}
+	if err := req.FromURLArgs("-1"); err == nil {
+		t.Errorf("invalid size accepted")
+	}
+}
+
 func TestLeavesFromURLArgs(t *testing.T) {
 	for _, table := range []struct {
 		desc       string
@@ -228,6 +258,58 @@ func TestConsistencyProofFromURLArgs(t *testing.T) {
 	}
 }
 
+func TestRangeProofFromURLArgs(t *testing.T) {
+	for _, table := range []struct {
+		desc             string
+		start, end, size string
+		want             *RangeProof
+		wantErr          string
+	}{
+		{
+			desc:    "bad start",
+			start:   "x",
+			end:     "2",
+			size:    "3",
+			wantErr: "parsing \"x\"",
+		},
+		{
+			desc:    "bad end",
+			start:   "1",
+			end:     "-2",
+			size:    "3",
+			wantErr: "parsing \"-2\"",
+		},
+		{
+			desc:    "bad size",
+			start:   "1",
+			end:     "2",
+			size:    "",
+			wantErr: "parsing \"\"",
+		},
+		{
+			desc:  "valid range",
+			start: "1",
+			end:   "20",
+			size:  "30",
+			want:  &RangeProof{StartIndex: 1, EndIndex: 20, Size: 30},
+		},
+	} {
+		var proof RangeProof
+		err := proof.FromURLArgs(table.start, table.end, table.size)
+		if table.want != nil {
+			if err != nil {
+				t.Errorf("test %s: %v", table.desc, err)
+			} else if proof != *table.want {
+				t.Errorf("test %s: got %v, want %v", table.desc, proof, *table.want)
+			}
+		} else if err == nil {
+			t.Errorf("test %s: expected err, got result %v", table.desc, proof)
+		} else if !strings.Contains(err.Error(), table.wantErr) {
+			t.Errorf("test %s: expected err %q, got %v", table.desc, table.wantErr, err)
+		}
+	}
+}
+
 func validLeaf(t *testing.T) *Leaf {
 	t.Helper()
 	return &Leaf{
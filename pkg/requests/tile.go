@@ -0,0 +1,110 @@
+package requests
+
+import (
+	"fmt"
+	"strings"
+
+	"sigsum.org/sigsum-go/pkg/ascii"
+	"sigsum.org/sigsum-go/pkg/types"
+)
+
+const (
+	// Maximum tile level allowed by the tlog-tiles spec.
+	maxTileLevel = 63
+)
+
+// Identifies a tile of hashes. Level L tiles hold hashes of nodes at
+// level L * types.TileHeight in the tree.
+type Tile struct {
+	Level uint64
+	Index uint64
+	// Number of hashes, between 1 and types.TileWidth. A width
+	// less than types.TileWidth means a partial tile.
+	Width uint64
+}
+
+// Identifies a bundle of leaves, starting at leaf index Index *
+// types.TileWidth.
+type EntryBundle struct {
+	Index uint64
+	// Number of leaves, between 1 and types.TileWidth.
+	Width uint64
+}
+
+// Encodes tile index and width as a path, e.g., index 1234067 and
+// width 17 is encoded as "x001/x234/067.p/17".
+func tilePath(index, width uint64) string {
+	s := fmt.Sprintf("%03d", index%1000)
+	for index >= 1000 {
+		index /= 1000
+		s = fmt.Sprintf("x%03d/%s", index%1000, s)
+	}
+	if width < types.TileWidth {
+		s += fmt.Sprintf(".p/%d", width)
+	}
+	return s
+}
+
+func parseTilePath(path string) (index, width uint64, err error) {
+	width = types.TileWidth
+	if p, w, found := strings.Cut(path, ".p/"); found {
+		width, err = ascii.IntFromDecimal(w)
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid partial tile width %q: %v", w, err)
+		}
+		if width == 0 || width >= types.TileWidth {
+			return 0, 0, fmt.Errorf("partial tile width %d out of range", width)
+		}
+		path = p
+	}
+	elements := strings.Split(path, "/")
+	if len(elements) > 7 {
+		return 0, 0, fmt.Errorf("invalid tile index, too many path elements")
+	}
+	for i, e := range elements {
+		if i < len(elements)-1 {
+			var found bool
+			if e, found = strings.CutPrefix(e, "x"); !found {
+				return 0, 0, fmt.Errorf("invalid tile index element %q", e)
+			}
+			if i == 0 && e == "000" {
+				return 0, 0, fmt.Errorf("invalid tile index, leading zero element")
+			}
+		}
+		if len(e) != 3 || strings.Trim(e, "0123456789") != "" {
+			return 0, 0, fmt.Errorf("invalid tile index element %q", e)
+		}
+		d := uint64((e[0]-'0'))*100 + uint64(e[1]-'0')*10 + uint64(e[2]-'0')
+		if index > (^uint64(0)-d)/1000 {
+			return 0, 0, fmt.Errorf("tile index out of range")
+		}
+		index = index*1000 + d
+	}
+	return index, width, nil
+}
+
+// ToURL encodes request parameters at the end of a slash-terminated URL
+func (req *Tile) ToURL(url string) string {
+	return url + fmt.Sprintf("%d/%s", req.Level, tilePath(req.Index, req.Width))
+}
+
+// ToURL encodes request parameters at the end of a slash-terminated URL
+func (req *EntryBundle) ToURL(url string) string {
+	return url + tilePath(req.Index, req.Width)
+}
+
+func (req *Tile) FromURLArgs(level, path string) (err error) {
+	if req.Level, err = ascii.IntFromDecimal(level); err != nil {
+		return err
+	}
+	if req.Level > maxTileLevel {
+		return fmt.Errorf("tile level %d out of range", req.Level)
+	}
+	req.Index, req.Width, err = parseTilePath(path)
+	return err
+}
+
+func (req *EntryBundle) FromURLArgs(path string) (err error) {
+	req.Index, req.Width, err = parseTilePath(path)
+	return err
+}
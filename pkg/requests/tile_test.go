@@ -0,0 +1,71 @@
+package requests
+
+import (
+	"testing"
+
+	"sigsum.org/sigsum-go/pkg/types"
+)
+
+func TestTileToURL(t *testing.T) {
+	url := types.EndpointTile.Path("https://poc.sigsum.org")
+	for _, table := range []struct {
+		req  Tile
+		want string
+	}{
+		{Tile{0, 0, 256}, "0/000"},
+		{Tile{1, 5, 17}, "1/005.p/17"},
+		{Tile{2, 1234067, 256}, "2/x001/x234/067"},
+		{Tile{0, 1000, 1}, "0/x001/000.p/1"},
+	} {
+		if got, want := table.req.ToURL(url), url+table.want; got != want {
+			t.Errorf("got url %s but wanted %s", got, want)
+		}
+	}
+}
+
+func TestEntryBundleToURL(t *testing.T) {
+	url := types.EndpointEntryBundle.Path("https://poc.sigsum.org")
+	req := EntryBundle{1234067, 17}
+	want := url + "x001/x234/067.p/17"
+	if got := req.ToURL(url); got != want {
+		t.Errorf("got url %s but wanted %s", got, want)
+	}
+}
+
+func TestTileFromURLArgs(t *testing.T) {
+	for _, table := range []struct {
+		level, path string
+		want        *Tile // nil if error expected
+	}{
+		{"0", "000", &Tile{0, 0, 256}},
+		{"3", "x001/x234/067.p/255", &Tile{3, 1234067, 255}},
+		{"63", "999", &Tile{63, 999, 256}},
+		{"64", "000", nil},
+		{"01", "000", nil},
+		{"0", "00", nil},
+		{"0", "0000", nil},
+		{"0", "1a3", nil},
+		{"0", "x000/001", nil},
+		{"0", "001/002", nil},
+		{"0", "x001", nil},
+		{"0", "000.p/0", nil},
+		{"0", "000.p/256", nil},
+		{"0", "000.p/017", nil},
+		{"0", "x018/x446/x744/x073/x709/x551/616", nil},
+		{"0", "x018/x446/x744/x073/x709/x551/615", &Tile{0, 18446744073709551615, 256}},
+	} {
+		var req Tile
+		err := req.FromURLArgs(table.level, table.path)
+		if table.want == nil {
+			if err == nil {
+				t.Errorf("no error for level %q, path %q", table.level, table.path)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("failed for level %q, path %q: %v", table.level, table.path, err)
+		} else if req != *table.want {
+			t.Errorf("got %v, want %v, for level %q, path %q", req, *table.want, table.level, table.path)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package secondary
+
+import (
+	"context"
+	"fmt"
+
+	"sigsum.org/sigsum-go/pkg/api"
+	"sigsum.org/sigsum-go/pkg/crypto"
+	"sigsum.org/sigsum-go/pkg/requests"
+	"sigsum.org/sigsum-go/pkg/types"
+)
+
+// Source of consistency proofs for the primary's tree, including
+// sequenced leaves not yet published.
+type ProofSource interface {
+	GetConsistencyProof(context.Context, requests.ConsistencyProof) (types.ConsistencyProof, error)
+}
+
+// A Checker is used by the primary node, to find out how much of its
+// tree has been replicated by the secondary.
+type Checker struct {
+	secondary api.Secondary
+	publicKey crypto.PublicKey
+}
+
+// Creates a checker for the secondary, e.g., a client.Client for the
+// secondary's get-secondary-tree-head endpoint, with the given public
+// key.
+func NewChecker(secondary api.Secondary, publicKey *crypto.PublicKey) *Checker {
+	return &Checker{secondary: secondary, publicKey: *publicKey}
+}
+
+// Returns the secondary's latest tree head, after checking its
+// signature, and that it is consistent with the primary's tree head
+// th. Since the secondary follows the primary, its tree can't be
+// larger than the primary's, and the returned tree head represents
+// the part of the primary's tree that has been replicated.
+func (c *Checker) ConfirmedTreeHead(ctx context.Context, proofs ProofSource, th *types.TreeHead) (types.TreeHead, error) {
+	sth, err := c.secondary.GetSecondaryTreeHead(ctx)
+	if err != nil {
+		return types.TreeHead{}, fmt.Errorf("get-secondary-tree-head failed: %v", err)
+	}
+	if !sth.Verify(&c.publicKey) {
+		return types.TreeHead{}, fmt.Errorf("invalid signature on secondary tree head")
+	}
+	if sth.Size > th.Size {
+		return types.TreeHead{}, fmt.Errorf("secondary tree size %d larger than primary tree size %d",
+			sth.Size, th.Size)
+	}
+	var proof types.ConsistencyProof
+	if sth.Size > 0 && sth.Size < th.Size {
+		proof, err = proofs.GetConsistencyProof(ctx, requests.ConsistencyProof{OldSize: sth.Size, NewSize: th.Size})
+		if err != nil {
+			return types.TreeHead{}, fmt.Errorf("getting consistency proof from size %d failed: %v", sth.Size, err)
+		}
+	}
+	if err := proof.Verify(&sth.TreeHead, th); err != nil {
+		return types.TreeHead{}, fmt.Errorf("secondary tree head of size %d inconsistent with primary: %v", sth.Size, err)
+	}
+	return sth.TreeHead, nil
+}
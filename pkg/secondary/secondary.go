@@ -0,0 +1,172 @@
+// Package secondary implements replication of a Sigsum log, from a
+// primary node to a secondary node. The secondary follows the
+// primary's sequenced leaves, using get-leaves requests to the
+// primary's internal endpoint (see server.NewGetLeavesServer and
+// logserver.Log.GetSequencedLeaves), rebuilds the Merkle tree, and
+// signs tree heads of its own, served using
+// server.NewSecondary. The primary uses a Checker, to publish only
+// tree heads that have been replicated by the secondary.
+//
+// The secondary keeps its copy of the log in memory only; after a
+// restart, all leaves are fetched again from the primary.
+package secondary
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"sigsum.org/sigsum-go/pkg/api"
+	"sigsum.org/sigsum-go/pkg/crypto"
+	"sigsum.org/sigsum-go/pkg/log"
+	"sigsum.org/sigsum-go/pkg/merkle"
+	"sigsum.org/sigsum-go/pkg/requests"
+	"sigsum.org/sigsum-go/pkg/types"
+)
+
+const (
+	DefaultInterval = 10 * time.Second
+	// Maximum number of leaves requested by a single get-leaves
+	// request.
+	DefaultBatchSize = 512
+)
+
+type Config struct {
+	// Signer for the secondary's tree heads. This key must be
+	// different from the primary's key.
+	Signer crypto.Signer
+	// Interval between synchronizations with the primary. Zero
+	// implies a default interval.
+	Interval time.Duration
+	// Maximum number of leaves to request at a time. Zero implies
+	// a default.
+	BatchSize uint64
+}
+
+func (c *Config) withDefaults() Config {
+	config := *c
+	if config.Interval <= 0 {
+		config.Interval = DefaultInterval
+	}
+	if config.BatchSize == 0 {
+		config.BatchSize = DefaultBatchSize
+	}
+	return config
+}
+
+// Implements api.Secondary.
+type Secondary struct {
+	config Config
+	// Retrieves the primary's sequenced leaves. Must fail with
+	// api.ErrNotFound for a start index at the end of the
+	// primary's tree.
+	getLeaves func(context.Context, requests.Leaves) ([]types.Leaf, error)
+
+	// Serializes calls to Sync, and protects the tree.
+	syncing sync.Mutex
+	tree    merkle.Tree
+
+	// Protects sth.
+	m   sync.RWMutex
+	sth types.SignedTreeHead
+}
+
+// Creates a new secondary, starting out with a signed empty tree
+// head. The getLeaves function retrieves leaves from the primary,
+// e.g., the GetLeaves method of a client.Client for the primary's
+// internal endpoint.
+func New(c *Config, getLeaves func(context.Context, requests.Leaves) ([]types.Leaf, error)) (*Secondary, error) {
+	if c.Signer == nil {
+		return nil, fmt.Errorf("no signer configured")
+	}
+	s := &Secondary{
+		config:    c.withDefaults(),
+		getLeaves: getLeaves,
+		tree:      merkle.NewTree(),
+	}
+	if err := s.sign(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Sync retrieves all new leaves from the primary, appends them to the
+// tree, and signs a new tree head. If retrieving leaves fails, a tree
+// head for the leaves retrieved so far is signed before the error is
+// returned.
+func (s *Secondary) Sync(ctx context.Context) error {
+	s.syncing.Lock()
+	defer s.syncing.Unlock()
+
+	oldSize := s.tree.Size()
+	err := s.fetchLeaves(ctx)
+	if s.tree.Size() > oldSize {
+		log.Debug("Secondary replicated %d new leaves, tree size %d", s.tree.Size()-oldSize, s.tree.Size())
+		if err := s.sign(); err != nil {
+			return err
+		}
+	}
+	return err
+}
+
+func (s *Secondary) fetchLeaves(ctx context.Context) error {
+	for {
+		size := s.tree.Size()
+		leaves, err := s.getLeaves(ctx, requests.Leaves{StartIndex: size, EndIndex: size + s.config.BatchSize})
+		if errors.Is(err, api.ErrNotFound) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("get-leaves from primary failed: %v", err)
+		}
+		if len(leaves) == 0 || uint64(len(leaves)) > s.config.BatchSize {
+			return fmt.Errorf("unexpected number of leaves from primary, got %d, requested %d",
+				len(leaves), s.config.BatchSize)
+		}
+		for i, leaf := range leaves {
+			h := leaf.ToHash()
+			if !s.tree.AddLeafHash(&h) {
+				return fmt.Errorf("primary returned duplicate leaf at index %d", size+uint64(i))
+			}
+		}
+	}
+}
+
+// Signs a tree head for the current tree. Must be called with the
+// syncing lock held, or during initialization.
+func (s *Secondary) sign() error {
+	th := types.TreeHead{Size: s.tree.Size(), RootHash: s.tree.GetRootHash()}
+	sth, err := th.Sign(s.config.Signer)
+	if err != nil {
+		return err
+	}
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.sth = sth
+	return nil
+}
+
+// Run calls Sync immediately, and then at the configured interval,
+// until the context is cancelled.
+func (s *Secondary) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.config.Interval)
+	defer ticker.Stop()
+	for {
+		if err := s.Sync(ctx); err != nil {
+			log.Error("Replicating leaves from primary failed: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Secondary) GetSecondaryTreeHead(_ context.Context) (types.SignedTreeHead, error) {
+	s.m.RLock()
+	defer s.m.RUnlock()
+	return s.sth, nil
+}
@@ -0,0 +1,171 @@
+package secondary_test
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+
+	"sigsum.org/sigsum-go/pkg/api"
+	"sigsum.org/sigsum-go/pkg/client"
+	"sigsum.org/sigsum-go/pkg/crypto"
+	"sigsum.org/sigsum-go/pkg/logserver"
+	"sigsum.org/sigsum-go/pkg/merkle"
+	"sigsum.org/sigsum-go/pkg/requests"
+	"sigsum.org/sigsum-go/pkg/secondary"
+	"sigsum.org/sigsum-go/pkg/server"
+	"sigsum.org/sigsum-go/pkg/types"
+)
+
+func newLeaves(t *testing.T, first, n int) []types.Leaf {
+	t.Helper()
+	signer := crypto.NewEd25519Signer(&crypto.PrivateKey{2})
+	var leaves []types.Leaf
+	for i := first; i < first+n; i++ {
+		msg := crypto.Hash{byte(i)}
+		signature, err := types.SignLeafMessage(signer, msg[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		leaf, err := (&requests.Leaf{Message: msg, Signature: signature, PublicKey: signer.Public()}).Verify()
+		if err != nil {
+			t.Fatal(err)
+		}
+		leaves = append(leaves, leaf)
+	}
+	return leaves
+}
+
+func TestSync(t *testing.T) {
+	ctx := context.Background()
+	signer := crypto.NewEd25519Signer(&crypto.PrivateKey{3})
+	var primaryLeaves []types.Leaf
+	requestCount := 0
+	s, err := secondary.New(&secondary.Config{Signer: signer, BatchSize: 2},
+		func(_ context.Context, req requests.Leaves) ([]types.Leaf, error) {
+			requestCount++
+			if req.StartIndex >= uint64(len(primaryLeaves)) {
+				return nil, api.ErrNotFound
+			}
+			return primaryLeaves[req.StartIndex:min(req.EndIndex, uint64(len(primaryLeaves)))], nil
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, n := range []int{0, 5, 1, 0} {
+		primaryLeaves = append(primaryLeaves, newLeaves(t, len(primaryLeaves), n)...)
+		requestCount = 0
+		if err := s.Sync(ctx); err != nil {
+			t.Fatalf("Sync failed: %v", err)
+		}
+		if got, want := requestCount, (n+1)/2+1; got != want {
+			t.Errorf("unexpected number of get-leaves requests, got %d, want %d", got, want)
+		}
+		tree := merkle.NewTree()
+		for _, leaf := range primaryLeaves {
+			h := leaf.ToHash()
+			tree.AddLeafHash(&h)
+		}
+		sth, err := s.GetSecondaryTreeHead(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pub := signer.Public()
+		if !sth.Verify(&pub) {
+			t.Errorf("invalid signature on secondary tree head")
+		}
+		if want := (types.TreeHead{Size: tree.Size(), RootHash: tree.GetRootHash()}); sth.TreeHead != want {
+			t.Errorf("unexpected secondary tree head, got %v, want %v", sth.TreeHead, want)
+		}
+	}
+}
+
+func TestReplication(t *testing.T) {
+	ctx := context.Background()
+	primarySigner := crypto.NewEd25519Signer(&crypto.PrivateKey{1})
+	secondarySigner := crypto.NewEd25519Signer(&crypto.PrivateKey{3})
+
+	// Client for the primary's internal endpoint, created
+	// when the primary is up.
+	var internal *client.Client
+	s, err := secondary.New(&secondary.Config{Signer: secondarySigner},
+		func(ctx context.Context, req requests.Leaves) ([]types.Leaf, error) {
+			return internal.GetLeaves(ctx, req)
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	secondaryServer := httptest.NewServer(server.NewSecondary(&server.Config{}, s))
+	defer secondaryServer.Close()
+
+	secondaryKey := secondarySigner.Public()
+	primary, err := logserver.New(&logserver.Config{
+		Signer:    primarySigner,
+		Secondary: secondary.NewChecker(client.New(client.Config{URL: secondaryServer.URL}), &secondaryKey),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer primary.Close()
+	internalServer := httptest.NewServer(server.NewGetLeavesServer(&server.Config{}, primary.GetSequencedLeaves))
+	defer internalServer.Close()
+	internal = client.New(client.Config{URL: internalServer.URL})
+
+	submitSigner := crypto.NewEd25519Signer(&crypto.PrivateKey{2})
+	add := func(first, n int) {
+		t.Helper()
+		for i := first; i < first+n; i++ {
+			msg := crypto.Hash{byte(i)}
+			signature, err := types.SignLeafMessage(submitSigner, msg[:])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := primary.AddLeaf(ctx, requests.Leaf{Message: msg, Signature: signature, PublicKey: submitSigner.Public()}, nil); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	publish := func(wantSize uint64) {
+		t.Helper()
+		if err := primary.Publish(ctx); err != nil {
+			t.Fatalf("Publish failed: %v", err)
+		}
+		cth, err := primary.GetTreeHead(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cth.Size != wantSize {
+			t.Errorf("unexpected published tree size %d, want %d", cth.Size, wantSize)
+		}
+		pub := primarySigner.Public()
+		if !cth.Verify(&pub) {
+			t.Errorf("invalid signature on published tree head")
+		}
+	}
+
+	add(0, 3)
+	// Secondary hasn't replicated anything.
+	publish(0)
+	if err := s.Sync(ctx); err != nil {
+		t.Fatal(err)
+	}
+	add(3, 2)
+	// Secondary has replicated the first 3 leaves.
+	publish(3)
+	if err := s.Sync(ctx); err != nil {
+		t.Fatal(err)
+	}
+	publish(5)
+
+	// A checker with the wrong key rejects the secondary's tree
+	// heads.
+	wrongKey := primarySigner.Public()
+	checker := secondary.NewChecker(s, &wrongKey)
+	if _, err := checker.ConfirmedTreeHead(ctx, primary, &types.TreeHead{Size: 5}); err == nil {
+		t.Errorf("secondary tree head with invalid signature accepted")
+	}
+	// The secondary's tree head must match the primary's tree.
+	checker = secondary.NewChecker(s, &secondaryKey)
+	if _, err := checker.ConfirmedTreeHead(ctx, primary, &types.TreeHead{Size: 5}); err == nil {
+		t.Errorf("inconsistent secondary tree head accepted")
+	}
+}
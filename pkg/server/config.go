@@ -1,11 +1,16 @@
 package server
 
 import (
+	"fmt"
 	"time"
 )
 
 const (
 	defaultTimeout = 30 * time.Second
+	defaultMaxWait = 20 * time.Second
+
+	defaultTreeHeadMaxAge  = 5 * time.Second
+	defaultImmutableMaxAge = 365 * 24 * time.Hour
 )
 
 type Metrics interface {
@@ -21,7 +26,50 @@ func (_ noMetrics) OnResponse(_ string, _ int, _ time.Duration) {}
 type Config struct {
 	Prefix  string
 	Timeout time.Duration
+	// Maximum time a get-tree-head request may wait for the log
+	// to grow, see api.Log.WaitTreeHead. Zero implies a default,
+	// which is less than the Timeout.
+	MaxWait time.Duration
 	Metrics Metrics
+	// Caching policy for log responses. If nil, no Cache-Control
+	// headers are sent. ETag headers are sent regardless, and
+	// If-None-Match requests are supported.
+	Cache *CachePolicy
+}
+
+// Caching policy, for the benefit of an HTTP cache in front of a log.
+// Inclusion and consistency proofs are immutable, and so are
+// get-leaves responses including all requested leaves. Other
+// successful responses, i.e., tree heads and get-leaves responses
+// including fewer leaves than requested, may change when the log
+// publishes a new tree head.
+type CachePolicy struct {
+	// Max-age for responses that may change. Zero implies a
+	// default of a few seconds.
+	TreeHeadMaxAge time.Duration
+	// Max-age for immutable responses. Zero implies a default of
+	// one year.
+	ImmutableMaxAge time.Duration
+}
+
+// Returns the Cache-Control header value for a response, or empty
+// string if no header should be sent.
+func (c *CachePolicy) cacheControl(immutable bool) string {
+	if c == nil {
+		return ""
+	}
+	if immutable {
+		maxAge := c.ImmutableMaxAge
+		if maxAge <= 0 {
+			maxAge = defaultImmutableMaxAge
+		}
+		return fmt.Sprintf("public, max-age=%d, immutable", int64(maxAge/time.Second))
+	}
+	maxAge := c.TreeHeadMaxAge
+	if maxAge <= 0 {
+		maxAge = defaultTreeHeadMaxAge
+	}
+	return fmt.Sprintf("public, max-age=%d", int64(maxAge/time.Second))
 }
 
 func (c *Config) withDefaults() Config {
@@ -29,6 +77,9 @@ func (c *Config) withDefaults() Config {
 	if config.Timeout == 0 {
 		config.Timeout = defaultTimeout
 	}
+	if config.MaxWait == 0 {
+		config.MaxWait = min(defaultMaxWait, config.Timeout/2)
+	}
 	if config.Metrics == nil {
 		config.Metrics = noMetrics{}
 	}
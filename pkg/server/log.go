@@ -3,6 +3,7 @@ package server
 import (
 	"context"
 	"fmt"
+	"io"
 	"net/http"
 
 	"sigsum.org/sigsum-go/pkg/api"
@@ -32,13 +33,14 @@ func newGetLeavesServer(config *Config, getLeaves func(context.Context, requests
 				reportError(w, r.URL, err)
 				return
 			}
-			if got, max := uint64(len(leaves)), req.EndIndex-req.StartIndex; got == 0 || got > max {
+			got, max := uint64(len(leaves)), req.EndIndex-req.StartIndex
+			if got == 0 || got > max {
 				reportError(w, r.URL, fmt.Errorf("bad leaf count %d, should have 0 < count <= %d", got, max))
 				return
 			}
-			if err := types.LeavesToASCII(w, leaves); err != nil {
-				logError(r.URL, err)
-			}
+			server.writeCacheable(w, r, got == max, func(w io.Writer) error {
+				return types.LeavesToASCII(w, leaves)
+			})
 		}))
 	return server
 }
@@ -53,6 +55,19 @@ func NewGetLeavesServer(config *Config, getLeaves func(context.Context, requests
 // possibly without knowledge of the log's current state. In
 // particular, requests for trivial inclusion and consistency proofs
 // are rejected, and not passed on to the underlying api.Log.
+//
+// In addition to the plain get-tree-head endpoint, the handler
+// supports get-tree-head/<size>, which waits until the log has a
+// tree head larger than <size>, but at most config.MaxWait, and then
+// responds with the log's current tree head.
+//
+// If the log implements api.RangeProver, the handler also supports
+// get-range-proof/<start>/<end>/<size>.
+//
+// Responses to get-tree-head (including waiting requests),
+// get-inclusion-proof, get-consistency-proof, get-range-proof and
+// get-leaves requests include an ETag header, and caching headers as
+// configured by config.Cache.
 func NewLog(config *Config, log api.Log) http.Handler {
 	server := newGetLeavesServer(config, log.GetLeaves)
 	server.register(http.MethodGet, types.EndpointGetTreeHead, "",
@@ -62,9 +77,34 @@ func NewLog(config *Config, log api.Log) http.Handler {
 				reportError(w, r.URL, err)
 				return
 			}
-			if err = cth.ToASCII(w); err != nil {
-				logError(r.URL, err)
+			server.writeCacheable(w, r, false, cth.ToASCII)
+		}))
+	server.register(http.MethodGet, types.EndpointGetTreeHead, "/{size}",
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var req requests.TreeHead
+			if err := req.FromURLArgs(r.PathValue("size")); err != nil {
+				reportError(w, r.URL, api.ErrBadRequest.WithError(err))
+				return
+			}
+			ctx, cancel := context.WithTimeout(r.Context(), server.config.MaxWait)
+			defer cancel()
+			cth, err := log.WaitTreeHead(ctx, req)
+			if err != nil && r.Context().Err() != nil {
+				// Client disconnected, or the
+				// request was cancelled.
+				logError(r.URL, r.Context().Err())
+				return
+			}
+			if err != nil && ctx.Err() != nil {
+				// No larger tree head within the wait
+				// limit, respond with the current one.
+				cth, err = log.GetTreeHead(r.Context())
+			}
+			if err != nil {
+				reportError(w, r.URL, err)
+				return
 			}
+			server.writeCacheable(w, r, false, cth.ToASCII)
 		}))
 	server.register(http.MethodGet, types.EndpointGetInclusionProof, "", handlerBadRequest)
 	server.register(http.MethodGet, types.EndpointGetInclusionProof, "{size}/{hash}",
@@ -87,9 +127,7 @@ func NewLog(config *Config, log api.Log) http.Handler {
 				reportError(w, r.URL, err)
 				return
 			}
-			if err := proof.ToASCII(w); err != nil {
-				logError(r.URL, err)
-			}
+			server.writeCacheable(w, r, true, proof.ToASCII)
 		}))
 	server.register(http.MethodGet, types.EndpointGetConsistencyProof, "", handlerBadRequest)
 	server.register(http.MethodGet, types.EndpointGetConsistencyProof, "{old}/{new}",
@@ -116,10 +154,37 @@ func NewLog(config *Config, log api.Log) http.Handler {
 				reportError(w, r.URL, err)
 				return
 			}
-			if err := proof.ToASCII(w); err != nil {
-				logError(r.URL, err)
-			}
+			server.writeCacheable(w, r, true, proof.ToASCII)
 		}))
+	if prover, ok := log.(api.RangeProver); ok {
+		server.register(http.MethodGet, types.EndpointGetRangeProof, "", handlerBadRequest)
+		server.register(http.MethodGet, types.EndpointGetRangeProof, "{start}/{end}/{size}",
+			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				var req requests.RangeProof
+				if err := req.FromURLArgs(r.PathValue("start"), r.PathValue("end"), r.PathValue("size")); err != nil {
+					reportError(w, r.URL, api.ErrBadRequest.WithError(err))
+					return
+				}
+				if req.StartIndex >= req.EndIndex || req.EndIndex > req.Size {
+					reportError(w, r.URL, api.ErrBadRequest.WithError(
+						fmt.Errorf("start_index(%d) must be less than end_index(%d), which must not exceed size(%d)",
+							req.StartIndex, req.EndIndex, req.Size)))
+					return
+				}
+				if req.StartIndex == 0 && req.EndIndex == req.Size {
+					// The proof for the complete tree is always empty.
+					reportError(w, r.URL, api.ErrBadRequest.WithError(
+						fmt.Errorf("range must not cover the complete tree")))
+					return
+				}
+				proof, err := prover.GetRangeProof(r.Context(), req)
+				if err != nil {
+					reportError(w, r.URL, err)
+					return
+				}
+				server.writeCacheable(w, r, true, proof.ToASCII)
+			}))
+	}
 	server.register(http.MethodPost, types.EndpointAddLeaf, "",
 		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			var req requests.Leaf
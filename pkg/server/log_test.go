@@ -1,9 +1,11 @@
 package server
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"net/http"
+	"strings"
 	"testing"
 	"time"
 
@@ -53,6 +55,89 @@ func TestGetTreeHead(t *testing.T) {
 	}
 }
 
+func TestWaitTreeHead(t *testing.T) {
+	cth := types.CosignedTreeHead{
+		SignedTreeHead: types.SignedTreeHead{
+			TreeHead: types.TreeHead{
+				Size:     3,
+				RootHash: crypto.Hash{1},
+			},
+			Signature: crypto.Signature{2},
+		},
+	}
+	for _, table := range []struct {
+		url     string
+		req     *requests.TreeHead
+		timeout bool // If WaitTreeHead should wait until timeout.
+		status  int
+	}{
+		{url: "/foo/get-tree-head/", status: 404},
+		{url: "/foo/get-tree-head/x", status: 400},
+		{url: "/foo/get-tree-head/2/3", status: 404},
+		{url: "/foo/get-tree-head/2", req: &requests.TreeHead{OldSize: 2}, status: 200},
+		{url: "/foo/get-tree-head/3", req: &requests.TreeHead{OldSize: 3}, timeout: true, status: 200},
+	} {
+		func() {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			log := mocks.NewMockLog(ctrl)
+
+			config := Config{Prefix: "foo", Timeout: 5 * time.Minute, MaxWait: 10 * time.Millisecond}
+			server := NewLog(&config, log)
+
+			if table.req != nil {
+				if table.timeout {
+					log.EXPECT().WaitTreeHead(gomock.Any(), *table.req).DoAndReturn(
+						func(ctx context.Context, _ requests.TreeHead) (types.CosignedTreeHead, error) {
+							<-ctx.Done()
+							return types.CosignedTreeHead{}, ctx.Err()
+						})
+					log.EXPECT().GetTreeHead(gomock.Any()).Return(cth, nil)
+				} else {
+					log.EXPECT().WaitTreeHead(gomock.Any(), *table.req).Return(cth, nil)
+				}
+			}
+			result, body := queryServer(t, server, http.MethodGet, table.url, "")
+
+			if got, want := result.StatusCode, table.status; got != want {
+				t.Errorf("Unexpected status code for %q, got %d, want %d", table.url, got, want)
+			}
+			if table.status != 200 {
+				return
+			}
+			if got, want := body, writeFuncToString(t, cth.ToASCII); got != want {
+				t.Errorf("Unexpected response for %q, got %q, want %q", table.url, got, want)
+			}
+		}()
+	}
+}
+
+func TestWaitTreeHeadCancel(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	log := mocks.NewMockLog(ctrl)
+
+	config := Config{Timeout: 5 * time.Minute, MaxWait: time.Minute}
+	server := NewLog(&config, log)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	log.EXPECT().WaitTreeHead(gomock.Any(), requests.TreeHead{OldSize: 3}).DoAndReturn(
+		func(ctx context.Context, _ requests.TreeHead) (types.CosignedTreeHead, error) {
+			// Client goes away while waiting.
+			cancel()
+			<-ctx.Done()
+			return types.CosignedTreeHead{}, ctx.Err()
+		})
+	// No GetTreeHead call expected, and no error response.
+	result, body := queryServerHook(t, server, http.MethodGet, "/get-tree-head/3", "",
+		func(req *http.Request) *http.Request {
+			return req.WithContext(ctx)
+		})
+	if result.StatusCode == http.StatusInternalServerError || len(body) > 0 {
+		t.Errorf("Unexpected response for cancelled request, status %d, body %q", result.StatusCode, body)
+	}
+}
+
 func TestGetInclusionProof(t *testing.T) {
 	req := requests.InclusionProof{
 		Size: 2,
@@ -180,6 +265,93 @@ func TestGetConsistencyProof(t *testing.T) {
 	}
 }
 
+// A log that also implements api.RangeProver.
+type rangeProvingLog struct {
+	*mocks.MockLog
+	getRangeProof func(requests.RangeProof) (types.RangeProof, error)
+}
+
+func (l rangeProvingLog) GetRangeProof(_ context.Context, req requests.RangeProof) (types.RangeProof, error) {
+	return l.getRangeProof(req)
+}
+
+func TestGetRangeProof(t *testing.T) {
+	req := requests.RangeProof{
+		StartIndex: 2,
+		EndIndex:   4,
+		Size:       5,
+	}
+
+	proof := types.RangeProof{
+		Path: []crypto.Hash{crypto.Hash{2}, crypto.Hash{3}},
+	}
+
+	for _, table := range []struct {
+		url    string
+		req    *requests.RangeProof
+		status int
+		err    error
+	}{
+		{url: "/foo/get-range-proof/", status: 400},
+		{url: "/foo/get-range-proof/2/4", status: 400},
+		{url: "/foo/get-range-proof/2/4/x", status: 400},
+		{url: "/foo/get-range-proof/2/4/5",
+			req:    &req,
+			status: 200,
+		},
+		{url: "/foo/get-range-proof/2/4/5",
+			req:    &req,
+			status: 403, // Arbitrary error
+			err:    api.ErrForbidden,
+		},
+		{url: "/foo/get-range-proof/2/2/5", status: 400},
+		{url: "/foo/get-range-proof/2/6/5", status: 400},
+		{url: "/foo/get-range-proof/0/5/5", status: 400},
+	} {
+		func() {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			called := false
+			log := rangeProvingLog{
+				MockLog: mocks.NewMockLog(ctrl),
+				getRangeProof: func(got requests.RangeProof) (types.RangeProof, error) {
+					called = true
+					if table.req == nil || got != *table.req {
+						t.Errorf("Unexpected request for %q: %v", table.url, got)
+					}
+					return proof, table.err
+				},
+			}
+
+			config := Config{Prefix: "foo", Timeout: 5 * time.Minute}
+			server := NewLog(&config, log)
+
+			result, body := queryServer(t, server, http.MethodGet, table.url, "")
+
+			if got, want := result.StatusCode, table.status; got != want {
+				t.Errorf("Unexpected status code for %q, got %d, want %d", table.url, got, want)
+			}
+			if got, want := called, table.req != nil; got != want {
+				t.Errorf("Unexpected GetRangeProof call for %q: %v", table.url, got)
+			}
+			if table.status != 200 {
+				return
+			}
+			if got, want := body, writeFuncToString(t, proof.ToASCII); got != want {
+				t.Errorf("Unexpected response for %q, got %q, want %q", table.url, got, want)
+			}
+		}()
+	}
+
+	// A log without range proof support.
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	server := NewLog(&Config{}, mocks.NewMockLog(ctrl))
+	if result, _ := queryServer(t, server, http.MethodGet, "/get-range-proof/2/4/5", ""); result.StatusCode != http.StatusNotFound {
+		t.Errorf("Unexpected status code for unsupported get-range-proof, got %d, want 404", result.StatusCode)
+	}
+}
+
 func TestGetLeaves(t *testing.T) {
 	req := requests.Leaves{StartIndex: 2, EndIndex: 5}
 
@@ -360,3 +532,73 @@ func TestAddLeaf(t *testing.T) {
 		}()
 	}
 }
+
+func TestCaching(t *testing.T) {
+	cth := types.CosignedTreeHead{
+		SignedTreeHead: types.SignedTreeHead{
+			TreeHead: types.TreeHead{Size: 3, RootHash: crypto.Hash{1}},
+		},
+	}
+	for _, table := range []struct {
+		desc             string
+		cache            *CachePolicy
+		url              string
+		expect           func(log *mocks.MockLog)
+		wantCacheControl string
+	}{
+		{"no caching", nil, "/get-tree-head", func(log *mocks.MockLog) {
+			log.EXPECT().GetTreeHead(gomock.Any()).Return(cth, nil).Times(2)
+		}, ""},
+		{"tree head", &CachePolicy{}, "/get-tree-head", func(log *mocks.MockLog) {
+			log.EXPECT().GetTreeHead(gomock.Any()).Return(cth, nil).Times(2)
+		}, "public, max-age=5"},
+		{"waiting tree head", &CachePolicy{}, "/get-tree-head/2", func(log *mocks.MockLog) {
+			log.EXPECT().WaitTreeHead(gomock.Any(), gomock.Any()).Return(cth, nil).Times(2)
+		}, "public, max-age=5"},
+		{"inclusion proof", &CachePolicy{ImmutableMaxAge: time.Hour}, "/get-inclusion-proof/3/" + strings.Repeat("00", 32), func(log *mocks.MockLog) {
+			log.EXPECT().GetInclusionProof(gomock.Any(), gomock.Any()).Return(
+				types.InclusionProof{LeafIndex: 1, Path: []crypto.Hash{{1}}}, nil).Times(2)
+		}, "public, max-age=3600, immutable"},
+		{"consistency proof", &CachePolicy{}, "/get-consistency-proof/2/3", func(log *mocks.MockLog) {
+			log.EXPECT().GetConsistencyProof(gomock.Any(), gomock.Any()).Return(
+				types.ConsistencyProof{Path: []crypto.Hash{{1}}}, nil).Times(2)
+		}, "public, max-age=31536000, immutable"},
+		{"all leaves", &CachePolicy{}, "/get-leaves/2/4", func(log *mocks.MockLog) {
+			log.EXPECT().GetLeaves(gomock.Any(), gomock.Any()).Return(make([]types.Leaf, 2), nil).Times(2)
+		}, "public, max-age=31536000, immutable"},
+		{"partial leaves", &CachePolicy{TreeHeadMaxAge: time.Minute}, "/get-leaves/2/5", func(log *mocks.MockLog) {
+			log.EXPECT().GetLeaves(gomock.Any(), gomock.Any()).Return(make([]types.Leaf, 2), nil).Times(2)
+		}, "public, max-age=60"},
+	} {
+		func() {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			log := mocks.NewMockLog(ctrl)
+			server := NewLog(&Config{Cache: table.cache}, log)
+			table.expect(log)
+
+			result, body := queryServer(t, server, http.MethodGet, table.url, "")
+			if got, want := result.StatusCode, http.StatusOK; got != want {
+				t.Fatalf("%s: unexpected status code, got %d, want %d", table.desc, got, want)
+			}
+			if got, want := result.Header.Get("Cache-Control"), table.wantCacheControl; got != want {
+				t.Errorf("%s: unexpected Cache-Control header, got %q, want %q", table.desc, got, want)
+			}
+			etag := result.Header.Get("ETag")
+			if len(etag) == 0 || len(body) == 0 {
+				t.Fatalf("%s: missing ETag or body", table.desc)
+			}
+			result, body = queryServerHook(t, server, http.MethodGet, table.url, "",
+				func(req *http.Request) *http.Request {
+					req.Header.Set("If-None-Match", "\"other\", W/"+etag)
+					return req
+				})
+			if got, want := result.StatusCode, http.StatusNotModified; got != want {
+				t.Errorf("%s: unexpected status code for conditional request, got %d, want %d", table.desc, got, want)
+			}
+			if len(body) > 0 {
+				t.Errorf("%s: unexpected body for Not Modified response: %q", table.desc, body)
+			}
+		}()
+	}
+}
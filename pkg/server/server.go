@@ -9,12 +9,18 @@
 package server
 
 import (
+	"bytes"
 	"context"
+	"encoding/hex"
+	"io"
 	"net/http"
 	"net/url"
+	"strconv"
+	"strings"
 	"time"
 
 	"sigsum.org/sigsum-go/pkg/api"
+	"sigsum.org/sigsum-go/pkg/crypto"
 	"sigsum.org/sigsum-go/pkg/log"
 	"sigsum.org/sigsum-go/pkg/types"
 )
@@ -87,9 +93,52 @@ func reportError(w http.ResponseWriter, url *url.URL, err error) {
 	} else {
 		log.Debug("%q: status %d, %v", url.Path, statusCode, err)
 	}
+	if retryAfter, ok := api.ErrorRetryAfter(err); ok {
+		// Round up to whole seconds.
+		w.Header().Set("Retry-After", strconv.FormatInt(int64((retryAfter+time.Second-1)/time.Second), 10))
+	}
 	http.Error(w, err.Error(), statusCode)
 }
 
+// Writes a successful response, produced by the write function, with
+// an ETag header derived from the response body, and Cache-Control
+// header according to the configured policy. If the request has a
+// matching If-None-Match header, responds with 304 Not Modified and
+// no body.
+func (s *server) writeCacheable(w http.ResponseWriter, r *http.Request, immutable bool, write func(io.Writer) error) {
+	var buf bytes.Buffer
+	if err := write(&buf); err != nil {
+		reportError(w, r.URL, err)
+		return
+	}
+	h := crypto.HashBytes(buf.Bytes())
+	etag := `"` + hex.EncodeToString(h[:16]) + `"`
+	w.Header().Set("ETag", etag)
+	if cacheControl := s.config.Cache.cacheControl(immutable); len(cacheControl) > 0 {
+		w.Header().Set("Cache-Control", cacheControl)
+	}
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		logError(r.URL, err)
+	}
+}
+
+// Checks if an If-None-Match header value, a comma-separated list of
+// entity tags, matches the etag. Comparison is weak, i.e., any W/
+// prefix is ignored.
+func etagMatches(header, etag string) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
 func logError(url *url.URL, err error) {
 	log.Debug("%q: request failed: %v", url.Path, err)
 }
@@ -167,6 +167,22 @@ func TestPost(t *testing.T) {
 	}
 }
 
+func TestRetryAfter(t *testing.T) {
+	config := Config{Prefix: "foo", Timeout: 5 * time.Minute}
+	server := newServer(&config)
+	server.register(http.MethodGet, "get-x", "",
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			reportError(w, r.URL, api.ErrTooManyRequests.WithRetryAfter(1500*time.Millisecond))
+		}))
+	result, _ := queryServer(t, server, http.MethodGet, "/foo/get-x", "")
+	if got, want := result.StatusCode, http.StatusTooManyRequests; got != want {
+		t.Errorf("Unexpected status code, got %d, want %d", got, want)
+	}
+	if got, want := result.Header.Get("Retry-After"), "2"; got != want {
+		t.Errorf("Unexpected Retry-After header, got %q, want %q", got, want)
+	}
+}
+
 func TestMetrics(t *testing.T) {
 	// If this delay is exceeded, don't fail test, just log a
 	// warning, since we may be delayed due to bad luck in
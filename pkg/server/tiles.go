@@ -0,0 +1,123 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+
+	"sigsum.org/sigsum-go/pkg/api"
+	"sigsum.org/sigsum-go/pkg/checkpoint"
+	"sigsum.org/sigsum-go/pkg/crypto"
+	"sigsum.org/sigsum-go/pkg/requests"
+	"sigsum.org/sigsum-go/pkg/types"
+)
+
+const (
+	contentTypeCheckpoint = "text/plain; charset=utf-8"
+	contentTypeTile       = "application/octet-stream"
+)
+
+// Returns a HTTP handler serving a Sigsum log as static tlog-tiles,
+// see https://github.com/C2SP/C2SP/blob/main/tlog-tiles.md. The log
+// is identified by its public key. Cosignatures on the log's tree
+// head are included as signature lines on the checkpoint, but only
+// for the listed witnesses, since the key name of the witness is
+// needed to produce a cosignature line.
+func NewTileLog(config *Config, logKey *crypto.PublicKey, witnesses []checkpoint.NoteVerifier, log api.TileLog) http.Handler {
+	origin := types.SigsumCheckpointOrigin(logKey)
+	keyId := checkpoint.NewLogKeyId(origin, logKey)
+
+	server := newServer(config)
+	server.register(http.MethodGet, types.EndpointCheckpoint, "",
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			cth, err := log.GetTreeHead(r.Context())
+			if err != nil {
+				reportError(w, r.URL, err)
+				return
+			}
+			cp := checkpoint.Checkpoint{
+				SignedTreeHead: cth.SignedTreeHead,
+				Origin:         origin,
+				KeyId:          keyId,
+			}
+			w.Header().Set("content-type", contentTypeCheckpoint)
+			if err := cp.ToASCII(w); err != nil {
+				logError(r.URL, err)
+				return
+			}
+			for _, wit := range witnesses {
+				cs, ok := cth.Cosignatures[crypto.HashBytes(wit.PublicKey[:])]
+				if !ok {
+					continue
+				}
+				line := checkpoint.CosignatureLine{KeyName: wit.Name, KeyId: wit.KeyId, Cosignature: cs}
+				if err := line.ToASCII(w); err != nil {
+					logError(r.URL, err)
+					return
+				}
+			}
+		}))
+	server.register(http.MethodGet, types.EndpointEntryBundle, "{path...}",
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var req requests.EntryBundle
+			if err := req.FromURLArgs(r.PathValue("path")); err != nil {
+				reportError(w, r.URL, api.ErrNotFound.WithError(err))
+				return
+			}
+			if req.Index > (^uint64(0)-req.Width)/types.TileWidth {
+				reportError(w, r.URL, api.ErrNotFound)
+				return
+			}
+			start := req.Index * types.TileWidth
+			var leaves []types.Leaf
+			for end := start + req.Width; start+uint64(len(leaves)) < end; {
+				batch, err := log.GetLeaves(r.Context(), requests.Leaves{
+					StartIndex: start + uint64(len(leaves)),
+					EndIndex:   end,
+				})
+				if err != nil {
+					// Out of range requests are reported as
+					// not found, as for any static file.
+					if api.ErrorStatusCode(err) == http.StatusBadRequest {
+						err = api.ErrNotFound.WithError(err)
+					}
+					reportError(w, r.URL, err)
+					return
+				}
+				if len(batch) == 0 {
+					reportError(w, r.URL, fmt.Errorf("empty leaf response"))
+					return
+				}
+				leaves = append(leaves, batch...)
+			}
+			if got, want := uint64(len(leaves)), req.Width; got != want {
+				reportError(w, r.URL, fmt.Errorf("bad leaf count %d, expected %d", got, want))
+				return
+			}
+			w.Header().Set("content-type", contentTypeTile)
+			if err := types.LeavesToEntryBundle(w, leaves); err != nil {
+				logError(r.URL, err)
+			}
+		}))
+	server.register(http.MethodGet, types.EndpointTile, "{level}/{path...}",
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var req requests.Tile
+			if err := req.FromURLArgs(r.PathValue("level"), r.PathValue("path")); err != nil {
+				reportError(w, r.URL, api.ErrNotFound.WithError(err))
+				return
+			}
+			hashes, err := log.GetTile(r.Context(), req)
+			if err != nil {
+				reportError(w, r.URL, err)
+				return
+			}
+			if got, want := uint64(len(hashes)), req.Width; got != want {
+				reportError(w, r.URL, fmt.Errorf("bad tile width %d, expected %d", got, want))
+				return
+			}
+			w.Header().Set("content-type", contentTypeTile)
+			if err := types.TileHashesToBinary(w, hashes); err != nil {
+				logError(r.URL, err)
+			}
+		}))
+	return server
+}
@@ -0,0 +1,358 @@
+package submit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"sigsum.org/sigsum-go/pkg/api"
+	"sigsum.org/sigsum-go/pkg/crypto"
+	"sigsum.org/sigsum-go/pkg/log"
+	"sigsum.org/sigsum-go/pkg/policy"
+	"sigsum.org/sigsum-go/pkg/proof"
+	"sigsum.org/sigsum-go/pkg/requests"
+	token "sigsum.org/sigsum-go/pkg/submit-token"
+	"sigsum.org/sigsum-go/pkg/types"
+)
+
+// Sigsum proofs for the same leaf, one per log, from distinct logs,
+// in the order the logs were tried.
+type MultiProof []proof.SigsumProof
+
+// Returns the proof from the log with the given key hash, if any.
+func (m MultiProof) ForLog(logKeyHash *crypto.Hash) (proof.SigsumProof, bool) {
+	for _, pr := range m {
+		if pr.LogKeyHash == *logKeyHash {
+			return pr, true
+		}
+	}
+	return proof.SigsumProof{}, false
+}
+
+// Result of submitting one leaf of a batch. If Err is nil, Proofs
+// includes complete sigsum proofs for the leaf, from the number of
+// logs required by the configuration.
+type BatchResult struct {
+	Proofs MultiProof
+	Err    error
+}
+
+// Submits a batch of leaves. For each log, add-leaf requests for all
+// pending leaves are sent concurrently, and then a single tree head
+// is polled for, with inclusion proofs retrieved for all leaves
+// together. Leaves are submitted to the logs in the policy in turn,
+// until each leaf has proofs from config.RequiredLogs logs; leaves
+// that fail on one log are retried on the next. The PerLogTimeout
+// applies to the complete batch submitted to each log. Returns one
+// result per request, in the same order.
+func SubmitBatch(ctx context.Context, config *Config, reqs []requests.Leaf) []BatchResult {
+	results := make([]BatchResult, len(reqs))
+	leaves := make([]types.Leaf, len(reqs))
+	leafHashes := make([]crypto.Hash, len(reqs))
+	// Indices of leaves without the required number of proofs.
+	var pending []int
+	for i := range reqs {
+		leaf, err := reqs[i].Verify()
+		if err != nil {
+			results[i].Err = fmt.Errorf("verifying leaf request failed: %v", err)
+			continue
+		}
+		leaves[i] = leaf
+		leafHashes[i] = leaf.ToHash()
+		pending = append(pending, i)
+	}
+	setErrors := func(err error) {
+		for _, i := range pending {
+			results[i].Err = err
+		}
+	}
+
+	logs := config.Policy.GetLogsWithUrl()
+	if len(logs) == 0 {
+		setErrors(fmt.Errorf("no logs defined in policy"))
+		return results
+	}
+	required := config.getRequiredLogs()
+	if required > len(logs) {
+		setErrors(fmt.Errorf("%d logs required, but only %d logs in policy", required, len(logs)))
+		return results
+	}
+	for _, entity := range logs {
+		if len(pending) == 0 {
+			break
+		}
+		log.Info("Attempting submit of %d leaves to log: %s", len(pending), entity.URL)
+		header, err := config.getSubmitHeader(&entity)
+		if err != nil {
+			setErrors(err)
+			return results
+		}
+
+		client := config.newLogClient(&entity)
+
+		batchReqs := make([]requests.Leaf, len(pending))
+		batchHashes := make([]crypto.Hash, len(pending))
+		for j, i := range pending {
+			batchReqs[j] = reqs[i]
+			batchHashes[j] = leafHashes[i]
+		}
+		logKeyHash := crypto.HashBytes(entity.PublicKey[:])
+		proofs, errs := func() ([]proof.SigsumProof, []error) {
+			ctx, cancel := context.WithTimeout(ctx, config.getTimeout())
+			defer cancel()
+			return submitBatchToLog(ctx, config.Policy, client, &logKeyHash, header, config.sleep,
+				config.getMaxConcurrentRequests(), batchReqs, batchHashes)
+		}()
+		var incomplete []int
+		for j, i := range pending {
+			if errs[j] != nil {
+				log.Error("Submitting leaf %x to log %q failed: %v", leafHashes[i], entity.URL, errs[j])
+			} else {
+				proofs[j].Leaf = proof.NewShortLeaf(&leaves[i])
+				results[i].Proofs = append(results[i].Proofs, proofs[j])
+			}
+			if len(results[i].Proofs) < required {
+				incomplete = append(incomplete, i)
+			}
+		}
+		pending = incomplete
+	}
+	if required == 1 {
+		setErrors(fmt.Errorf("all logs failed, giving up"))
+	} else {
+		for _, i := range pending {
+			results[i].Err = fmt.Errorf("leaf included in only %d of %d required logs, giving up",
+				len(results[i].Proofs), required)
+		}
+	}
+	return results
+}
+
+// Calls f(i) for 0 <= i < n, with at most maxConcurrent calls running
+// concurrently, and waits for all calls to complete.
+func forEachConcurrently(n, maxConcurrent int, f func(i int)) {
+	sem := make(chan struct{}, maxConcurrent)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer func() { <-sem; wg.Done() }()
+			f(i)
+		}(i)
+	}
+	wg.Wait()
+}
+
+// Submits leaves to a single log, and collects proofs, using a single
+// tree head for all leaves included at the time it is retrieved.
+// Leaves already included in the log's current tree head are not
+// submitted again. While leaves are missing, waits for the log's tree
+// to grow, see api.Log.WaitTreeHead. Tree heads that don't yet
+// satisfy the policy's witness quorum are skipped, and polling
+// continues until the context expires. Returns one proof and one
+// error per leaf, where the proof is valid if the error is nil.
+// Leaves proof.Leaf for the caller to populate.
+func submitBatchToLog(ctx context.Context, p *policy.Policy,
+	cli api.Log, logKeyHash *crypto.Hash, header *token.SubmitHeader, sleep func(context.Context) error,
+	maxConcurrent int, reqs []requests.Leaf, leafHashes []crypto.Hash) ([]proof.SigsumProof, []error) {
+	errs := make([]error, len(reqs))
+	proofs := make([]proof.SigsumProof, len(reqs))
+
+	// Indices of leaves still being processed.
+	pending := make([]int, len(reqs))
+	for i := range pending {
+		pending[i] = i
+	}
+	// Leaves may already be included, e.g., if the proof from an
+	// earlier submission was lost. Then there's no need to spend
+	// rate limit budget on add-leaf requests.
+	pending = findIncludedLeaves(ctx, p, cli, logKeyHash, leafHashes, pending, maxConcurrent, proofs)
+	submitted := pending
+	setErrors := func(err error) {
+		for _, i := range pending {
+			errs[i] = err
+		}
+		pending = nil
+	}
+	// Repeat add-leaf requests until the log reports that all
+	// leaves are persisted.
+	for len(pending) > 0 {
+		persisted := make([]bool, len(pending))
+		forEachConcurrently(len(pending), maxConcurrent, func(j int) {
+			i := pending[j]
+			persisted[j], errs[i] = cli.AddLeaf(ctx, reqs[i], header)
+		})
+		var notPersisted []int
+		for j, i := range pending {
+			if errs[i] == nil && !persisted[j] {
+				notPersisted = append(notPersisted, i)
+			}
+		}
+		if len(notPersisted) == 0 {
+			break
+		}
+		pending = notPersisted
+		log.Debug("Leaves submitted, waiting for %d to be persisted.", len(pending))
+		if err := sleep(ctx); err != nil {
+			setErrors(err)
+		}
+	}
+	pending = nil
+	for _, i := range submitted {
+		if errs[i] == nil {
+			pending = append(pending, i)
+		}
+	}
+	// Leaves submitted, now get a signed tree head + inclusion
+	// proofs.
+	var lastProgress string
+	var cth types.CosignedTreeHead
+	// Set when leaves are missing from the latest tree head.
+	waitForSize := false
+	for len(pending) > 0 {
+		var err error
+		if waitForSize {
+			cth, err = cli.WaitTreeHead(ctx, requests.TreeHead{OldSize: cth.Size})
+		} else {
+			cth, err = cli.GetTreeHead(ctx)
+		}
+		if err != nil {
+			setErrors(err)
+			break
+		}
+		waitForSize = false
+		if err := p.VerifyCosignedTreeHead(logKeyHash, &cth); err != nil {
+			var quorumErr *policy.QuorumError
+			if !errors.As(err, &quorumErr) {
+				setErrors(fmt.Errorf("verifying tree head failed: %v", err))
+				break
+			}
+			// Witnesses may not yet have cosigned the
+			// latest tree head, keep polling.
+			progress := fmt.Sprintf("Tree head of size %d has no witness quorum (%v), missing cosignatures from %s, waiting.",
+				cth.Size, quorumErr, formatMissingWitnesses(quorumErr.Missing))
+			if progress != lastProgress {
+				log.Info("%s", progress)
+				lastProgress = progress
+			} else {
+				log.Debug("%s", progress)
+			}
+			if err := sleep(ctx); err != nil {
+				setErrors(fmt.Errorf("%w, latest tree head not accepted: %v", err, quorumErr))
+				break
+			}
+			continue
+		}
+		if cth.Size == 0 {
+			// Certainly not included yet.
+			log.Debug("Signed tree is still empty, waiting.")
+		} else {
+			// See if we can have inclusion proofs for this tree size.
+			pending = getInclusionProofs(ctx, cli, logKeyHash, &cth, leafHashes, pending, maxConcurrent, proofs, errs)
+			if len(pending) == 0 {
+				break
+			}
+			log.Debug("No inclusion proof yet for %d leaves, waiting.", len(pending))
+		}
+		// Wait for the log's tree to grow.
+		waitForSize = true
+	}
+	return proofs, errs
+}
+
+// Lists witnesses, by url if available, and otherwise by key hash.
+func formatMissingWitnesses(witnesses []policy.Entity) string {
+	names := make([]string, len(witnesses))
+	for i, w := range witnesses {
+		if len(w.URL) > 0 {
+			names[i] = w.URL
+		} else {
+			names[i] = fmt.Sprintf("%x", crypto.HashBytes(w.PublicKey[:]))
+		}
+	}
+	return strings.Join(names, ", ")
+}
+
+// Looks for the leaves with the given indices in the log's current
+// tree head, and stores complete proofs (except proof.Leaf) for
+// leaves that are already included. Returns the indices of the
+// remaining leaves. Failures, e.g., a tree head without witness
+// quorum, are logged and treated as leaves not being included.
+func findIncludedLeaves(ctx context.Context, p *policy.Policy, cli api.LogReader, logKeyHash *crypto.Hash,
+	leafHashes []crypto.Hash, indices []int, maxConcurrent int, proofs []proof.SigsumProof) []int {
+	cth, err := cli.GetTreeHead(ctx)
+	if err != nil {
+		log.Debug("Getting tree head failed, not looking for included leaves: %v", err)
+		return indices
+	}
+	if cth.Size == 0 {
+		return indices
+	}
+	if err := p.VerifyCosignedTreeHead(logKeyHash, &cth); err != nil {
+		log.Debug("Verifying tree head failed, not looking for included leaves: %v", err)
+		return indices
+	}
+	errs := make([]error, len(leafHashes))
+	notIncluded := getInclusionProofs(ctx, cli, logKeyHash, &cth, leafHashes, indices, maxConcurrent, proofs, errs)
+	for _, i := range indices {
+		if errs[i] != nil {
+			log.Debug("Looking for leaf %x failed: %v", leafHashes[i], errs[i])
+			notIncluded = append(notIncluded, i)
+		}
+	}
+	sort.Ints(notIncluded)
+	if found := len(indices) - len(notIncluded); found > 0 {
+		log.Info("%d leaves already included in log, not submitted again.", found)
+	}
+	return notIncluded
+}
+
+// Retrieves inclusion proofs for the leaves with the given indices,
+// for the given tree head, concurrently. Stores complete proofs
+// (except proof.Leaf) and errors at the corresponding index of proofs
+// and errs. Returns the indices of leaves that are not yet included.
+func getInclusionProofs(ctx context.Context, cli api.LogReader, logKeyHash *crypto.Hash, cth *types.CosignedTreeHead,
+	leafHashes []crypto.Hash, indices []int, maxConcurrent int, proofs []proof.SigsumProof, errs []error) []int {
+	if cth.Size == 0 {
+		// Certainly not included yet.
+		return indices
+	}
+	included := make([]bool, len(indices))
+	forEachConcurrently(len(indices), maxConcurrent, func(j int) {
+		i := indices[j]
+		inclusion, err := cli.GetInclusionProof(ctx,
+			requests.InclusionProof{
+				Size:     cth.Size,
+				LeafHash: leafHashes[i],
+			})
+		if errors.Is(err, api.ErrNotFound) {
+			return
+		}
+		included[j] = true
+		if err != nil {
+			errs[i] = fmt.Errorf("failed to get inclusion proof: %v", err)
+			return
+		}
+		// Check validity.
+		if err := inclusion.Verify(&leafHashes[i], &cth.TreeHead); err != nil {
+			errs[i] = fmt.Errorf("inclusion proof invalid: %v", err)
+			return
+		}
+		proofs[i] = proof.SigsumProof{
+			LogKeyHash: *logKeyHash,
+			TreeHead:   *cth,
+			Inclusion:  inclusion,
+		}
+	})
+	var notIncluded []int
+	for j, i := range indices {
+		if !included[j] {
+			notIncluded = append(notIncluded, i)
+		}
+	}
+	return notIncluded
+}
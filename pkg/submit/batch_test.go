@@ -0,0 +1,282 @@
+package submit
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"sigsum.org/sigsum-go/pkg/crypto"
+	"sigsum.org/sigsum-go/pkg/logserver"
+	"sigsum.org/sigsum-go/pkg/policy"
+	"sigsum.org/sigsum-go/pkg/proof"
+	"sigsum.org/sigsum-go/pkg/requests"
+	"sigsum.org/sigsum-go/pkg/server"
+	token "sigsum.org/sigsum-go/pkg/submit-token"
+	"sigsum.org/sigsum-go/pkg/types"
+)
+
+type countingLog struct {
+	*logserver.Log
+	treeHeads atomic.Int64
+	addLeafs  atomic.Int64
+	// If set, WaitTreeHead calls wait, and then GetTreeHead,
+	// like a client polling a log that doesn't support waiting.
+	wait func(context.Context) error
+}
+
+func (l *countingLog) GetTreeHead(ctx context.Context) (types.CosignedTreeHead, error) {
+	l.treeHeads.Add(1)
+	return l.Log.GetTreeHead(ctx)
+}
+
+func (l *countingLog) AddLeaf(ctx context.Context, req requests.Leaf, header *token.SubmitHeader) (bool, error) {
+	l.addLeafs.Add(1)
+	return l.Log.AddLeaf(ctx, req, header)
+}
+
+func (l *countingLog) WaitTreeHead(ctx context.Context, req requests.TreeHead) (types.CosignedTreeHead, error) {
+	if l.wait == nil {
+		return l.Log.WaitTreeHead(ctx, req)
+	}
+	if err := l.wait(ctx); err != nil {
+		return types.CosignedTreeHead{}, err
+	}
+	return l.GetTreeHead(ctx)
+}
+
+func TestSubmitBatchToLog(t *testing.T) {
+	logPub, logSigner, err := crypto.NewKeyPair()
+	if err != nil {
+		t.Fatalf("creating log key failed: %v", err)
+	}
+	submitPub, submitSigner, err := crypto.NewKeyPair()
+	if err != nil {
+		t.Fatalf("creating submit key failed: %v", err)
+	}
+	logKeyHash := crypto.HashBytes(logPub[:])
+	policy, err := policy.NewKofNPolicy([]crypto.PublicKey{logPub}, nil, 0)
+	if err != nil {
+		t.Fatalf("creating policy failed: %v", err)
+	}
+	l, err := logserver.New(&logserver.Config{Signer: logSigner})
+	if err != nil {
+		t.Fatalf("creating log failed: %v", err)
+	}
+	log := countingLog{Log: l}
+
+	const n = 50
+	var reqs []requests.Leaf
+	var leaves []types.Leaf
+	var leafHashes []crypto.Hash
+	for i := 0; i < n; i++ {
+		msg := crypto.HashBytes([]byte{byte(i)})
+		signature, err := types.SignLeafMessage(submitSigner, msg[:])
+		if err != nil {
+			t.Fatalf("signing message failed: %v", err)
+		}
+		req := requests.Leaf{Message: msg, Signature: signature, PublicKey: submitPub}
+		leaf, err := req.Verify()
+		if err != nil {
+			t.Fatalf("leaf verify failed: %v", err)
+		}
+		reqs = append(reqs, req)
+		leaves = append(leaves, leaf)
+		leafHashes = append(leafHashes, leaf.ToHash())
+	}
+	// Use the wrong leaf hash for one of the leaves; it is then
+	// never found in the log.
+	leafHashes[7][0] ^= 1
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sleeps := 0
+	sleep := func(ctx context.Context) error {
+		sleeps++
+		if sleeps > 10 {
+			// Give up on the leaf that isn't found.
+			cancel()
+			return ctx.Err()
+		}
+		return log.Publish(ctx)
+	}
+	log.wait = sleep
+	proofs, errs := submitBatchToLog(ctx, policy, &log, &logKeyHash, nil, sleep, 4, reqs, leafHashes)
+	for i := range reqs {
+		if i == 7 {
+			if errs[i] == nil {
+				t.Errorf("leaf %d with invalid leaf hash succeeded", i)
+			}
+			continue
+		}
+		if errs[i] != nil {
+			t.Errorf("leaf %d failed: %v", i, errs[i])
+			continue
+		}
+		pr := proofs[i]
+		pr.Leaf = proof.NewShortLeaf(&leaves[i])
+		msg := crypto.HashBytes([]byte{byte(i)})
+		if err := pr.Verify(&msg, map[crypto.Hash]crypto.PublicKey{
+			crypto.HashBytes(submitPub[:]): submitPub}, policy); err != nil {
+			t.Errorf("proof for leaf %d failed to verify: %v", i, err)
+		}
+	}
+	// One tree head to look for already included leaves. All
+	// leaves are persisted after the first sleep, and then a
+	// single tree head should be sufficient, except for polling
+	// for the missing leaf, once per remaining sleep.
+	if got, want := log.treeHeads.Load(), int64(sleeps); got != want {
+		t.Errorf("unexpected number of tree head requests, got %d, want %d", got, want)
+	}
+}
+
+func TestSubmitBatchToLogIncluded(t *testing.T) {
+	logPub, logSigner, err := crypto.NewKeyPair()
+	if err != nil {
+		t.Fatalf("creating log key failed: %v", err)
+	}
+	submitPub, submitSigner, err := crypto.NewKeyPair()
+	if err != nil {
+		t.Fatalf("creating submit key failed: %v", err)
+	}
+	logKeyHash := crypto.HashBytes(logPub[:])
+	policy, err := policy.NewKofNPolicy([]crypto.PublicKey{logPub}, nil, 0)
+	if err != nil {
+		t.Fatalf("creating policy failed: %v", err)
+	}
+	l, err := logserver.New(&logserver.Config{Signer: logSigner})
+	if err != nil {
+		t.Fatalf("creating log failed: %v", err)
+	}
+	log := countingLog{Log: l}
+	ctx := context.Background()
+
+	var reqs []requests.Leaf
+	var leafHashes []crypto.Hash
+	for i := 0; i < 5; i++ {
+		msg := crypto.HashBytes([]byte{byte(i)})
+		signature, err := types.SignLeafMessage(submitSigner, msg[:])
+		if err != nil {
+			t.Fatalf("signing message failed: %v", err)
+		}
+		req := requests.Leaf{Message: msg, Signature: signature, PublicKey: submitPub}
+		leaf, err := req.Verify()
+		if err != nil {
+			t.Fatalf("leaf verify failed: %v", err)
+		}
+		reqs = append(reqs, req)
+		leafHashes = append(leafHashes, leaf.ToHash())
+	}
+	// First three leaves are already included in the log.
+	for _, req := range reqs[:3] {
+		if _, err := l.AddLeaf(ctx, req, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := l.Publish(ctx); err != nil {
+		t.Fatal(err)
+	}
+	sleep := func(ctx context.Context) error { return log.Publish(ctx) }
+	log.wait = sleep
+	proofs, errs := submitBatchToLog(ctx, policy, &log, &logKeyHash, nil, sleep, 4, reqs, leafHashes)
+	for i := range reqs {
+		if errs[i] != nil {
+			t.Errorf("leaf %d failed: %v", i, errs[i])
+			continue
+		}
+		if err := proofs[i].Inclusion.Verify(&leafHashes[i], &proofs[i].TreeHead.TreeHead); err != nil {
+			t.Errorf("inclusion proof for leaf %d invalid: %v", i, err)
+		}
+	}
+	// Two add-leaf requests for each of the two new leaves, the
+	// first one before the leaf is persisted.
+	if got, want := log.addLeafs.Load(), int64(4); got != want {
+		t.Errorf("unexpected number of add-leaf requests, got %d, want %d", got, want)
+	}
+}
+
+func TestSubmitBatchMultipleLogs(t *testing.T) {
+	submitPub, submitSigner, err := crypto.NewKeyPair()
+	if err != nil {
+		t.Fatalf("creating submit key failed: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	policyText := ""
+	for i := 0; i < 3; i++ {
+		logPub, logSigner, err := crypto.NewKeyPair()
+		if err != nil {
+			t.Fatalf("creating log key failed: %v", err)
+		}
+		var handler http.Handler
+		if i == 0 {
+			// A broken log.
+			handler = http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				http.Error(w, "broken", http.StatusInternalServerError)
+			})
+		} else {
+			l, err := logserver.New(&logserver.Config{Signer: logSigner, Interval: 10 * time.Millisecond})
+			if err != nil {
+				t.Fatalf("creating log failed: %v", err)
+			}
+			go l.Run(ctx)
+			handler = server.NewLog(&server.Config{}, l)
+		}
+		s := httptest.NewServer(handler)
+		defer s.Close()
+		policyText += fmt.Sprintf("log %x %s\n", logPub[:], s.URL)
+	}
+	policy, err := policy.ParseConfig(bytes.NewBufferString(policyText + "quorum none\n"))
+	if err != nil {
+		t.Fatalf("parsing policy failed: %v", err)
+	}
+
+	var reqs []requests.Leaf
+	for i := 0; i < 5; i++ {
+		msg := crypto.HashBytes([]byte{byte(i)})
+		signature, err := types.SignLeafMessage(submitSigner, msg[:])
+		if err != nil {
+			t.Fatalf("signing message failed: %v", err)
+		}
+		reqs = append(reqs, requests.Leaf{Message: msg, Signature: signature, PublicKey: submitPub})
+	}
+	// The broken log's server errors are retried until the per
+	// log timeout.
+	config := Config{Policy: policy, PollDelay: 10 * time.Millisecond, RequiredLogs: 2,
+		RetryDelay: 10 * time.Millisecond, PerLogTimeout: time.Second}
+	for i, result := range SubmitBatch(ctx, &config, reqs) {
+		if result.Err != nil {
+			t.Fatalf("submit of leaf %d failed: %v", i, result.Err)
+		}
+		if got, want := len(result.Proofs), 2; got != want {
+			t.Fatalf("unexpected number of proofs for leaf %d, got %d, want %d", i, got, want)
+		}
+		if result.Proofs[0].LogKeyHash == result.Proofs[1].LogKeyHash {
+			t.Errorf("proofs for leaf %d from the same log", i)
+		}
+		for _, pr := range result.Proofs {
+			if err := pr.Verify(&reqs[i].Message, map[crypto.Hash]crypto.PublicKey{
+				crypto.HashBytes(submitPub[:]): submitPub}, policy); err != nil {
+				t.Errorf("proof for leaf %d failed to verify: %v", i, err)
+			}
+			if _, ok := result.Proofs.ForLog(&pr.LogKeyHash); !ok {
+				t.Errorf("ForLog failed to find proof")
+			}
+		}
+	}
+
+	// Only two working logs.
+	config.RequiredLogs = 3
+	if _, err := SubmitLeafRequestMulti(ctx, &config, &reqs[0]); err == nil {
+		t.Errorf("submit succeeded without the required number of logs")
+	}
+	config.RequiredLogs = 4
+	if _, err := SubmitLeafRequestMulti(ctx, &config, &reqs[0]); err == nil {
+		t.Errorf("submit succeeded with more required logs than in policy")
+	}
+}
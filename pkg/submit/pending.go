@@ -0,0 +1,368 @@
+package submit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"time"
+
+	"sigsum.org/sigsum-go/pkg/ascii"
+	"sigsum.org/sigsum-go/pkg/crypto"
+	"sigsum.org/sigsum-go/pkg/log"
+	"sigsum.org/sigsum-go/pkg/policy"
+	"sigsum.org/sigsum-go/pkg/proof"
+	"sigsum.org/sigsum-go/pkg/requests"
+	"sigsum.org/sigsum-go/pkg/types"
+)
+
+const PendingSubmissionVersion = 1
+
+// Returned (possibly wrapped) by CollectBatch, for a leaf that is not
+// yet included in a log's latest tree head.
+var ErrNotIncluded = errors.New("leaf not yet included")
+
+// A PendingSubmission records a leaf that has been accepted by one or
+// more logs, so that proofs can be collected later, see
+// StartBatch and CollectBatch.
+type PendingSubmission struct {
+	// Key hashes of the logs that accepted the leaf.
+	Logs []crypto.Hash
+	// For each of Logs, whether or not the log has responded that
+	// the leaf is persisted (200 OK, rather than 202 Accepted).
+	// Until then, CollectBatch resends the add-leaf request, if the
+	// leaf isn't yet included. Missing elements mean not persisted.
+	Persisted []bool
+	// Time of the add-leaf request.
+	SubmitTime time.Time
+	Leaf       requests.Leaf
+}
+
+func (ps *PendingSubmission) isPersisted(j int) bool {
+	return j >= 0 && j < len(ps.Persisted) && ps.Persisted[j]
+}
+
+func (ps *PendingSubmission) setPersisted(j int) {
+	for len(ps.Persisted) < len(ps.Logs) {
+		ps.Persisted = append(ps.Persisted, false)
+	}
+	ps.Persisted[j] = true
+}
+
+// Format is a version line, a submit_time line (seconds since the
+// epoch), one log line per log, with the log's key hash and 1 if the
+// leaf is persisted, otherwise 0, an empty line, and then the add-leaf
+// request.
+func (ps *PendingSubmission) ToASCII(w io.Writer) error {
+	if err := ascii.WriteInt(w, "version", PendingSubmissionVersion); err != nil {
+		return err
+	}
+	if err := ascii.WriteInt(w, "submit_time", uint64(ps.SubmitTime.Unix())); err != nil {
+		return err
+	}
+	for j, logKeyHash := range ps.Logs {
+		persisted := uint64(0)
+		if ps.isPersisted(j) {
+			persisted = 1
+		}
+		if err := ascii.WriteLine(w, "log", logKeyHash[:], persisted); err != nil {
+			return err
+		}
+	}
+	// Empty line as separator.
+	if _, err := fmt.Fprint(w, "\n"); err != nil {
+		return err
+	}
+	return ps.Leaf.ToASCII(w)
+}
+
+func (ps *PendingSubmission) FromASCII(r io.Reader) error {
+	p := ascii.NewParser(r)
+	version, err := p.GetInt("version")
+	if err != nil {
+		return fmt.Errorf("invalid version line: %v", err)
+	}
+	if version != PendingSubmissionVersion {
+		return fmt.Errorf("unknown version %d, wanted %d", version, PendingSubmissionVersion)
+	}
+	submitTime, err := p.GetInt("submit_time")
+	if err != nil {
+		return err
+	}
+	ps.SubmitTime = time.Unix(int64(submitTime), 0)
+	ps.Logs, ps.Persisted = nil, nil
+	for {
+		values, err := p.GetValues("log", 2)
+		if err == ascii.ErrEmptyLine {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		logKeyHash, err := crypto.HashFromHex(values[0])
+		if err != nil {
+			return err
+		}
+		persisted, err := ascii.IntFromDecimal(values[1])
+		if err != nil {
+			return err
+		}
+		if persisted > 1 {
+			return fmt.Errorf("invalid persisted flag %d", persisted)
+		}
+		ps.Logs = append(ps.Logs, logKeyHash)
+		ps.Persisted = append(ps.Persisted, persisted == 1)
+	}
+	if len(ps.Logs) == 0 {
+		return fmt.Errorf("invalid pending submission, no logs")
+	}
+	if err := ps.Leaf.Parse(&p); err != nil {
+		return err
+	}
+	return p.GetEOF()
+}
+
+// Result of starting submission of one leaf of a batch. If Err is
+// nil, Pending lists config.RequiredLogs logs that accepted the leaf.
+type StartResult struct {
+	Pending PendingSubmission
+	Err     error
+}
+
+// Sends add-leaf requests for a batch of leaves, without waiting for
+// the leaves to be included. Each leaf is sent to logs in the policy
+// in turn, until it is accepted by config.RequiredLogs logs. Leaves
+// already included in a log's current tree head are not sent again,
+// and are recorded as persisted by that log. Returns one result per
+// request, in the same order, to be passed to CollectBatch later.
+func StartBatch(ctx context.Context, config *Config, reqs []requests.Leaf) []StartResult {
+	results := make([]StartResult, len(reqs))
+	leafHashes := make([]crypto.Hash, len(reqs))
+	var pending []int
+	for i := range reqs {
+		leaf, err := reqs[i].Verify()
+		if err != nil {
+			results[i].Err = fmt.Errorf("verifying leaf request failed: %v", err)
+			continue
+		}
+		leafHashes[i] = leaf.ToHash()
+		results[i].Pending.Leaf = reqs[i]
+		pending = append(pending, i)
+	}
+	setErrors := func(err error) {
+		for _, i := range pending {
+			results[i].Err = err
+		}
+	}
+
+	logs := config.Policy.GetLogsWithUrl()
+	if len(logs) == 0 {
+		setErrors(fmt.Errorf("no logs defined in policy"))
+		return results
+	}
+	required := config.getRequiredLogs()
+	if required > len(logs) {
+		setErrors(fmt.Errorf("%d logs required, but only %d logs in policy", required, len(logs)))
+		return results
+	}
+	for _, entity := range logs {
+		if len(pending) == 0 {
+			break
+		}
+		log.Info("Sending %d leaves to log: %s", len(pending), entity.URL)
+		header, err := config.getSubmitHeader(&entity)
+		if err != nil {
+			setErrors(err)
+			return results
+		}
+
+		client := config.newLogClient(&entity)
+		logKeyHash := crypto.HashBytes(entity.PublicKey[:])
+		errs := make([]error, len(reqs))
+		persisted := make([]bool, len(reqs))
+		func() {
+			ctx, cancel := context.WithTimeout(ctx, config.getTimeout())
+			defer cancel()
+			// Leaves may already be included, e.g., if an
+			// earlier submission was interrupted. Then
+			// the log has persisted them, and there's no
+			// need to spend rate limit budget on add-leaf
+			// requests.
+			notIncluded := findIncludedLeaves(ctx, config.Policy, client, &logKeyHash, leafHashes, pending,
+				config.getMaxConcurrentRequests(), make([]proof.SigsumProof, len(reqs)))
+			for _, i := range pending {
+				persisted[i] = true
+			}
+			forEachConcurrently(len(notIncluded), config.getMaxConcurrentRequests(), func(j int) {
+				i := notIncluded[j]
+				persisted[i], errs[i] = client.AddLeaf(ctx, reqs[i], header)
+			})
+		}()
+		now := time.Now()
+		var incomplete []int
+		for _, i := range pending {
+			if errs[i] != nil {
+				log.Error("Submitting leaf to log %q failed: %v", entity.URL, errs[i])
+			} else {
+				results[i].Pending.Logs = append(results[i].Pending.Logs, logKeyHash)
+				results[i].Pending.Persisted = append(results[i].Pending.Persisted, persisted[i])
+				results[i].Pending.SubmitTime = now
+			}
+			if len(results[i].Pending.Logs) < required {
+				incomplete = append(incomplete, i)
+			}
+		}
+		pending = incomplete
+	}
+	for _, i := range pending {
+		results[i].Err = fmt.Errorf("leaf accepted by only %d of %d required logs, giving up",
+			len(results[i].Pending.Logs), required)
+	}
+	return results
+}
+
+// Collects proofs for a batch of pending submissions, without
+// waiting. For each log, a single tree head is retrieved, and
+// inclusion proofs for all leaves submitted to that log. Returns one
+// result per pending submission, in the same order. On success, the
+// result has one proof for each of the submission's logs, in the same
+// order. If a leaf isn't yet included in some log, the error wraps
+// ErrNotIncluded, and collection can be retried later. This includes
+// the case that the log's latest tree head doesn't yet have enough
+// witness cosignatures to satisfy the policy.
+//
+// A log that accepted a leaf isn't required to persist it until it
+// responds to an add-leaf request with 200 OK, so for each leaf not
+// yet included, the add-leaf request is sent again, unless the log
+// has already responded that the leaf is persisted. The Persisted
+// flags of pending are updated accordingly, and callers should save
+// the updated pending submissions for leaves not yet included, to
+// avoid needless resends.
+func CollectBatch(ctx context.Context, config *Config, pending []PendingSubmission) []BatchResult {
+	results := make([]BatchResult, len(pending))
+	leaves := make([]types.Leaf, len(pending))
+	leafHashes := make([]crypto.Hash, len(pending))
+	// Maps log key hash to indices of submissions to that log.
+	byLog := make(map[crypto.Hash][]int)
+	for i := range pending {
+		leaf, err := pending[i].Leaf.Verify()
+		if err != nil {
+			results[i].Err = fmt.Errorf("verifying leaf request failed: %v", err)
+			continue
+		}
+		leaves[i] = leaf
+		leafHashes[i] = leaf.ToHash()
+		for _, logKeyHash := range pending[i].Logs {
+			byLog[logKeyHash] = append(byLog[logKeyHash], i)
+		}
+	}
+	logs := make(map[crypto.Hash]policy.Entity)
+	for _, entity := range config.Policy.GetLogsWithUrl() {
+		logs[crypto.HashBytes(entity.PublicKey[:])] = entity
+	}
+	// Maps log key hash to the proofs for that log.
+	logProofs := make(map[crypto.Hash][]proof.SigsumProof)
+	for logKeyHash, indices := range byLog {
+		entity, ok := logs[logKeyHash]
+		if !ok {
+			for _, i := range indices {
+				results[i].Err = fmt.Errorf("log %x not in policy", logKeyHash)
+			}
+			continue
+		}
+		proofs := make([]proof.SigsumProof, len(pending))
+		errs := make([]error, len(pending))
+		func() {
+			ctx, cancel := context.WithTimeout(ctx, config.getTimeout())
+			defer cancel()
+			collectFromLog(ctx, config, &entity, &logKeyHash, pending, leafHashes, indices, proofs, errs)
+		}()
+		for _, i := range indices {
+			if errs[i] != nil && results[i].Err == nil {
+				results[i].Err = fmt.Errorf("log %q: %w", entity.URL, errs[i])
+			}
+		}
+		logProofs[logKeyHash] = proofs
+	}
+	for i := range pending {
+		if results[i].Err != nil {
+			continue
+		}
+		for _, logKeyHash := range pending[i].Logs {
+			pr := logProofs[logKeyHash][i]
+			pr.Leaf = proof.NewShortLeaf(&leaves[i])
+			results[i].Proofs = append(results[i].Proofs, pr)
+		}
+	}
+	return results
+}
+
+// Collects proofs from a single log, for the leaves with the given
+// indices. Resends add-leaf requests for leaves that are not
+// included, unless the log has already responded that they are
+// persisted. If the log's tree head doesn't yet satisfy the witness
+// quorum, inclusion is still checked, and add-leaf requests resent,
+// but any proofs are not usable until witnesses have cosigned.
+func collectFromLog(ctx context.Context, config *Config, entity *policy.Entity, logKeyHash *crypto.Hash,
+	pending []PendingSubmission, leafHashes []crypto.Hash, indices []int, proofs []proof.SigsumProof, errs []error) {
+	setErrors := func(err error) {
+		for _, i := range indices {
+			errs[i] = err
+		}
+	}
+	cli := config.newLogClient(entity)
+	cth, err := cli.GetTreeHead(ctx)
+	if err != nil {
+		setErrors(err)
+		return
+	}
+	var noQuorum error
+	if err := config.Policy.VerifyCosignedTreeHead(logKeyHash, &cth); err != nil {
+		var quorumErr *policy.QuorumError
+		if !errors.As(err, &quorumErr) {
+			setErrors(fmt.Errorf("verifying tree head failed: %v", err))
+			return
+		}
+		// Witnesses may not yet have cosigned the latest
+		// tree head, so proofs must be collected later.
+		noQuorum = fmt.Errorf("%w, tree head of size %d has no witness quorum: %v",
+			ErrNotIncluded, cth.Size, err)
+	}
+	notIncluded := getInclusionProofs(ctx, cli, logKeyHash, &cth, leafHashes, indices,
+		config.getMaxConcurrentRequests(), proofs, errs)
+	if noQuorum != nil {
+		for _, i := range indices {
+			if errs[i] == nil {
+				errs[i] = noQuorum
+			}
+		}
+	}
+	var resend []int
+	for _, i := range notIncluded {
+		errs[i] = ErrNotIncluded
+		if !pending[i].isPersisted(slices.Index(pending[i].Logs, *logKeyHash)) {
+			resend = append(resend, i)
+		}
+	}
+	if len(resend) == 0 {
+		return
+	}
+	header, err := config.getSubmitHeader(entity)
+	if err != nil {
+		for _, i := range resend {
+			errs[i] = fmt.Errorf("%w, resending add-leaf failed: %v", ErrNotIncluded, err)
+		}
+		return
+	}
+	log.Info("Resending %d leaves not yet included to log: %s", len(resend), entity.URL)
+	forEachConcurrently(len(resend), config.getMaxConcurrentRequests(), func(j int) {
+		i := resend[j]
+		persisted, err := cli.AddLeaf(ctx, pending[i].Leaf, header)
+		if err != nil {
+			errs[i] = fmt.Errorf("%w, resending add-leaf failed: %v", ErrNotIncluded, err)
+		} else if persisted {
+			pending[i].setPersisted(slices.Index(pending[i].Logs, *logKeyHash))
+		}
+	})
+}
@@ -0,0 +1,378 @@
+package submit
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"sigsum.org/sigsum-go/pkg/crypto"
+	"sigsum.org/sigsum-go/pkg/logserver"
+	"sigsum.org/sigsum-go/pkg/policy"
+	"sigsum.org/sigsum-go/pkg/requests"
+	"sigsum.org/sigsum-go/pkg/server"
+	"sigsum.org/sigsum-go/pkg/types"
+)
+
+func TestPendingSubmissionASCII(t *testing.T) {
+	ps := PendingSubmission{
+		Logs:       []crypto.Hash{{1}, {2}},
+		Persisted:  []bool{false, true},
+		SubmitTime: time.Unix(1700000000, 0),
+		Leaf: requests.Leaf{
+			Message:   crypto.Hash{3},
+			Signature: crypto.Signature{4},
+			PublicKey: crypto.PublicKey{5},
+		},
+	}
+	buf := bytes.Buffer{}
+	if err := ps.ToASCII(&buf); err != nil {
+		t.Fatal(err)
+	}
+	want := "version=1\n" +
+		"submit_time=1700000000\n" +
+		"log=0100000000000000000000000000000000000000000000000000000000000000 0\n" +
+		"log=0200000000000000000000000000000000000000000000000000000000000000 1\n" +
+		"\n" +
+		"message=0300000000000000000000000000000000000000000000000000000000000000\n" +
+		"signature=0400000000000000000000000000000000000000000000000000000000000000" +
+		"0000000000000000000000000000000000000000000000000000000000000000\n" +
+		"public_key=0500000000000000000000000000000000000000000000000000000000000000\n"
+	if got := buf.String(); got != want {
+		t.Errorf("got pending submission\n%s\nwant\n%s", got, want)
+	}
+	var parsed PendingSubmission
+	if err := parsed.FromASCII(bytes.NewBufferString(want)); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(parsed, ps) {
+		t.Errorf("got %v, want %v", parsed, ps)
+	}
+	// No log lines.
+	if err := parsed.FromASCII(bytes.NewBufferString("version=1\nsubmit_time=1\n\n" +
+		want[bytes.Index([]byte(want), []byte("message")):])); err == nil {
+		t.Errorf("pending submission without logs accepted")
+	}
+}
+
+func TestStartAndCollect(t *testing.T) {
+	submitPub, submitSigner, err := crypto.NewKeyPair()
+	if err != nil {
+		t.Fatalf("creating submit key failed: %v", err)
+	}
+	ctx := context.Background()
+
+	var logs []*logserver.Log
+	policyText := ""
+	for i := 0; i < 2; i++ {
+		logPub, logSigner, err := crypto.NewKeyPair()
+		if err != nil {
+			t.Fatalf("creating log key failed: %v", err)
+		}
+		l, err := logserver.New(&logserver.Config{Signer: logSigner})
+		if err != nil {
+			t.Fatalf("creating log failed: %v", err)
+		}
+		logs = append(logs, l)
+		s := httptest.NewServer(server.NewLog(&server.Config{}, l))
+		defer s.Close()
+		policyText += fmt.Sprintf("log %x %s\n", logPub[:], s.URL)
+	}
+	policy, err := policy.ParseConfig(bytes.NewBufferString(policyText + "quorum none\n"))
+	if err != nil {
+		t.Fatalf("parsing policy failed: %v", err)
+	}
+
+	var reqs []requests.Leaf
+	for i := 0; i < 3; i++ {
+		msg := crypto.HashBytes([]byte{byte(i)})
+		signature, err := types.SignLeafMessage(submitSigner, msg[:])
+		if err != nil {
+			t.Fatalf("signing message failed: %v", err)
+		}
+		reqs = append(reqs, requests.Leaf{Message: msg, Signature: signature, PublicKey: submitPub})
+	}
+	config := Config{Policy: policy, RequiredLogs: 2}
+	var pending []PendingSubmission
+	for i, result := range StartBatch(ctx, &config, reqs) {
+		if result.Err != nil {
+			t.Fatalf("start of leaf %d failed: %v", i, result.Err)
+		}
+		if got, want := len(result.Pending.Logs), 2; got != want {
+			t.Fatalf("unexpected number of logs for leaf %d, got %d, want %d", i, got, want)
+		}
+		pending = append(pending, result.Pending)
+	}
+
+	// Nothing published yet.
+	for i, result := range CollectBatch(ctx, &config, pending) {
+		if !errors.Is(result.Err, ErrNotIncluded) {
+			t.Errorf("unexpected result for leaf %d before publishing: %v", i, result.Err)
+		}
+	}
+
+	for _, l := range logs {
+		if err := l.Publish(ctx); err != nil {
+			t.Fatalf("publish failed: %v", err)
+		}
+	}
+	for i, result := range CollectBatch(ctx, &config, pending) {
+		if result.Err != nil {
+			t.Fatalf("collect of leaf %d failed: %v", i, result.Err)
+		}
+		if got, want := len(result.Proofs), 2; got != want {
+			t.Fatalf("unexpected number of proofs for leaf %d, got %d, want %d", i, got, want)
+		}
+		for j, pr := range result.Proofs {
+			if pr.LogKeyHash != pending[i].Logs[j] {
+				t.Errorf("proofs for leaf %d in unexpected order", i)
+			}
+			if err := pr.Verify(&reqs[i].Message, map[crypto.Hash]crypto.PublicKey{
+				crypto.HashBytes(submitPub[:]): submitPub}, policy); err != nil {
+				t.Errorf("proof for leaf %d failed to verify: %v", i, err)
+			}
+		}
+	}
+}
+
+// Serves the log currently stored, so that the log can be replaced,
+// as if restarted. Counts add-leaf requests, and can pretend that
+// accepted leaves are persisted.
+type restartableLog struct {
+	handler atomic.Pointer[http.Handler]
+	// Number of add-leaf requests.
+	addLeaf atomic.Int32
+	// If set, responds 200 OK rather than 202 Accepted.
+	persist atomic.Bool
+}
+
+func (l *restartableLog) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasSuffix(r.URL.Path, "/add-leaf") {
+		(*l.handler.Load()).ServeHTTP(w, r)
+		return
+	}
+	l.addLeaf.Add(1)
+	rec := httptest.NewRecorder()
+	(*l.handler.Load()).ServeHTTP(rec, r)
+	if rec.Code == http.StatusAccepted && l.persist.Load() {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	for k, v := range rec.Header() {
+		w.Header()[k] = v
+	}
+	w.WriteHeader(rec.Code)
+	w.Write(rec.Body.Bytes())
+}
+
+func (l *restartableLog) set(log *logserver.Log) {
+	handler := server.NewLog(&server.Config{}, log)
+	l.handler.Store(&handler)
+}
+
+// A log that can be restarted, losing any leaves accepted but not
+// yet published, and a policy using it.
+type restartTest struct {
+	t         *testing.T
+	dir       string
+	logSigner crypto.Signer
+	log       *logserver.Log
+	endpoint  restartableLog
+	policy    *policy.Policy
+}
+
+// If witness is set, the policy requires a cosignature that the log
+// never gets.
+func newRestartTest(t *testing.T, witness bool) *restartTest {
+	logPub, logSigner, err := crypto.NewKeyPair()
+	if err != nil {
+		t.Fatalf("creating log key failed: %v", err)
+	}
+	rt := restartTest{t: t, dir: t.TempDir(), logSigner: logSigner}
+	rt.restart()
+	s := httptest.NewServer(&rt.endpoint)
+	t.Cleanup(s.Close)
+	policyText := fmt.Sprintf("log %x %s\n", logPub[:], s.URL)
+	if witness {
+		policyText += fmt.Sprintf("witness w %x\nquorum w\n", crypto.PublicKey{1})
+	} else {
+		policyText += "quorum none\n"
+	}
+	rt.policy, err = policy.ParseConfig(bytes.NewBufferString(policyText))
+	if err != nil {
+		t.Fatalf("parsing policy failed: %v", err)
+	}
+	return &rt
+}
+
+func (rt *restartTest) restart() {
+	if rt.log != nil {
+		rt.log.Close()
+	}
+	l, err := logserver.New(&logserver.Config{Signer: rt.logSigner, Directory: rt.dir})
+	if err != nil {
+		rt.t.Fatalf("creating log failed: %v", err)
+	}
+	rt.t.Cleanup(func() { l.Close() })
+	rt.log = l
+	rt.endpoint.set(l)
+}
+
+func (rt *restartTest) publish(ctx context.Context) {
+	if err := rt.log.Publish(ctx); err != nil {
+		rt.t.Fatalf("publish failed: %v", err)
+	}
+}
+
+// Starts submission of a leaf.
+func (rt *restartTest) start(ctx context.Context, config *Config, msg *crypto.Hash, submitSigner crypto.Signer) PendingSubmission {
+	signature, err := types.SignLeafMessage(submitSigner, msg[:])
+	if err != nil {
+		rt.t.Fatalf("signing message failed: %v", err)
+	}
+	req := requests.Leaf{Message: *msg, Signature: signature, PublicKey: submitSigner.Public()}
+	results := StartBatch(ctx, config, []requests.Leaf{req})
+	if results[0].Err != nil {
+		rt.t.Fatalf("start failed: %v", results[0].Err)
+	}
+	return results[0].Pending
+}
+
+func TestCollectResendsDroppedLeaf(t *testing.T) {
+	submitPub, submitSigner, err := crypto.NewKeyPair()
+	if err != nil {
+		t.Fatalf("creating submit key failed: %v", err)
+	}
+	ctx := context.Background()
+	rt := newRestartTest(t, false)
+	msg := crypto.HashBytes([]byte("dropped"))
+	config := Config{Policy: rt.policy}
+	pending := []PendingSubmission{rt.start(ctx, &config, &msg, submitSigner)}
+
+	// Restart the log, losing the accepted but not yet
+	// sequenced leaf.
+	rt.restart()
+	rt.publish(ctx)
+	if result := CollectBatch(ctx, &config, pending)[0]; !errors.Is(result.Err, ErrNotIncluded) {
+		t.Fatalf("unexpected result for dropped leaf: %v", result.Err)
+	}
+	// The leaf was resent by CollectBatch.
+	rt.publish(ctx)
+	result := CollectBatch(ctx, &config, pending)[0]
+	if result.Err != nil {
+		t.Fatalf("collect failed: %v", result.Err)
+	}
+	if err := result.Proofs[0].Verify(&msg, map[crypto.Hash]crypto.PublicKey{
+		crypto.HashBytes(submitPub[:]): submitPub}, rt.policy); err != nil {
+		t.Errorf("proof failed to verify: %v", err)
+	}
+}
+
+func TestCollectResendsWithoutQuorum(t *testing.T) {
+	_, submitSigner, err := crypto.NewKeyPair()
+	if err != nil {
+		t.Fatalf("creating submit key failed: %v", err)
+	}
+	ctx := context.Background()
+	rt := newRestartTest(t, true)
+	msg := crypto.HashBytes([]byte("dropped"))
+	config := Config{Policy: rt.policy}
+	pending := []PendingSubmission{rt.start(ctx, &config, &msg, submitSigner)}
+
+	rt.restart()
+	rt.publish(ctx)
+	// Resent, even though the tree head lacks cosignatures.
+	if result := CollectBatch(ctx, &config, pending)[0]; !errors.Is(result.Err, ErrNotIncluded) {
+		t.Fatalf("unexpected result for dropped leaf: %v", result.Err)
+	}
+	rt.publish(ctx)
+	leaf, err := pending[0].Leaf.Verify()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cth, err := rt.log.GetTreeHead(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rt.log.GetInclusionProof(ctx, requests.InclusionProof{Size: cth.Size, LeafHash: leaf.ToHash()}); err != nil {
+		t.Errorf("dropped leaf not resent: %v", err)
+	}
+	// Included, but still no usable proof.
+	if result := CollectBatch(ctx, &config, pending)[0]; !errors.Is(result.Err, ErrNotIncluded) {
+		t.Errorf("unexpected result without quorum: %v", result.Err)
+	}
+}
+
+func TestCollectSkipsPersistedLeaf(t *testing.T) {
+	_, submitSigner, err := crypto.NewKeyPair()
+	if err != nil {
+		t.Fatalf("creating submit key failed: %v", err)
+	}
+	ctx := context.Background()
+	rt := newRestartTest(t, false)
+	config := Config{Policy: rt.policy}
+	msg := crypto.HashBytes([]byte("accepted"))
+	pending := []PendingSubmission{rt.start(ctx, &config, &msg, submitSigner)}
+	if got, want := pending[0].Persisted, []bool{false}; !slices.Equal(got, want) {
+		t.Fatalf("unexpected persisted flags after start, got %v, want %v", got, want)
+	}
+	rt.restart()
+	rt.publish(ctx)
+
+	// Resent, and recorded as persisted.
+	rt.endpoint.persist.Store(true)
+	rt.endpoint.addLeaf.Store(0)
+	if result := CollectBatch(ctx, &config, pending)[0]; !errors.Is(result.Err, ErrNotIncluded) {
+		t.Fatalf("unexpected result: %v", result.Err)
+	}
+	if got := rt.endpoint.addLeaf.Load(); got != 1 {
+		t.Errorf("unexpected number of add-leaf requests, got %d, want 1", got)
+	}
+	if got, want := pending[0].Persisted, []bool{true}; !slices.Equal(got, want) {
+		t.Errorf("unexpected persisted flags after resend, got %v, want %v", got, want)
+	}
+
+	// Not resent again, even if the log drops it.
+	rt.restart()
+	rt.publish(ctx)
+	if result := CollectBatch(ctx, &config, pending)[0]; !errors.Is(result.Err, ErrNotIncluded) {
+		t.Fatalf("unexpected result: %v", result.Err)
+	}
+	if got := rt.endpoint.addLeaf.Load(); got != 1 {
+		t.Errorf("persisted leaf resent, %d add-leaf requests", got)
+	}
+}
+
+func TestStartIncludedLeaf(t *testing.T) {
+	_, submitSigner, err := crypto.NewKeyPair()
+	if err != nil {
+		t.Fatalf("creating submit key failed: %v", err)
+	}
+	ctx := context.Background()
+	rt := newRestartTest(t, false)
+	config := Config{Policy: rt.policy}
+	msg := crypto.HashBytes([]byte("included"))
+	rt.start(ctx, &config, &msg, submitSigner)
+	rt.publish(ctx)
+
+	// Started again, e.g., after losing the .pending file.
+	rt.endpoint.addLeaf.Store(0)
+	pending := rt.start(ctx, &config, &msg, submitSigner)
+	if got := rt.endpoint.addLeaf.Load(); got != 0 {
+		t.Errorf("included leaf submitted again, %d add-leaf requests", got)
+	}
+	if got, want := pending.Persisted, []bool{true}; !slices.Equal(got, want) {
+		t.Errorf("unexpected persisted flags, got %v, want %v", got, want)
+	}
+	if result := CollectBatch(ctx, &config, []PendingSubmission{pending})[0]; result.Err != nil {
+		t.Errorf("collect failed: %v", result.Err)
+	}
+}
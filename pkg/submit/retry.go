@@ -0,0 +1,123 @@
+package submit
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net/http"
+	"time"
+
+	"sigsum.org/sigsum-go/pkg/api"
+	"sigsum.org/sigsum-go/pkg/log"
+	"sigsum.org/sigsum-go/pkg/requests"
+	token "sigsum.org/sigsum-go/pkg/submit-token"
+	"sigsum.org/sigsum-go/pkg/types"
+)
+
+// Wraps an api.Log, retrying requests that fail with a transient
+// error, i.e., 429 Too Many Requests or a 5xx server error, with
+// exponential backoff and jitter. A Retry-After delay from the log
+// is respected. Other errors, e.g., 400 Bad Request or 403 Forbidden,
+// are returned immediately, as is the transient error if the context
+// would expire before the next attempt.
+type retryingLog struct {
+	log      api.Log
+	delay    time.Duration
+	maxDelay time.Duration
+	// Sleeps for the given time, or until the context expires.
+	sleep func(context.Context, time.Duration) error
+}
+
+func newRetryingLog(log api.Log, delay, maxDelay time.Duration) *retryingLog {
+	return &retryingLog{log: log, delay: delay, maxDelay: maxDelay, sleep: sleepWithContext}
+}
+
+// Returns true for errors worth retrying, i.e., 429 Too Many
+// Requests, and server errors. Errors not from the log's response,
+// e.g., connection failures, are not retried.
+func isTransient(err error) bool {
+	var apiError *api.Error
+	if !errors.As(err, &apiError) {
+		return false
+	}
+	status := apiError.StatusCode()
+	return status == http.StatusTooManyRequests || status >= 500
+}
+
+// Returns a random duration in the interval [d/2, d].
+func withJitter(d time.Duration) time.Duration {
+	if d <= 1 {
+		return d
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d-d/2)+1))
+}
+
+func (l *retryingLog) retry(ctx context.Context, name string, f func() error) error {
+	delay := l.delay
+	for {
+		err := f()
+		if err == nil || !isTransient(err) {
+			return err
+		}
+		wait := withJitter(delay)
+		if retryAfter, ok := api.ErrorRetryAfter(err); ok {
+			wait = retryAfter
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < wait {
+			return err
+		}
+		log.Debug("%s failed: %v, retrying in %v", name, err, wait)
+		if l.sleep(ctx, wait) != nil {
+			return err
+		}
+		delay = min(2*delay, l.maxDelay)
+	}
+}
+
+func (l *retryingLog) GetTreeHead(ctx context.Context) (cth types.CosignedTreeHead, err error) {
+	err = l.retry(ctx, "get-tree-head", func() (err error) {
+		cth, err = l.log.GetTreeHead(ctx)
+		return err
+	})
+	return
+}
+
+func (l *retryingLog) WaitTreeHead(ctx context.Context, req requests.TreeHead) (cth types.CosignedTreeHead, err error) {
+	err = l.retry(ctx, "get-tree-head", func() (err error) {
+		cth, err = l.log.WaitTreeHead(ctx, req)
+		return err
+	})
+	return
+}
+
+func (l *retryingLog) GetInclusionProof(ctx context.Context, req requests.InclusionProof) (proof types.InclusionProof, err error) {
+	err = l.retry(ctx, "get-inclusion-proof", func() (err error) {
+		proof, err = l.log.GetInclusionProof(ctx, req)
+		return err
+	})
+	return
+}
+
+func (l *retryingLog) GetConsistencyProof(ctx context.Context, req requests.ConsistencyProof) (proof types.ConsistencyProof, err error) {
+	err = l.retry(ctx, "get-consistency-proof", func() (err error) {
+		proof, err = l.log.GetConsistencyProof(ctx, req)
+		return err
+	})
+	return
+}
+
+func (l *retryingLog) GetLeaves(ctx context.Context, req requests.Leaves) (leaves []types.Leaf, err error) {
+	err = l.retry(ctx, "get-leaves", func() (err error) {
+		leaves, err = l.log.GetLeaves(ctx, req)
+		return err
+	})
+	return
+}
+
+func (l *retryingLog) AddLeaf(ctx context.Context, req requests.Leaf, header *token.SubmitHeader) (persisted bool, err error) {
+	err = l.retry(ctx, "add-leaf", func() (err error) {
+		persisted, err = l.log.AddLeaf(ctx, req, header)
+		return err
+	})
+	return
+}
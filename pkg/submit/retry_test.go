@@ -0,0 +1,89 @@
+package submit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+
+	"sigsum.org/sigsum-go/pkg/api"
+	"sigsum.org/sigsum-go/pkg/mocks"
+	"sigsum.org/sigsum-go/pkg/types"
+)
+
+func TestRetryingLog(t *testing.T) {
+	errUnavailable := api.NewError(503, fmt.Errorf("unavailable"))
+	for _, table := range []struct {
+		desc string
+		errs []error // Errors returned before success.
+		// Expected sleeps, where jitter may reduce each by up
+		// to half. Nil if the request should fail.
+		sleeps []time.Duration
+	}{
+		{"success", nil, []time.Duration{}},
+		{"transient", []error{api.ErrTooManyRequests, errUnavailable, errUnavailable, errUnavailable},
+			[]time.Duration{time.Second, 2 * time.Second, 3 * time.Second, 3 * time.Second}},
+		{"retry after", []error{api.ErrTooManyRequests.WithRetryAfter(10 * time.Second)},
+			[]time.Duration{10 * time.Second}},
+		{"forbidden", []error{api.ErrForbidden}, nil},
+		{"bad request", []error{errUnavailable, api.ErrBadRequest}, nil},
+		{"connection failure", []error{errors.New("connection refused")}, nil},
+	} {
+		ctrl := gomock.NewController(t)
+		client := mocks.NewMockLog(ctrl)
+		var calls []*gomock.Call
+		for _, err := range table.errs {
+			calls = append(calls, client.EXPECT().GetTreeHead(gomock.Any()).Return(types.CosignedTreeHead{}, err))
+		}
+		if table.sleeps != nil {
+			calls = append(calls, client.EXPECT().GetTreeHead(gomock.Any()).Return(types.CosignedTreeHead{}, nil))
+		}
+		gomock.InOrder(calls...)
+
+		var sleeps []time.Duration
+		l := newRetryingLog(client, time.Second, 3*time.Second)
+		l.sleep = func(_ context.Context, d time.Duration) error {
+			sleeps = append(sleeps, d)
+			return nil
+		}
+		_, err := l.GetTreeHead(context.Background())
+		if table.sleeps == nil {
+			if err == nil {
+				t.Errorf("%s: unexpected success", table.desc)
+			}
+		} else if err != nil {
+			t.Errorf("%s: failed: %v", table.desc, err)
+		} else if len(sleeps) != len(table.sleeps) {
+			t.Errorf("%s: unexpected sleeps: %v, want %v", table.desc, sleeps, table.sleeps)
+		} else {
+			for i, d := range sleeps {
+				if want := table.sleeps[i]; d > want || d < want/2 {
+					t.Errorf("%s: unexpected sleep %d: %v, want at most %v", table.desc, i, d, want)
+				}
+			}
+		}
+		ctrl.Finish()
+	}
+}
+
+func TestRetryingLogDeadline(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	client := mocks.NewMockLog(ctrl)
+	client.EXPECT().GetTreeHead(gomock.Any()).Return(
+		types.CosignedTreeHead{}, api.ErrTooManyRequests.WithRetryAfter(time.Hour))
+
+	l := newRetryingLog(client, time.Second, time.Minute)
+	l.sleep = func(_ context.Context, _ time.Duration) error {
+		t.Errorf("unexpected sleep")
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	if _, err := l.GetTreeHead(ctx); !errors.Is(err, api.ErrTooManyRequests) {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
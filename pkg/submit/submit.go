@@ -4,7 +4,6 @@ package submit
 
 import (
 	"context"
-	"errors"
 	"fmt"
 	"net/http"
 	"time"
@@ -12,7 +11,6 @@ import (
 	"sigsum.org/sigsum-go/pkg/api"
 	"sigsum.org/sigsum-go/pkg/client"
 	"sigsum.org/sigsum-go/pkg/crypto"
-	"sigsum.org/sigsum-go/pkg/log"
 	"sigsum.org/sigsum-go/pkg/policy"
 	"sigsum.org/sigsum-go/pkg/proof"
 	"sigsum.org/sigsum-go/pkg/requests"
@@ -26,6 +24,11 @@ const (
 	// use something longer.
 	defaultTimeout   = 45 * time.Second
 	defaultUserAgent = "sigsum-go submit"
+	// Maximum number of concurrent requests to a single log,
+	// when submitting a batch.
+	defaultMaxConcurrentRequests = 16
+	defaultRetryDelay            = time.Second
+	defaultMaxRetryDelay         = 16 * time.Second
 )
 
 type Config struct {
@@ -44,6 +47,23 @@ type Config struct {
 
 	UserAgent string
 
+	// Delay before retrying a request that failed with a
+	// transient error, i.e., 429 Too Many Requests or a 5xx
+	// server error, unless the log specifies a Retry-After
+	// delay. The delay is doubled for each retry, up to
+	// MaxRetryDelay, and randomized. Other errors fail the
+	// submission to the log immediately. Zero implies defaults.
+	RetryDelay    time.Duration
+	MaxRetryDelay time.Duration
+
+	// Number of distinct logs the leaf must be included in, each
+	// producing a separate proof. Zero implies one log.
+	RequiredLogs int
+
+	// Maximum number of concurrent requests to a log, when
+	// submitting a batch of leaves. Zero implies a default.
+	MaxConcurrentRequests int
+
 	// The policy specifies the logs and witnesses to use.
 	Policy *policy.Policy
 
@@ -66,6 +86,71 @@ func (c *Config) getTimeout() time.Duration {
 	return c.PerLogTimeout
 }
 
+func (c *Config) getRetryDelay() time.Duration {
+	if c.RetryDelay <= 0 {
+		return defaultRetryDelay
+	}
+	return c.RetryDelay
+}
+
+func (c *Config) getMaxRetryDelay() time.Duration {
+	if c.MaxRetryDelay <= 0 {
+		return max(defaultMaxRetryDelay, c.getRetryDelay())
+	}
+	return c.MaxRetryDelay
+}
+
+// Creates a client for the given log, which retries requests that
+// fail with a transient error. If the log has mirrors, read requests
+// are distributed over the log's url and all mirrors.
+func (c *Config) newLogClient(entity *policy.Entity) api.Log {
+	var cli api.Log
+	if len(entity.MirrorURLs) > 0 {
+		cli = client.NewMulti(client.MultiConfig{
+			UserAgent:  c.getUserAgent(),
+			URLs:       []string{entity.URL},
+			MirrorURLs: entity.MirrorURLs,
+			HTTPClient: c.HTTPClient,
+			PollDelay:  c.getPollDelay(),
+		})
+	} else {
+		cli = client.New(client.Config{
+			UserAgent:  c.getUserAgent(),
+			URL:        entity.URL,
+			HTTPClient: c.HTTPClient,
+			PollDelay:  c.getPollDelay(),
+		})
+	}
+	return newRetryingLog(cli, c.getRetryDelay(), c.getMaxRetryDelay())
+}
+
+// Returns the submit token header for add-leaf requests to the given
+// log, or nil if no rate limit signer is configured.
+func (c *Config) getSubmitHeader(entity *policy.Entity) (*token.SubmitHeader, error) {
+	if c.RateLimitSigner == nil || len(c.Domain) == 0 {
+		return nil, nil
+	}
+	signature, err := token.MakeToken(c.RateLimitSigner, &entity.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("creating submit token failed: %v", err)
+	}
+	return &token.SubmitHeader{Domain: c.Domain, Token: signature}, nil
+}
+
+func (c *Config) getMaxConcurrentRequests() int {
+	if c.MaxConcurrentRequests <= 0 {
+		return defaultMaxConcurrentRequests
+	}
+	return c.MaxConcurrentRequests
+}
+
+func (c *Config) getRequiredLogs() int {
+	if c.RequiredLogs <= 0 {
+		return 1
+	}
+	return c.RequiredLogs
+}
+
 func (c *Config) getUserAgent() string {
 	if len(c.UserAgent) == 0 {
 		return defaultUserAgent
@@ -103,112 +188,29 @@ func SubmitMessage(ctx context.Context, config *Config, signer crypto.Signer, me
 	})
 }
 
+// Submits a leaf, and returns the proof from the first log where it
+// is included. If config.RequiredLogs is larger than one, the leaf
+// is still required to be included in that many logs, but the other
+// proofs are discarded; use SubmitLeafRequestMulti to get them all.
 func SubmitLeafRequest(ctx context.Context, config *Config, req *requests.Leaf) (proof.SigsumProof, error) {
-	leaf, err := req.Verify()
+	proofs, err := SubmitLeafRequestMulti(ctx, config, req)
 	if err != nil {
-		return proof.SigsumProof{}, fmt.Errorf("verifying leaf request failed: %v", err)
-	}
-	leafHash := leaf.ToHash()
-
-	logs := config.Policy.GetLogsWithUrl()
-	if len(logs) == 0 {
-		return proof.SigsumProof{}, fmt.Errorf("no logs defined in policy")
+		return proof.SigsumProof{}, err
 	}
-	for _, entity := range logs {
-		log.Info("Attempting submit to log: %s", entity.URL)
-		var header *token.SubmitHeader
-		if config.RateLimitSigner != nil && len(config.Domain) > 0 {
-			signature, err := token.MakeToken(config.RateLimitSigner, &entity.PublicKey)
-			if err != nil {
-				return proof.SigsumProof{}, fmt.Errorf("creating submit token failed: %v", err)
-			}
-			header = &token.SubmitHeader{Domain: config.Domain, Token: signature}
-		}
-
-		client := client.New(client.Config{
-			UserAgent:  config.getUserAgent(),
-			URL:        entity.URL,
-			HTTPClient: config.HTTPClient,
-		})
+	return proofs[0], nil
+}
 
-		logKeyHash := crypto.HashBytes(entity.PublicKey[:])
-		pr, err := func() (proof.SigsumProof, error) {
-			ctx, cancel := context.WithTimeout(ctx, config.getTimeout())
-			defer cancel()
-			return submitLeafToLog(ctx, config.Policy, client, &logKeyHash, header, config.sleep, req, &leafHash)
-		}()
-		if err == nil {
-			pr.Leaf = proof.NewShortLeaf(&leaf)
-			return pr, nil
-		}
-		log.Error("Submitting to log %q failed: %v", entity.URL, err)
-	}
-	return proof.SigsumProof{}, fmt.Errorf("all logs failed, giving up")
+// Submits a leaf to config.RequiredLogs distinct logs, and returns a
+// proof from each of them.
+func SubmitLeafRequestMulti(ctx context.Context, config *Config, req *requests.Leaf) (MultiProof, error) {
+	result := SubmitBatch(ctx, config, []requests.Leaf{*req})[0]
+	return result.Proofs, result.Err
 }
 
 func submitLeafToLog(ctx context.Context, policy *policy.Policy,
 	cli api.Log, logKeyHash *crypto.Hash, header *token.SubmitHeader, sleep func(context.Context) error,
 	req *requests.Leaf, leafHash *crypto.Hash) (proof.SigsumProof, error) {
-	pr := proof.SigsumProof{
-		// Note: Leaves to caller to populate proof.Leaf.
-		LogKeyHash: *logKeyHash,
-	}
-
-	for {
-		persisted, err := cli.AddLeaf(ctx, *req, header)
-
-		if err != nil {
-			return proof.SigsumProof{}, err
-		}
-		if persisted {
-			break
-		}
-		log.Debug("Leaf submitted, waiting for it to be persisted.")
-		if err := sleep(ctx); err != nil {
-			return proof.SigsumProof{}, err
-		}
-	}
-	// Leaf submitted, now get a signed tree head + inclusion proof.
-	for {
-		var err error
-		pr.TreeHead, err = cli.GetTreeHead(ctx)
-		if err != nil {
-			return proof.SigsumProof{}, err
-		}
-		if err := policy.VerifyCosignedTreeHead(&pr.LogKeyHash, &pr.TreeHead); err != nil {
-			return proof.SigsumProof{}, fmt.Errorf("verifying tree head failed: %v", err)
-		}
-
-		// See if we can have an inclusion proof for this tree size.
-		if pr.TreeHead.Size == 0 {
-			// Certainly not included yet.
-			log.Debug("Signed tree is still empty, waiting.")
-			if err := sleep(ctx); err != nil {
-				return proof.SigsumProof{}, err
-			}
-			continue
-		}
-		pr.Inclusion, err = cli.GetInclusionProof(ctx,
-			requests.InclusionProof{
-				Size:     pr.TreeHead.Size,
-				LeafHash: *leafHash,
-			})
-		if errors.Is(err, api.ErrNotFound) {
-			log.Debug("No inclusion proof yet, waiting.")
-			if err := sleep(ctx); err != nil {
-				return proof.SigsumProof{}, err
-			}
-			continue
-		}
-		if err != nil {
-			return proof.SigsumProof{}, fmt.Errorf("failed to get inclusion proof: %v", err)
-		}
-
-		// Check validity.
-		if err = pr.Inclusion.Verify(leafHash, &pr.TreeHead.TreeHead); err != nil {
-			return proof.SigsumProof{}, fmt.Errorf("inclusion proof invalid: %v", err)
-		}
-
-		return pr, nil
-	}
+	proofs, errs := submitBatchToLog(ctx, policy, cli, logKeyHash, header, sleep, 1,
+		[]requests.Leaf{*req}, []crypto.Hash{*leafHash})
+	return proofs[0], errs[0]
 }
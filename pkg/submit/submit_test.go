@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"strings"
 	"testing"
 
 	"github.com/golang/mock/gomock"
@@ -44,8 +45,12 @@ func TestSubmitSuccess(t *testing.T) {
 		msg, sth, inclusionProof, req, leaf, leafHash := prepareResponse(t, submitSigner, logSigner, &tree, i)
 		client.EXPECT().AddLeaf(gomock.Any(), req, gomock.Any()).Return(false, nil)
 		client.EXPECT().AddLeaf(gomock.Any(), req, gomock.Any()).Return(true, nil)
-		client.EXPECT().GetTreeHead(gomock.Any()).Return(
-			types.CosignedTreeHead{SignedTreeHead: sth}, nil)
+		gomock.InOrder(
+			// Empty tree, before submission.
+			client.EXPECT().GetTreeHead(gomock.Any()).Return(types.CosignedTreeHead{}, nil),
+			client.EXPECT().GetTreeHead(gomock.Any()).Return(
+				types.CosignedTreeHead{SignedTreeHead: sth}, nil),
+		)
 		client.EXPECT().GetInclusionProof(gomock.Any(), gomock.Any()).Return(inclusionProof, nil)
 		pr, err := submitLeafToLog(context.Background(), policy,
 			client, &logKeyHash, nil, func(_ context.Context) error { return nil },
@@ -108,8 +113,12 @@ func TestSubmitFailure(t *testing.T) {
 			getInclusionError = errors.New("mock error")
 		}
 		client.EXPECT().AddLeaf(gomock.Any(), req, gomock.Any()).Return(true, addError)
-		client.EXPECT().GetTreeHead(gomock.Any()).Return(
-			types.CosignedTreeHead{SignedTreeHead: sth}, getTHError).AnyTimes()
+		gomock.InOrder(
+			// Empty tree, before submission.
+			client.EXPECT().GetTreeHead(gomock.Any()).Return(types.CosignedTreeHead{}, nil),
+			client.EXPECT().GetTreeHead(gomock.Any()).Return(
+				types.CosignedTreeHead{SignedTreeHead: sth}, getTHError).AnyTimes(),
+		)
 		client.EXPECT().GetInclusionProof(gomock.Any(), gomock.Any()).Return(inclusionProof, getInclusionError).AnyTimes()
 		pr, err := submitLeafToLog(context.Background(), policy,
 			client, &logKeyHash, nil, func(_ context.Context) error { return nil },
@@ -128,6 +137,85 @@ func TestSubmitFailure(t *testing.T) {
 	}
 }
 
+func TestSubmitWaitForQuorum(t *testing.T) {
+	logPub, logSigner, err := crypto.NewKeyPair()
+	if err != nil {
+		t.Fatalf("creating log key failed: %v", err)
+	}
+	_, submitSigner, err := crypto.NewKeyPair()
+	if err != nil {
+		t.Fatalf("creating submit key failed: %v", err)
+	}
+	witnessPub, witnessSigner, err := crypto.NewKeyPair()
+	if err != nil {
+		t.Fatalf("creating witness key failed: %v", err)
+	}
+	logKeyHash := crypto.HashBytes(logPub[:])
+	witnessKeyHash := crypto.HashBytes(witnessPub[:])
+
+	policy, err := policy.NewKofNPolicy([]crypto.PublicKey{logPub}, []crypto.PublicKey{witnessPub}, 1)
+	if err != nil {
+		t.Fatalf("creating policy failed: %v", err)
+	}
+	tree := merkle.NewTree()
+	_, sth, inclusionProof, req, _, leafHash := prepareResponse(t, submitSigner, logSigner, &tree, 1)
+	cosignature, err := sth.Cosign(witnessSigner, types.SigsumCheckpointOrigin(&logPub), 0)
+	if err != nil {
+		t.Fatalf("cosigning failed: %v", err)
+	}
+	uncosigned := types.CosignedTreeHead{SignedTreeHead: sth}
+	cosigned := types.CosignedTreeHead{SignedTreeHead: sth,
+		Cosignatures: map[crypto.Hash]types.Cosignature{witnessKeyHash: cosignature}}
+
+	t.Run("quorum", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+		client := mocks.NewMockLog(ctrl)
+
+		client.EXPECT().AddLeaf(gomock.Any(), req, gomock.Any()).Return(true, nil)
+		gomock.InOrder(
+			// Including the initial lookup of included leaves.
+			client.EXPECT().GetTreeHead(gomock.Any()).Return(uncosigned, nil).Times(4),
+			client.EXPECT().GetTreeHead(gomock.Any()).Return(cosigned, nil),
+		)
+		client.EXPECT().GetInclusionProof(gomock.Any(), gomock.Any()).Return(inclusionProof, nil)
+		pr, err := submitLeafToLog(context.Background(), policy,
+			client, &logKeyHash, nil, func(_ context.Context) error { return nil },
+			&req, &leafHash)
+		if err != nil {
+			t.Fatalf("submit failed: %v", err)
+		}
+		if _, ok := pr.TreeHead.Cosignatures[witnessKeyHash]; !ok {
+			t.Errorf("proof is missing cosignature")
+		}
+	})
+	t.Run("timeout", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+		client := mocks.NewMockLog(ctrl)
+
+		client.EXPECT().AddLeaf(gomock.Any(), req, gomock.Any()).Return(true, nil)
+		// Including the initial lookup of included leaves.
+		client.EXPECT().GetTreeHead(gomock.Any()).Return(uncosigned, nil).Times(4)
+		sleeps := 0
+		_, err := submitLeafToLog(context.Background(), policy,
+			client, &logKeyHash, nil, func(_ context.Context) error {
+				sleeps++
+				if sleeps == 3 {
+					return context.DeadlineExceeded
+				}
+				return nil
+			},
+			&req, &leafHash)
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("expected timeout, got: %v", err)
+		}
+		if !strings.Contains(err.Error(), "not enough cosignatures") {
+			t.Errorf("error doesn't mention missing cosignatures: %v", err)
+		}
+	})
+}
+
 func prepareResponse(t *testing.T, submitSigner, logSigner crypto.Signer, tree *merkle.Tree, i int) (crypto.Hash, types.SignedTreeHead, types.InclusionProof, requests.Leaf, types.Leaf, crypto.Hash) {
 	msg := crypto.HashBytes([]byte{byte(i)})
 	signature, err := types.SignLeafMessage(submitSigner, msg[:])
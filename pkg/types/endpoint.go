@@ -9,12 +9,20 @@ const (
 	EndpointGetInclusionProof   = Endpoint("get-inclusion-proof/")
 	EndpointGetConsistencyProof = Endpoint("get-consistency-proof/")
 	EndpointGetLeaves           = Endpoint("get-leaves/")
+	// Optional extension, see api.RangeProver.
+	EndpointGetRangeProof = Endpoint("get-range-proof/")
 
 	// For primary/secondary replication.
 	EndpointGetSecondaryTreeHead = Endpoint("get-secondary-tree-head")
 
 	// Witness api.
 	EndpointAddCheckpoint = Endpoint("add-checkpoint")
+
+	// Static tlog-tiles api, see
+	// https://github.com/C2SP/C2SP/blob/main/tlog-tiles.md
+	EndpointCheckpoint  = Endpoint("checkpoint")
+	EndpointTile        = Endpoint("tile/")
+	EndpointEntryBundle = Endpoint("tile/entries/")
 )
 
 // Path adds endpoint name to a service prefix.  If prefix is empty, nothing is added.
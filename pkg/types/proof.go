@@ -12,6 +12,11 @@ import (
 
 const (
 	proofSizeLimit = 63
+	// A range proof includes at most one hash per level on each
+	// side of the range.
+	rangeProofSizeLimit = 2 * proofSizeLimit
+	// Maximum number of leaves covered by a multiproof.
+	multiProofLeafLimit = 4096
 )
 
 type InclusionProof struct {
@@ -23,6 +28,23 @@ type ConsistencyProof struct {
 	Path []crypto.Hash
 }
 
+// Proof of inclusion of a range of leaves, see
+// merkle.ProveRangeFromNodes. Note that the range and tree size are
+// not included on the wire.
+type RangeProof struct {
+	Path []crypto.Hash
+}
+
+// Proof of inclusion of several leaves, at arbitrary indices, in the
+// same tree, see merkle.ProveMultiFromNodes. Hashes shared between the
+// inclusion paths of the leaves are included only once. Like for
+// InclusionProof, the size is not included on the wire.
+type MultiProof struct {
+	// Strictly increasing.
+	LeafIndices []uint64
+	Path        []crypto.Hash
+}
+
 func hashesToASCII(w io.Writer, hashes []crypto.Hash) error {
 	for _, hash := range hashes {
 		if err := ascii.WriteHash(w, "node_hash", &hash); err != nil {
@@ -33,7 +55,7 @@ func hashesToASCII(w io.Writer, hashes []crypto.Hash) error {
 }
 
 // Treats empty list as an error.
-func hashesFromASCII(p *ascii.Parser) ([]crypto.Hash, error) {
+func hashesFromASCII(p *ascii.Parser, limit int) ([]crypto.Hash, error) {
 	var hashes []crypto.Hash
 	for {
 		hash, err := p.GetHash("node_hash")
@@ -47,7 +69,7 @@ func hashesFromASCII(p *ascii.Parser) ([]crypto.Hash, error) {
 		if err != nil {
 			return nil, err
 		}
-		if len(hashes) >= proofSizeLimit {
+		if len(hashes) >= limit {
 			return nil, fmt.Errorf("too many node hashes")
 		}
 		hashes = append(hashes, hash)
@@ -68,7 +90,7 @@ func (pr *InclusionProof) Parse(p ascii.Parser) error {
 	if err != nil {
 		return err
 	}
-	pr.Path, err = hashesFromASCII(&p)
+	pr.Path, err = hashesFromASCII(&p, proofSizeLimit)
 	return err
 }
 
@@ -86,7 +108,7 @@ func (pr *ConsistencyProof) ToASCII(w io.Writer) error {
 
 func (pr *ConsistencyProof) Parse(p *ascii.Parser) error {
 	var err error
-	pr.Path, err = hashesFromASCII(p)
+	pr.Path, err = hashesFromASCII(p, proofSizeLimit)
 	return err
 }
 
@@ -134,3 +156,77 @@ func (pr *ConsistencyProof) Verify(oldTree, newTree *TreeHead) error {
 	return merkle.VerifyConsistency(
 		oldTree.Size, newTree.Size, &oldTree.RootHash, &newTree.RootHash, pr.Path)
 }
+
+func (pr *RangeProof) ToASCII(w io.Writer) error {
+	return hashesToASCII(w, pr.Path)
+}
+
+func (pr *RangeProof) FromASCII(r io.Reader) error {
+	p := ascii.NewParser(r)
+	var err error
+	pr.Path, err = hashesFromASCII(&p, rangeProofSizeLimit)
+	return err
+}
+
+// Verifies that the leaves, starting at index start, are included
+// in the tree.
+func (pr *RangeProof) Verify(leaves []crypto.Hash, start uint64, th *TreeHead) error {
+	return merkle.VerifyRange(leaves, start, th.Size, &th.RootHash, pr.Path)
+}
+
+// Note the size is not included on the wire.
+func (pr *MultiProof) ToASCII(w io.Writer) error {
+	if err := ascii.WriteInt(w, "leaf_count", uint64(len(pr.LeafIndices))); err != nil {
+		return err
+	}
+	for _, index := range pr.LeafIndices {
+		if err := ascii.WriteInt(w, "leaf_index", index); err != nil {
+			return err
+		}
+	}
+	return hashesToASCII(w, pr.Path)
+}
+
+func (pr *MultiProof) FromASCII(r io.Reader) error {
+	p := ascii.NewParser(r)
+	count, err := p.GetInt("leaf_count")
+	if err != nil {
+		return err
+	}
+	if count == 0 || count > multiProofLeafLimit {
+		return fmt.Errorf("invalid leaf count %d", count)
+	}
+	pr.LeafIndices = make([]uint64, 0, count)
+	for i := uint64(0); i < count; i++ {
+		index, err := p.GetInt("leaf_index")
+		if err != nil {
+			return err
+		}
+		if i > 0 && index <= pr.LeafIndices[i-1] {
+			return fmt.Errorf("leaf indices not strictly increasing")
+		}
+		pr.LeafIndices = append(pr.LeafIndices, index)
+	}
+	// The path is empty if the proof covers all leaves of the
+	// tree. Otherwise, each leaf needs at most one hash per level.
+	pr.Path = nil
+	for {
+		hash, err := p.GetHash("node_hash")
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if len(pr.Path) >= int(count)*proofSizeLimit {
+			return fmt.Errorf("too many node hashes")
+		}
+		pr.Path = append(pr.Path, hash)
+	}
+}
+
+// Verifies that the leaves, at the indices of the proof, are included
+// in the tree.
+func (pr *MultiProof) Verify(leaves []crypto.Hash, th *TreeHead) error {
+	return merkle.VerifyMulti(leaves, pr.LeafIndices, th.Size, &th.RootHash, pr.Path)
+}
@@ -11,6 +11,7 @@ import (
 
 	"sigsum.org/sigsum-go/pkg/ascii"
 	"sigsum.org/sigsum-go/pkg/crypto"
+	"sigsum.org/sigsum-go/pkg/merkle"
 )
 
 func TestInclusionProofToASCII(t *testing.T) {
@@ -101,6 +102,94 @@ func TestConsistencyProofFromASCII(t *testing.T) {
 	}
 }
 
+func TestRangeProofFromASCII(t *testing.T) {
+	hashLines := func(n int) string {
+		return strings.Repeat(fmt.Sprintf("node_hash=%x\n", crypto.Hash{}), n)
+	}
+	for _, table := range []struct {
+		desc       string
+		serialized string
+		wantLen    int
+	}{
+		{"empty", "", 0},
+		{"longer than an inclusion path", hashLines(100), 100},
+		{"maximum length", hashLines(126), 126},
+		{"too long", hashLines(127), 0},
+	} {
+		var proof RangeProof
+		err := proof.FromASCII(bytes.NewBufferString(table.serialized))
+		if table.wantLen == 0 {
+			if err == nil {
+				t.Errorf("test %q: expected error", table.desc)
+			}
+		} else if err != nil {
+			t.Errorf("test %q failed: %v", table.desc, err)
+		} else if got := len(proof.Path); got != table.wantLen {
+			t.Errorf("test %q: got %d hashes, want %d", table.desc, got, table.wantLen)
+		}
+	}
+}
+
+func TestMultiProofASCII(t *testing.T) {
+	tree := merkle.NewTree()
+	var leaves []crypto.Hash
+	for i := 0; i < 20; i++ {
+		leaves = append(leaves, crypto.Hash{byte(i)})
+		tree.AddLeafHash(&leaves[i])
+	}
+	th := TreeHead{Size: tree.Size(), RootHash: tree.GetRootHash()}
+	for _, indices := range [][]uint64{{7}, {0, 3, 4, 19}, {2, 3, 5, 8, 13}} {
+		path, err := tree.ProveMulti(indices, th.Size)
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf := bytes.Buffer{}
+		if err := (&MultiProof{LeafIndices: indices, Path: path}).ToASCII(&buf); err != nil {
+			t.Fatal(err)
+		}
+		var proof MultiProof
+		if err := proof.FromASCII(&buf); err != nil {
+			t.Fatalf("FromASCII failed for indices %v: %v", indices, err)
+		}
+		if !slices.Equal(proof.LeafIndices, indices) || !slices.Equal(proof.Path, path) {
+			t.Errorf("unexpected proof for indices %v, got %v", indices, proof)
+		}
+		var selected []crypto.Hash
+		for _, i := range indices {
+			selected = append(selected, leaves[i])
+		}
+		if err := proof.Verify(selected, &th); err != nil {
+			t.Errorf("Verify failed for indices %v: %v", indices, err)
+		}
+	}
+
+	hashLines := func(n int) string {
+		return strings.Repeat(fmt.Sprintf("node_hash=%x\n", crypto.Hash{}), n)
+	}
+	for _, table := range []struct {
+		desc       string
+		serialized string
+		wantErr    bool
+	}{
+		{"valid", "leaf_count=2\nleaf_index=1\nleaf_index=5\n" + hashLines(3), false},
+		{"valid, empty path", "leaf_count=1\nleaf_index=0\n", false},
+		{"empty", "", true},
+		{"zero leaves", "leaf_count=0\n" + hashLines(1), true},
+		{"too many leaves", "leaf_count=4097\nleaf_index=1\n", true},
+		{"missing index", "leaf_count=2\nleaf_index=1\n" + hashLines(1), true},
+		{"decreasing indices", "leaf_count=2\nleaf_index=5\nleaf_index=1\n", true},
+		{"duplicate indices", "leaf_count=2\nleaf_index=5\nleaf_index=5\n", true},
+		{"maximum length", "leaf_count=1\nleaf_index=0\n" + hashLines(63), false},
+		{"too long", "leaf_count=1\nleaf_index=0\n" + hashLines(64), true},
+	} {
+		var proof MultiProof
+		err := proof.FromASCII(bytes.NewBufferString(table.serialized))
+		if got, want := err != nil, table.wantErr; got != want {
+			t.Errorf("got error %v but wanted %v in test %q: %v", got, want, table.desc, err)
+		}
+	}
+}
+
 func TestConsistencyProofToBase64(t *testing.T) {
 	expBase64 := []string{
 		"BAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=",
@@ -0,0 +1,89 @@
+package types
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"sigsum.org/sigsum-go/pkg/crypto"
+)
+
+const (
+	// Each tile represents TileHeight levels of the tree, and
+	// holds at most TileWidth hashes, or entries.
+	TileHeight = 8
+	TileWidth  = 1 << TileHeight
+)
+
+// Writes the hashes of a tile, concatenated.
+func TileHashesToBinary(w io.Writer, hashes []crypto.Hash) error {
+	for _, hash := range hashes {
+		if _, err := w.Write(hash[:]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Reads the hashes of a tile, which must be of the given width.
+func TileHashesFromBinary(r io.Reader, width uint64) ([]crypto.Hash, error) {
+	data, err := io.ReadAll(io.LimitReader(r, TileWidth*crypto.HashSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if got, want := uint64(len(data)), width*crypto.HashSize; got != want {
+		return nil, fmt.Errorf("unexpected tile size %d, expected %d", got, want)
+	}
+	hashes := make([]crypto.Hash, width)
+	for i := range hashes {
+		copy(hashes[i][:], data[i*crypto.HashSize:])
+	}
+	return hashes, nil
+}
+
+// Writes leaves as an entry bundle, where each entry is the binary
+// leaf, with a two-octet length prefix.
+func LeavesToEntryBundle(w io.Writer, leaves []Leaf) error {
+	for _, leaf := range leaves {
+		b := leaf.ToBinary()
+		if err := binary.Write(w, binary.BigEndian, uint16(len(b))); err != nil {
+			return err
+		}
+		if _, err := w.Write(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Reads an entry bundle, which must hold exactly width leaves.
+func LeavesFromEntryBundle(r io.Reader, width uint64) ([]Leaf, error) {
+	data, err := io.ReadAll(io.LimitReader(r, TileWidth*(2+128)+1))
+	if err != nil {
+		return nil, err
+	}
+	var leaves []Leaf
+	for len(data) > 0 {
+		if len(data) < 2 {
+			return nil, fmt.Errorf("truncated entry bundle")
+		}
+		size := int(binary.BigEndian.Uint16(data))
+		data = data[2:]
+		if len(data) < size {
+			return nil, fmt.Errorf("truncated entry bundle")
+		}
+		if uint64(len(leaves)) >= width {
+			return nil, fmt.Errorf("too many entries, expected %d", width)
+		}
+		var leaf Leaf
+		if err := leaf.FromBinary(data[:size]); err != nil {
+			return nil, err
+		}
+		leaves = append(leaves, leaf)
+		data = data[size:]
+	}
+	if got := uint64(len(leaves)); got != width {
+		return nil, fmt.Errorf("unexpected number of entries %d, expected %d", got, width)
+	}
+	return leaves, nil
+}